@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/storage/db"
+	"github.com/lookeme/short-url/internal/storage/sqlite"
+)
+
+// migrateUsage describes the arguments of the migrate command.
+const migrateUsage = "usage: shortener [flags] migrate up|down|status|redo|to VERSION"
+
+// runMigrate runs a migration command on the database of the configured storage and returns.
+// The service itself is not started, so production can apply migrations as a separate step
+// and start the service with -migrate=false.
+func runMigrate(ctx context.Context, cfg *configuration.Storage, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	var (
+		conn *sql.DB
+		err  error
+	)
+	switch cfg.StorageType() {
+	case configuration.StoragePostgres:
+		conn, err = db.OpenDB(cfg)
+	case configuration.StorageSQLite:
+		conn, err = sqlite.OpenDB(cfg)
+	default:
+		return fmt.Errorf("storage type %q has no migrations, set a database connection string with -d", cfg.StorageType())
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := db.RunMigrations(ctx, conn, cfg.StorageType(), args[0], args[1:]...); err != nil {
+		return fmt.Errorf("%w\n%s", err, migrateUsage)
+	}
+	return nil
+}
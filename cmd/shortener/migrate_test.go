@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pressly/goose/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/storage/sqlite"
+)
+
+func TestRunMigrate(t *testing.T) {
+	ctx := context.Background()
+	cfg := &configuration.Storage{ConnString: configuration.SQLitePrefix + filepath.Join(t.TempDir(), "short-url.db")}
+	version := func() int64 {
+		t.Helper()
+		conn, err := sqlite.OpenDB(cfg)
+		require.NoError(t, err)
+		defer conn.Close()
+		v, err := goose.GetDBVersionContext(ctx, conn)
+		require.NoError(t, err)
+		return v
+	}
+
+	require.NoError(t, runMigrate(ctx, cfg, []string{"up"}))
+	latest := version()
+	assert.Equal(t, int64(20261019140000), latest)
+	require.NoError(t, runMigrate(ctx, cfg, []string{"status"}))
+
+	require.NoError(t, runMigrate(ctx, cfg, []string{"down"}))
+	assert.Equal(t, int64(20261019130000), version())
+	require.NoError(t, runMigrate(ctx, cfg, []string{"redo"}))
+	assert.Equal(t, int64(20261019130000), version())
+
+	require.NoError(t, runMigrate(ctx, cfg, []string{"to", "0"}))
+	assert.Equal(t, int64(0), version(), "every migration can be rolled back")
+	require.NoError(t, runMigrate(ctx, cfg, []string{"to", "20240520151633"}))
+	assert.Equal(t, int64(20240520151633), version())
+	require.NoError(t, runMigrate(ctx, cfg, []string{"up"}))
+	assert.Equal(t, latest, version())
+
+	assert.Error(t, runMigrate(ctx, cfg, nil))
+	assert.Error(t, runMigrate(ctx, cfg, []string{"sideways"}))
+	assert.Error(t, runMigrate(ctx, cfg, []string{"to", "latest"}))
+	assert.Error(t, runMigrate(ctx, &configuration.Storage{Type: configuration.StorageMemory}, []string{"up"}))
+}
+
+func TestCreateStorageWithoutMigrations(t *testing.T) {
+	cfg := &configuration.Storage{
+		ConnString:     configuration.SQLitePrefix + filepath.Join(t.TempDir(), "short-url.db"),
+		SkipMigrations: true,
+	}
+	storage, err := createStorage(context.Background(), &logger.Logger{Log: zap.NewNop()}, cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+	defer storage.Close()
+	conn, err := sqlite.OpenDB(cfg)
+	require.NoError(t, err)
+	defer conn.Close()
+	var tables int
+	require.NoError(t, conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'short'`).Scan(&tables))
+	assert.Zero(t, tables)
+
+	require.NoError(t, runMigrate(context.Background(), cfg, []string{"up"}))
+	require.NoError(t, conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'short'`).Scan(&tables))
+	assert.Equal(t, 1, tables)
+}
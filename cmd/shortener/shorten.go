@@ -8,8 +8,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/lookeme/short-url/internal/app/domain/user"
 	"github.com/lookeme/short-url/internal/security"
@@ -17,11 +22,19 @@ import (
 	"github.com/lookeme/short-url/internal/app/domain/shorten"
 	"github.com/lookeme/short-url/internal/compression"
 	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/health"
 	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/metrics"
 	"github.com/lookeme/short-url/internal/server/handler"
 	"github.com/lookeme/short-url/internal/server/http"
+	"github.com/lookeme/short-url/internal/storage/bolt"
+	"github.com/lookeme/short-url/internal/storage/cache"
 	"github.com/lookeme/short-url/internal/storage/db"
 	"github.com/lookeme/short-url/internal/storage/inmemory"
+	"github.com/lookeme/short-url/internal/storage/sqlite"
+	"github.com/lookeme/short-url/internal/storage/traced"
+	"github.com/lookeme/short-url/internal/tracing"
+	"go.uber.org/zap"
 )
 
 // buildVersion represents the version number of the software build.
@@ -37,10 +50,20 @@ var (
 // If something fails during setup or execution, the program will log a Fatal error message.
 func main() {
 	cfg := configuration.New()
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	fmt.Printf("Build version: %s\n", buildVersion)
 	fmt.Printf("Build date: %s\n", buildDate)
 	fmt.Printf("Build commit: %s\n", buildCommit)
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("unknown command %q", args[0])
+		}
+		if err := runMigrate(ctx, cfg.Storage, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if err := run(ctx, cfg); err != nil {
 		log.Fatal(err)
 	}
@@ -51,47 +74,163 @@ func run(ctx context.Context, cfg *configuration.Config) error {
 	if err != nil {
 		return err
 	}
-	storage, err := createStorage(ctx, zlogger, cfg.Storage)
+	defer zlogger.Close()
+	if access := zlogger.AccessLog(); access != nil {
+		metrics.Default.NewFunc("shortener_access_log_dropped_total", "Number of access log lines dropped because the writer fell behind.", true,
+			nil, func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(access.Dropped())}}
+			})
+	}
+	exporter, err := tracing.NewExporter(cfg.Tracing, zlogger)
 	if err != nil {
 		return err
 	}
-	urlService := shorten.NewURLService(storage.ShortenRepository, zlogger, cfg)
-	userService := user.NewUserService(storage.UserRepository, zlogger)
-	urlHandler := handler.NewURLHandler(&urlService, &userService)
-	authService := security.New(&userService, zlogger)
-	var gzip compression.Compressor
-	server := http.NewServer(urlHandler, cfg.Network, zlogger, &gzip, authService)
+	tracing.Default.SetExporter(exporter)
+	// ctx is done once the shutdown starts, the queued spans are still flushed
+	defer tracing.Default.Shutdown(context.WithoutCancel(ctx))
+	// the background goroutines, such as the compaction, the purge and the internal listeners,
+	// are stopped with the server, or when it fails to start, and waited for before the storage is closed
+	backgroundCtx, stopBackground := context.WithCancel(ctx)
+	var background sync.WaitGroup
+	storage, err := createStorage(backgroundCtx, zlogger, cfg.Storage, &background)
+	if err != nil {
+		stopBackground()
+		return err
+	}
+	// registered before waiting for the background goroutines, so it runs once they are stopped:
+	// neither a compaction, a purge nor the gauges of the internal listeners use a closed storage
 	defer func(storage *db.Storage) {
+		if cached, ok := storage.ShortenRepository.(*cache.Repository); ok {
+			stats := cached.Stats()
+			zlogger.Log.Info("redirect cache stats",
+				zap.Uint64("hits", stats.Hits), zap.Uint64("misses", stats.Misses), zap.Int("size", stats.Size))
+		}
 		err := storage.Close()
 		if err != nil {
 			fmt.Printf("error during closing storage %s", err)
 		}
 	}(storage)
-	return server.Serve()
+	defer background.Wait()
+	defer stopBackground()
+	urlService := shorten.NewURLService(storage.ShortenRepository, zlogger, cfg)
+	if cfg.Storage.PurgeInterval > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			urlService.RunPurge(backgroundCtx, cfg.Storage.PurgeInterval)
+		}()
+	}
+	userService := user.NewUserService(storage.UserRepository, zlogger)
+	urlHandler := handler.NewURLHandler(&urlService, &userService)
+	authService := security.New(&userService, zlogger)
+	checker := health.New(cfg.Network.HealthCheckTimeout)
+	checker.Register("storage", urlService.Ping)
+	switch cfg.Storage.StorageType() {
+	case configuration.StorageMemory:
+		checker.Register("storage_file", health.FileWritable(cfg.Storage.FileStoragePath))
+	case configuration.StorageBolt:
+		checker.Register("storage_file", health.FileWritable(cfg.Storage.BoltFilePath))
+	}
+	compressor := compression.New(cfg.Network)
+	server := http.NewServer(urlHandler, cfg.Network, zlogger, compressor, authService, checker)
+	if cfg.Network.MetricsAddress != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := server.ServeMetrics(backgroundCtx); err != nil {
+				zlogger.Log.Error("error during serving metrics", zap.Error(err))
+			}
+		}()
+	}
+	if cfg.Network.AdminAddress != "" {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := server.ServeAdmin(backgroundCtx); err != nil {
+				zlogger.Log.Error("error during serving admin endpoints", zap.Error(err))
+			}
+		}()
+	}
+	return server.Serve(ctx)
 }
 
-func createStorage(ctx context.Context, log *logger.Logger, cfg *configuration.Storage) (*db.Storage, error) {
+// createStorage opens the storage of the configured type. The goroutines it starts in the background,
+// such as the compaction of the in-memory storage, run until ctx is done and are tracked by background.
+func createStorage(ctx context.Context, log *logger.Logger, cfg *configuration.Storage, background *sync.WaitGroup) (*db.Storage, error) {
 	var storage *db.Storage
-	if len(cfg.ConnString) == 0 {
+	switch cfg.StorageType() {
+	case configuration.StorageBolt:
+		kv, err := bolt.New(log, cfg)
+		if err != nil {
+			return storage, err
+		}
+		storage = db.NewStorage(bolt.NewUserRepository(kv), bolt.NewShortenRepository(kv))
+	case configuration.StorageSQLite:
+		sq, err := sqlite.New(log, cfg)
+		if err != nil {
+			return storage, err
+		}
+		storage = db.NewStorage(sqlite.NewUserRepository(sq), sqlite.NewShortenRepository(sq))
+	case configuration.StorageMemory:
 		shortenStore, err := inmemory.NewInMemShortenStorage(cfg, log)
 		if err != nil {
-			if err := shortenStore.RecoverFromFile(); err != nil {
-				return storage, err
-			}
+			return storage, err
 		}
-		userStore, err := inmemory.NewInMemUserStorage(log)
+		if err := shortenStore.RecoverFromFile(); err != nil {
+			shortenStore.Close()
+			return storage, err
+		}
+		userStore, err := inmemory.NewInMemUserStorage(cfg, log)
 		if err != nil {
+			shortenStore.Close()
 			return storage, err
 		}
+		if err := userStore.RecoverFromFile(); err != nil {
+			shortenStore.Close()
+			userStore.Close()
+			return storage, err
+		}
+		userStore.ReserveIDs(shortenStore.MaxUserID())
+		if cfg.CompactInterval > 0 {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				shortenStore.RunCompaction(ctx, cfg.CompactInterval)
+			}()
+		}
+		metrics.Default.NewGaugeFunc("shortener_inmemory_urls", "Number of short URLs kept in memory, including deleted ones.",
+			func() float64 { return float64(shortenStore.Len()) })
 		storage = db.NewStorage(userStore, shortenStore)
-	} else {
+	case configuration.StoragePostgres:
 		postgres, err := db.New(ctx, log, cfg)
 		if err != nil {
 			return storage, err
 		}
+		metrics.Default.RegisterPgxPools(postgres.PoolStats)
 		shortenStorage := db.NewShortenRepository(postgres)
 		userStorage := db.NewUserRepository(postgres)
 		storage = db.NewStorage(userStorage, shortenStorage)
+	default:
+		return storage, fmt.Errorf("unsupported storage type %q", cfg.StorageType())
+	}
+	if tracing.Default.Enabled() {
+		storage.ShortenRepository = traced.NewShortenRepository(storage.ShortenRepository, tracing.Default, cfg.StorageType())
+		storage.UserRepository = traced.NewUserRepository(storage.UserRepository, tracing.Default, cfg.StorageType())
+	}
+	// the in-memory storage answers lookups from its maps already
+	if cfg.CacheSize > 0 && cfg.StorageType() != configuration.StorageMemory {
+		cached := cache.New(storage.ShortenRepository, cfg.CacheSize, cfg.CacheTTL)
+		metrics.Default.NewFunc("shortener_cache_lookups_total", "Number of redirect lookups by cache result.", true,
+			[]string{"result"}, func() []metrics.Sample {
+				stats := cached.Stats()
+				return []metrics.Sample{
+					{LabelValues: []string{"hit"}, Value: float64(stats.Hits)},
+					{LabelValues: []string{"miss"}, Value: float64(stats.Misses)},
+				}
+			})
+		metrics.Default.NewGaugeFunc("shortener_cache_entries", "Number of cached redirect lookups.",
+			func() float64 { return float64(cached.Stats().Size) })
+		storage.ShortenRepository = cached
 	}
 	return storage, nil
 }
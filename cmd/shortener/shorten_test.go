@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/storage/inmemory"
+)
+
+// TestCreateStorageRestart simulates several restarts of the service with the file storage
+// and checks that the data is loaded on every start without being appended to the file again.
+func TestCreateStorageRestart(t *testing.T) {
+	ctx := context.Background()
+	log := &logger.Logger{Log: zap.NewNop()}
+	cfg := &configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	var background sync.WaitGroup
+
+	storage, err := createStorage(ctx, log, cfg, &background)
+	require.NoError(t, err)
+	require.NoError(t, storage.ShortenRepository.Save(ctx, "http://localhost/a", "https://a.example", 7))
+	require.NoError(t, storage.ShortenRepository.Save(ctx, "http://localhost/b", "https://b.example", 8))
+	require.True(t, storage.ShortenRepository.DeleteByShortURL(ctx, "http://localhost/b", 8))
+	require.NoError(t, storage.Close())
+	info, err := os.Stat(cfg.FileStoragePath)
+	require.NoError(t, err)
+	size := info.Size()
+
+	for i := 0; i < 3; i++ {
+		storage, err = createStorage(ctx, log, cfg, &background)
+		require.NoError(t, err)
+
+		a, err := storage.ShortenRepository.FindByKey(ctx, "http://localhost/a")
+		require.NoError(t, err)
+		assert.Equal(t, "https://a.example", a.OriginalURL)
+		assert.Equal(t, 7, a.UserID)
+		assert.False(t, a.DeletedFlag)
+
+		b, err := storage.ShortenRepository.FindByKey(ctx, "http://localhost/b")
+		require.NoError(t, err)
+		assert.Equal(t, 8, b.UserID)
+		assert.True(t, b.DeletedFlag)
+		require.NoError(t, storage.Close())
+
+		info, err = os.Stat(cfg.FileStoragePath)
+		require.NoError(t, err)
+		assert.Equal(t, size, info.Size(), "file must not grow on restart")
+	}
+
+	storage, err = createStorage(ctx, log, cfg, &background)
+	require.NoError(t, err)
+	defer storage.Close()
+	require.NoError(t, storage.ShortenRepository.Save(ctx, "http://localhost/c", "https://c.example", 7))
+	c, err := storage.ShortenRepository.FindByKey(ctx, "http://localhost/c")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), c.ID)
+}
+
+// TestCreateStorageRestartUsers checks that users and the user ID sequence survive restarts,
+// and that new users never get the IDs of the owners of already stored URLs.
+func TestCreateStorageRestartUsers(t *testing.T) {
+	ctx := context.Background()
+	log := &logger.Logger{Log: zap.NewNop()}
+	cfg := &configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	var background sync.WaitGroup
+
+	storage, err := createStorage(ctx, log, cfg, &background)
+	require.NoError(t, err)
+	first, err := storage.UserRepository.SaveUser(ctx, "first", "hash")
+	require.NoError(t, err)
+	second, err := storage.UserRepository.SaveUser(ctx, "second", "hash")
+	require.NoError(t, err)
+	require.NoError(t, storage.ShortenRepository.Save(ctx, "http://localhost/a", "https://a.example", second))
+	require.NoError(t, storage.Close())
+
+	storage, err = createStorage(ctx, log, cfg, &background)
+	require.NoError(t, err)
+	user, err := storage.UserRepository.FindByID(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "first", user.Name)
+	third, err := storage.UserRepository.SaveUser(ctx, "third", "hash")
+	require.NoError(t, err)
+	assert.Greater(t, third, second)
+	require.NoError(t, storage.Close())
+
+	require.NoError(t, os.Remove(inmemory.UserStoragePath(cfg.FileStoragePath)))
+	storage, err = createStorage(ctx, log, cfg, &background)
+	require.NoError(t, err)
+	defer storage.Close()
+	fourth, err := storage.UserRepository.SaveUser(ctx, "fourth", "hash")
+	require.NoError(t, err)
+	assert.Greater(t, fourth, second)
+}
+
+// TestCreateStorageTracksCompaction checks that the compaction is tracked, so it can be waited for
+// before the storage is closed.
+func TestCreateStorageTracksCompaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json"), CompactInterval: time.Millisecond}
+	var background sync.WaitGroup
+	storage, err := createStorage(ctx, &logger.Logger{Log: zap.NewNop()}, cfg, &background)
+	require.NoError(t, err)
+	require.NoError(t, storage.ShortenRepository.Save(ctx, "http://localhost/a", "https://a.example", 7))
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	background.Wait()
+	require.NoError(t, storage.Close())
+	storage, err = createStorage(context.Background(), &logger.Logger{Log: zap.NewNop()}, &configuration.Storage{FileStoragePath: cfg.FileStoragePath}, &background)
+	require.NoError(t, err)
+	defer storage.Close()
+	a, err := storage.ShortenRepository.FindByKey(context.Background(), "http://localhost/a")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example", a.OriginalURL)
+}
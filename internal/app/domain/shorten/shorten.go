@@ -2,16 +2,39 @@ package shorten
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/lookeme/short-url/internal/configuration"
 	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/metrics"
 	"github.com/lookeme/short-url/internal/models"
 	"github.com/lookeme/short-url/internal/storage"
 	"github.com/lookeme/short-url/internal/utils"
 	"go.uber.org/zap"
 )
 
+// ErrNotOwner is returned when a user tries to modify a shortened URL created by another user.
+var ErrNotOwner = errors.New("url belongs to another user")
+
+// ErrInvalidURL is returned when a new destination of a shortened URL is not an absolute URL.
+var ErrInvalidURL = errors.New("invalid original url")
+
+// ErrBatchTooLarge is returned when a batch carries more URLs than the configured maximum.
+var ErrBatchTooLarge = errors.New("too many urls in a batch")
+
+var (
+	// urlsCreated counts the shortened URLs created one by one or in batches.
+	urlsCreated = metrics.Default.NewCounterVec("shortener_urls_created_total", "Number of shortened URLs created.", "source")
+	// deletesQueued counts the short URLs requested for deletion.
+	deletesQueued = metrics.Default.NewCounter("shortener_deletes_queued_total", "Number of short URLs queued for deletion.")
+)
+
 // URLService is a type that provides
 type URLService struct {
 	shortenRepository storage.ShortenRepository
@@ -29,22 +52,41 @@ func NewURLService(repository storage.ShortenRepository, log *logger.Logger, cfg
 	}
 }
 
-// handle error
-func (s *URLService) CreateAndSave(originURL string, userID int) (string, error) {
+// CreateAndSave generates a short URL for originURL, saves it on behalf of the user and attaches the given tags.
+// The URL and its tags are saved with a single repository call, so a URL is never stored without its tags.
+// It returns the created short URL.
+func (s *URLService) CreateAndSave(ctx context.Context, originURL string, userID int, tags ...string) (string, error) {
 	token := utils.NewShortToken(7)
 	key := token.Get()
-	if err := s.shortenRepository.Save(utils.CreateShortURL(key, s.cfg.Network.BaseURL), originURL, userID); err != nil {
+	shortURL := utils.CreateShortURL(key, s.cfg.Network.BaseURL)
+	var err error
+	if tags = normalizeTags(tags); len(tags) > 0 {
+		err = s.shortenRepository.SaveAll(ctx, []models.ShortenData{{
+			OriginalURL: originURL,
+			ShortURL:    shortURL,
+			UserID:      userID,
+			Tags:        tags,
+		}})
+	} else {
+		err = s.shortenRepository.Save(ctx, shortURL, originURL, userID)
+	}
+	if err != nil {
 		return "", err
 	}
-	return utils.CreateShortURL(key, s.cfg.Network.BaseURL), nil
+	urlsCreated.Inc("single")
+	return shortURL, nil
 }
 
 // CreateAndSaveBatch takes a slice of BatchRequest and creates and saves a batch of ShortenData objects.
 // It generates a short token for each URL and creates a ShortenData object with the original URL and the short URL.
 // The correlation ID from each BatchRequest is copied to the corresponding ShortenData object.
 // The generated ShortenData objects are then saved using the shortenRepository's SaveAll method.
+// Every ShortenData object is owned by the user with the given userID.
 // Finally, it creates a slice of BatchResponse objects with the correlation ID and short URL from each
-func (s *URLService) CreateAndSaveBatch(urls []models.BatchRequest) ([]models.BatchResponse, error) {
+func (s *URLService) CreateAndSaveBatch(ctx context.Context, urls []models.BatchRequest, userID int) ([]models.BatchResponse, error) {
+	if err := s.checkBatchSize(len(urls)); err != nil {
+		return nil, err
+	}
 	var dataToSave []models.ShortenData
 	for _, url := range urls {
 		token := utils.NewShortToken(7)
@@ -52,14 +94,17 @@ func (s *URLService) CreateAndSaveBatch(urls []models.BatchRequest) ([]models.Ba
 		shorten := models.ShortenData{
 			OriginalURL: url.OriginalURL,
 			ShortURL:    utils.CreateShortURL(key, s.cfg.Network.BaseURL),
+			UserID:      userID,
 		}
 		shorten.CorrelationID = url.CorrelationID
+		shorten.Tags = normalizeTags(url.Tags)
 		dataToSave = append(dataToSave, shorten)
 	}
-	err := s.shortenRepository.SaveAll(dataToSave)
+	err := s.shortenRepository.SaveAll(ctx, dataToSave)
 	if err != nil {
 		return nil, err
 	}
+	urlsCreated.Add(float64(len(dataToSave)), "batch")
 	var result []models.BatchResponse
 	for _, shorten := range dataToSave {
 		r := models.BatchResponse{
@@ -73,8 +118,8 @@ func (s *URLService) CreateAndSaveBatch(urls []models.BatchRequest) ([]models.Ba
 
 // FindByURL searches for a ShortenData object in the shortenRepository based on the given key.
 // If the object is found, it returns the ShortenData object and true. Otherwise, it returns an empty ShortenData object and false.
-func (s *URLService) FindByURL(key string) (models.ShortenData, bool) {
-	shorten, ok := s.shortenRepository.FindByURL(key)
+func (s *URLService) FindByURL(ctx context.Context, key string) (models.ShortenData, bool) {
+	shorten, ok := s.shortenRepository.FindByURL(ctx, key)
 	if !ok {
 		return models.ShortenData{}, false
 	}
@@ -84,29 +129,26 @@ func (s *URLService) FindByURL(key string) (models.ShortenData, bool) {
 // FindByURLs retrieves a batch of ShortenData objects from the shortenRepository based on the specified URLs.
 // It extracts the original URLs from the batch request and uses them as keys to query the repository.
 // The method returns the matching ShortenData objects and any error that occurred during the query.
-func (s *URLService) FindByURLs(urls []models.BatchRequest) ([]models.ShortenData, error) {
+func (s *URLService) FindByURLs(ctx context.Context, urls []models.BatchRequest) ([]models.ShortenData, error) {
 	var keys []string
 	for _, url := range urls {
 		keys = append(keys, url.OriginalURL)
 	}
-	return s.shortenRepository.FindByURLs(keys)
+	return s.shortenRepository.FindByURLs(ctx, keys)
 }
 
 // FindByKey finds the shorten data with the given key in the URLService.
 // It creates the short URL using the key and the base URL from the configuration.
-func (s *URLService) FindByKey(key string) (models.ShortenData, bool) {
+// It returns storage.ErrNotFound if there is no such short URL, or the error of the storage if the lookup failed.
+func (s *URLService) FindByKey(ctx context.Context, key string) (models.ShortenData, error) {
 	shortURL := utils.CreateShortURL(key, s.cfg.Network.BaseURL)
-	shorten, ok := s.shortenRepository.FindByKey(shortURL)
-	if !ok {
-		return models.ShortenData{}, false
-	}
-	return shorten, ok
+	return s.shortenRepository.FindByKey(ctx, shortURL)
 }
 
 // FindAll retrieves all shorten data from the repository.
 // It returns a slice of ShortenData and an error if any.
-func (s *URLService) FindAll() ([]models.ShortenData, error) {
-	result, err := s.shortenRepository.FindAll()
+func (s *URLService) FindAll(ctx context.Context) ([]models.ShortenData, error) {
+	result, err := s.shortenRepository.FindAll(ctx)
 	if err != nil {
 		return result, err
 	}
@@ -115,23 +157,95 @@ func (s *URLService) FindAll() ([]models.ShortenData, error) {
 
 // FindAllByUserID retrieves all shorten data associated with a specific user by their userID.
 // It returns a slice of models.ShortenData and an error.
-func (s *URLService) FindAllByUserID(userID int) ([]models.ShortenData, error) {
-	result, err := s.shortenRepository.FindAllByUserID(userID)
+func (s *URLService) FindAllByUserID(ctx context.Context, userID int) ([]models.ShortenData, error) {
+	result, err := s.shortenRepository.FindAllByUserID(ctx, userID)
 	if err != nil {
 		return result, err
 	}
 	return result, nil
 }
 
+// FindAllByUserIDAndTag retrieves all shorten data of the user marked with the given tag.
+func (s *URLService) FindAllByUserIDAndTag(ctx context.Context, userID int, tag string) ([]models.ShortenData, error) {
+	return s.shortenRepository.FindAllByUserIDAndTag(ctx, userID, normalizeTag(tag))
+}
+
+// TagsByUserID returns the tags used by the user along with the number of URLs marked with each of them.
+func (s *URLService) TagsByUserID(ctx context.Context, userID int) ([]models.TagCount, error) {
+	return s.shortenRepository.CountTagsByUserID(ctx, userID)
+}
+
+// UpdateURL applies the given partial update to the shortened URL identified by key.
+// A new destination must be an absolute URL, otherwise ErrInvalidURL is returned.
+// Only the owner of the URL is allowed to update it, otherwise ErrNotOwner is returned;
+// userID must therefore be the user verified by the auth middleware, never one taken from the request as sent.
+// If the URL doesn't exist, storage.ErrNotFound is returned.
+func (s *URLService) UpdateURL(ctx context.Context, userID int, key string, update models.UpdateRequest) (models.ShortenData, error) {
+	shortURL := utils.CreateShortURL(key, s.cfg.Network.BaseURL)
+	data, err := s.shortenRepository.FindByKey(ctx, shortURL)
+	if err != nil {
+		return models.ShortenData{}, err
+	}
+	if data.DeletedFlag {
+		return models.ShortenData{}, storage.ErrNotFound
+	}
+	if data.UserID != userID {
+		return models.ShortenData{}, ErrNotOwner
+	}
+	if update.OriginalURL != nil {
+		u, err := url.ParseRequestURI(*update.OriginalURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return models.ShortenData{}, ErrInvalidURL
+		}
+		if err := s.shortenRepository.Update(ctx, shortURL, *update.OriginalURL, userID); err != nil {
+			return models.ShortenData{}, err
+		}
+		data.OriginalURL = *update.OriginalURL
+	}
+	if update.Tags != nil {
+		tags := normalizeTags(*update.Tags)
+		if err := s.shortenRepository.SaveTags(ctx, shortURL, tags); err != nil {
+			return models.ShortenData{}, err
+		}
+		data.Tags = tags
+	}
+	return data, nil
+}
+
+// normalizeTags trims and lowercases the tags, drops empty ones and duplicates and sorts the rest.
+func normalizeTags(tags []string) []string {
+	var result []string
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if _, ok := seen[tag]; ok || tag == "" {
+			continue
+		}
+		seen[tag] = struct{}{}
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// normalizeTag brings a single tag to its canonical form.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
 // Ping is a method of the URLService struct that is used to ping the service and check if it is available.
-// It takes a context.Context object as a parameter, but it is not used in the implementation.
-// It returns an error if there is an error during
-func (s *URLService) Ping(_ context.Context) error {
-	return nil
+// It returns the error of the storage if it can't serve requests, e.g. because the database is unreachable.
+func (s *URLService) Ping(ctx context.Context) error {
+	return s.shortenRepository.Ping(ctx)
 }
 
-// DeleteByShortURLs deletes URLs based on the provided shortURLs.
-func (s *URLService) DeleteByShortURLs(shortURLs []string) error {
+// DeleteByShortURLs deletes URLs of the user based on the provided shortURLs, URLs of other users are left intact.
+// It returns ErrBatchTooLarge without deleting anything if there are more of them than a batch may carry.
+func (s *URLService) DeleteByShortURLs(ctx context.Context, userID int, shortURLs []string) error {
+	if err := s.checkBatchSize(len(shortURLs)); err != nil {
+		return err
+	}
+	deletesQueued.Add(float64(len(shortURLs)))
 	results := make(chan bool)
 	var wg sync.WaitGroup
 
@@ -140,7 +254,7 @@ func (s *URLService) DeleteByShortURLs(shortURLs []string) error {
 		url := val
 		go func() {
 			defer wg.Done()
-			results <- s.shortenRepository.DeleteByShortURL(utils.CreateShortURL(url, s.cfg.Network.BaseURL))
+			results <- s.shortenRepository.DeleteByShortURL(ctx, utils.CreateShortURL(url, s.cfg.Network.BaseURL), userID)
 		}()
 	}
 
@@ -149,7 +263,58 @@ func (s *URLService) DeleteByShortURLs(shortURLs []string) error {
 		close(results)
 	}()
 	for i := range results {
-		s.Log.Log.Info("delete operation", zap.Bool("val", i))
+		s.Log.Ctx(ctx).Info("delete operation", zap.Bool("val", i))
+	}
+	return nil
+}
+
+// checkBatchSize returns ErrBatchTooLarge if a batch of n URLs exceeds the configured maximum, zero means no limit.
+func (s *URLService) checkBatchSize(n int) error {
+	if limit := s.cfg.Network.MaxBatchSize; limit > 0 && n > limit {
+		return fmt.Errorf("%w: %d, at most %d are allowed", ErrBatchTooLarge, n, limit)
 	}
 	return nil
 }
+
+// RestoreByShortURLs restores the URLs of the user deleted within the configured grace period.
+// It returns the short URLs which were restored, or the error of the storage if one of them can't be restored.
+func (s *URLService) RestoreByShortURLs(ctx context.Context, userID int, keys []string) ([]string, error) {
+	deletedAfter := time.Now().Add(-s.cfg.Storage.DeletedGracePeriod)
+	restored := make([]string, 0, len(keys))
+	for _, key := range keys {
+		shortURL := utils.CreateShortURL(key, s.cfg.Network.BaseURL)
+		ok, err := s.shortenRepository.RestoreByShortURL(ctx, shortURL, userID, deletedAfter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			restored = append(restored, shortURL)
+		}
+	}
+	return restored, nil
+}
+
+// PurgeDeleted permanently removes the URLs deleted longer than the configured grace period ago.
+// It returns the number of removed URLs.
+func (s *URLService) PurgeDeleted(ctx context.Context) (int, error) {
+	return s.shortenRepository.PurgeDeleted(ctx, time.Now().Add(-s.cfg.Storage.DeletedGracePeriod))
+}
+
+// RunPurge calls PurgeDeleted every interval until the context is done.
+func (s *URLService) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := s.PurgeDeleted(ctx)
+			if err != nil {
+				s.Log.Log.Error("error during purging deleted urls", zap.Error(err))
+				continue
+			}
+			s.Log.Log.Info("purge operation", zap.Int("purged", count))
+		}
+	}
+}
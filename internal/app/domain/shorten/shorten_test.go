@@ -1,5 +1,62 @@
 package shorten
 
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/storage/inmemory"
+)
+
+// taggingRepository fails to save the tags, either on their own or along with a batch.
+type taggingRepository struct {
+	storage.ShortenRepository
+}
+
+func (taggingRepository) SaveTags(context.Context, string, []string) error {
+	return errors.New("connection reset")
+}
+
+func (r taggingRepository) SaveAll(ctx context.Context, urls []models.ShortenData) error {
+	for _, url := range urls {
+		if len(url.Tags) > 0 {
+			return errors.New("connection reset")
+		}
+	}
+	return r.ShortenRepository.SaveAll(ctx, urls)
+}
+
+func TestCreateAndSaveWithFailingTags(t *testing.T) {
+	ctx := context.Background()
+	zlog := &logger.Logger{Log: zap.NewNop()}
+	repo, err := inmemory.NewInMemShortenStorage(&configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}, zlog)
+	require.NoError(t, err)
+	defer repo.Close()
+	cfg := &configuration.Config{Network: &configuration.NetworkCfg{BaseURL: "http://localhost:8080/"}}
+
+	failing := NewURLService(taggingRepository{repo}, zlog, cfg)
+	_, err = failing.CreateAndSave(ctx, "https://go.dev/", 1, "go")
+	require.Error(t, err)
+	_, ok := repo.FindByURL(ctx, "https://go.dev/")
+	assert.False(t, ok, "the URL isn't stored without its tags")
+
+	service := NewURLService(repo, zlog, cfg)
+	shortURL, err := service.CreateAndSave(ctx, "https://go.dev/", 1, "go")
+	require.NoError(t, err, "a retry doesn't conflict with the failed attempt")
+	saved, err := repo.FindByKey(ctx, shortURL)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go"}, saved.Tags)
+}
+
 //const URL = "www.yandex.ru"
 //const KEY = "key"
 
@@ -2,8 +2,10 @@
 package user
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 
 	"github.com/lookeme/short-url/internal/logger"
 	"github.com/lookeme/short-url/internal/models"
@@ -26,6 +28,9 @@ type UsrService struct {
 	Log            *logger.Logger
 }
 
+// ErrUnauthorized is returned when a request doesn't carry a valid token of a user.
+var ErrUnauthorized = errors.New("user is not authorized")
+
 var chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890-"
 
 // NewUserService constructs a new instance of UsrService with necessary dependencies.
@@ -40,7 +45,7 @@ func NewUserService(userRepository storage.UserRepository, log *logger.Logger) U
 // CreateUser generates a new user with a random username and password,
 // hashes the password using Argon2 algorithm, and saves the user into the repository.
 // It returns a User model or an error if the operation fails.
-func (s *UsrService) CreateUser() (models.User, error) {
+func (s *UsrService) CreateUser(ctx context.Context) (models.User, error) {
 	user := models.User{}
 	strPass := generatePass(8)
 	strName := generatePass(5)
@@ -49,7 +54,7 @@ func (s *UsrService) CreateUser() (models.User, error) {
 		return models.User{}, err
 	}
 	user.Name = strName
-	ID, err := s.userRepository.SaveUser(strName, hash)
+	ID, err := s.userRepository.SaveUser(ctx, strName, hash)
 	if err != nil {
 		return models.User{}, err
 	}
@@ -58,8 +63,8 @@ func (s *UsrService) CreateUser() (models.User, error) {
 }
 
 // FindByID retrieves a user from the repository by their ID.
-func (s *UsrService) FindByID(userID int) (models.User, error) {
-	return s.userRepository.FindByID(userID)
+func (s *UsrService) FindByID(ctx context.Context, userID int) (models.User, error) {
+	return s.userRepository.FindByID(ctx, userID)
 }
 
 // generateFromPassword creates a password hash using the Argon2 ID hashing algorithm.
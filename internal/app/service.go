@@ -1,33 +1,40 @@
 // Package app provides the main interfaces for the application.
 package app
 
-import "github.com/lookeme/short-url/internal/models"
+import (
+	"context"
+
+	"github.com/lookeme/short-url/internal/models"
+)
 
 // ShortenURLService provides an interface to operate on ShortenData.
 type ShortenURLService interface {
 
 	// CreateAndSave creates a shortened URL and stores it,
 	// it requires a key and the user ID as inputs and returns the created URL.
-	CreateAndSave(key string, userID int) (string, error)
+	CreateAndSave(ctx context.Context, key string, userID int) (string, error)
 
 	// FindByURL searches for an existing ShortenData entry using the given key.
 	// It returns the corresponding ShortenData and a boolean indicating if the entry exists.
-	FindByURL(key string) (models.ShortenData, bool)
+	FindByURL(ctx context.Context, key string) (models.ShortenData, bool)
 
 	// FindByKey searches for an existing ShortenData entry using the provided key.
-	// It returns the corresponding ShortenData and a boolean indicating if the entry exists.
-	FindByKey(key string) (models.ShortenData, bool)
+	// It returns the corresponding ShortenData, or storage.ErrNotFound if the entry doesn't exist.
+	FindByKey(ctx context.Context, key string) (models.ShortenData, error)
 
 	// FindAll returns all available ShortenData within the database.
-	FindAll() ([]models.ShortenData, error)
+	FindAll(ctx context.Context) ([]models.ShortenData, error)
 
-	// CreateAndSaveBatch creates a batch of shorten URLs and saves them,
+	// CreateAndSaveBatch creates a batch of shorten URLs owned by the user and saves them,
 	// requires an array of BatchRequest as input and returns an array of BatchResponse.
-	CreateAndSaveBatch(urls []models.BatchRequest) ([]models.BatchResponse, error)
+	CreateAndSaveBatch(ctx context.Context, urls []models.BatchRequest, userID int) ([]models.BatchResponse, error)
 
-	// DeleteByShortURLs deletes the ShortenData entries whose keys are in the given URLs.
+	// DeleteByShortURLs deletes the ShortenData entries of the user whose keys are in the given URLs.
 	// Returns an error if it fails.
-	DeleteByShortURLs(urls []string) error
+	DeleteByShortURLs(ctx context.Context, userID int, urls []string) error
+
+	// Ping returns an error if the storage can't serve requests.
+	Ping(ctx context.Context) error
 }
 
 // UserService provides an interface for operations on User models.
@@ -35,9 +42,9 @@ type UserService interface {
 
 	// CreateUser is a function that creates a new user given a username,
 	// and returns the newly created User model.
-	CreateUser(userName string) (models.User, error)
+	CreateUser(ctx context.Context, userName string) (models.User, error)
 
 	// FindByID searches for an existing User entry given a user ID,
 	// and returns the corresponding User model and a boolean indicating if the entry exists.
-	FindByID(userID int) (models.User, bool)
+	FindByID(ctx context.Context, userID int) (models.User, bool)
 }
@@ -0,0 +1,308 @@
+// Package compression provides the middleware compressing the responses and decompressing the requests
+// with the gzip, deflate, brotli and zstd content codings.
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/problem"
+)
+
+// DefaultMinSize is the size in bytes below which compressing a response doesn't pay off.
+const DefaultMinSize = 1024
+
+// compressibleTypes are the media types worth compressing besides text/* and the +json and +xml suffixes.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+// Compressor is a simple struct to bind the compression middleware method.
+type Compressor struct {
+	// MinSize is the size in bytes a response body must reach to be compressed.
+	MinSize int
+	// MaxBodySize is the size in bytes a request body may have as sent, zero means no limit.
+	MaxBodySize int64
+	// MaxDecompressedSize is the size in bytes a compressed request body may expand to, zero means no limit.
+	MaxDecompressedSize int64
+}
+
+// New creates a Compressor with the sizes of the network configuration.
+func New(cfg *configuration.NetworkCfg) *Compressor {
+	return &Compressor{
+		MinSize:             cfg.CompressMinSize,
+		MaxBodySize:         cfg.MaxBodySize,
+		MaxDecompressedSize: cfg.MaxDecompressedBodySize,
+	}
+}
+
+// Middleware is a middleware function for compressing and decompressing HTTP traffic.
+// The response is compressed with the content coding negotiated from the Accept-Encoding header,
+// if its body is at least MinSize bytes long and its content type is compressible.
+// A request body sent with a supported Content-Encoding is decompressed; any other coding is rejected
+// with 415 Unsupported Media Type. The rejections are answered with problem details like the errors of the handlers.
+//
+// The request body is limited to MaxBodySize bytes as sent and to MaxDecompressedSize bytes once decompressed,
+// so a small compressed body can't expand into gigabytes of memory. A body declared larger is rejected with
+// 413 Request Entity Too Large right away, otherwise reading beyond a limit fails with *http.MaxBytesError,
+// which the handlers answer with the same 413 problem.
+func (c *Compressor) Middleware(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if c.MaxBodySize > 0 {
+			if r.ContentLength > c.MaxBodySize {
+				problem.Write(w, r, problem.PayloadTooLarge(&http.MaxBytesError{Limit: c.MaxBodySize}))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, c.MaxBodySize)
+		}
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" {
+			body, err := newDecompressReader(r.Body, encoding)
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.Is(err, errUnsupportedEncoding):
+				problem.Write(w, r, problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType, err.Error()))
+				return
+			case errors.As(err, &maxBytesErr):
+				problem.Write(w, r, problem.PayloadTooLarge(maxBytesErr))
+				return
+			case err != nil:
+				problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeMalformedRequest, err.Error()))
+				return
+			}
+			defer body.Close()
+			if c.MaxDecompressedSize > 0 {
+				body = &limitedReader{ReadCloser: body, remaining: c.MaxDecompressedSize, limit: c.MaxDecompressedSize}
+			}
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc, ok := negotiate(r.Header.Get("Accept-Encoding"))
+		if !ok || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc, minSize: c.MinSize, status: http.StatusOK}
+		defer cw.Close()
+		h.ServeHTTP(cw, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// compressWriter compresses the response with the negotiated encoding.
+// The body is buffered until MinSize bytes are written, so the small responses are sent uncompressed
+// along with a Content-Length.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding encoding
+	minSize  int
+	status   int
+	buf      []byte
+	// decided is set once the header is sent, enc is set if the body is compressed
+	decided bool
+	enc     encoder
+}
+
+// WriteHeader records the status code, the header is sent once it is known whether the body is compressed.
+func (c *compressWriter) WriteHeader(statusCode int) {
+	if c.decided {
+		return
+	}
+	if statusCode < http.StatusOK {
+		// informational responses precede the final one
+		c.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	c.status = statusCode
+	if !bodyAllowed(statusCode) {
+		_ = c.start(false)
+	}
+}
+
+// Write buffers the body until it reaches MinSize bytes and compresses it from then on if it is compressible.
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.decided {
+		if c.enc != nil {
+			return c.enc.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.minSize {
+		if err := c.start(c.compressible()); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the buffered body to the client, compressing it if it is compressible whatever its size.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		_ = c.start(len(c.buf) > 0 && c.compressible())
+	}
+	if c.enc != nil {
+		_ = c.enc.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer for http.ResponseController.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Close sends the response if it is still buffered and finishes the compressed stream.
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if err := c.start(false); err != nil {
+			return err
+		}
+	}
+	if c.enc == nil {
+		return nil
+	}
+	err := c.enc.Close()
+	c.encoding.release(c.enc)
+	c.enc = nil
+	return err
+}
+
+// start sends the header and the buffered body, compressed or not.
+func (c *compressWriter) start(compress bool) error {
+	c.decided = true
+	header := c.Header()
+	if compress {
+		header.Set("Content-Encoding", c.encoding.name)
+		header.Del("Content-Length")
+		c.enc = c.encoding.acquire(c.ResponseWriter)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(c.buf)
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = nil
+	return err
+}
+
+// compressible reports whether the response is worth compressing: it isn't encoded already,
+// isn't a partial content and its content type, sniffed from the body if it isn't set, is textual.
+func (c *compressWriter) compressible() bool {
+	header := c.Header()
+	if !bodyAllowed(c.status) || header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(c.buf)
+		header.Set("Content-Type", contentType)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// bodyAllowed reports whether a response with the status code may have a body.
+func bodyAllowed(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// errUnsupportedEncoding is returned for a request body in a content coding the middleware doesn't support.
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// limitedReader fails with *http.MaxBytesError once more than limit bytes are read,
+// unlike io.LimitReader which silently truncates the body.
+type limitedReader struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+// Read reads at most one byte beyond the limit, enough to tell a body of exactly limit bytes from a larger one.
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &http.MaxBytesError{Limit: l.limit}
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, &http.MaxBytesError{Limit: l.limit}
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// decompressReader decompresses a request body and closes both the decompressor and the body.
+type decompressReader struct {
+	io.Reader
+	body  io.Closer
+	close func() error
+}
+
+// Close closes the decompressor and the body.
+func (d *decompressReader) Close() error {
+	err := d.close()
+	return errors.Join(err, d.body.Close())
+}
+
+// newDecompressReader wraps the body sent in the content coding with its decompressor.
+func newDecompressReader(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	noop := func() error { return nil }
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case Gzip, "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressReader{Reader: zr, body: body, close: zr.Close}, nil
+	case Deflate:
+		zr, err := zlib.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressReader{Reader: zr, body: body, close: zr.Close}, nil
+	case Brotli:
+		return &decompressReader{Reader: brotli.NewReader(body), body: body, close: noop}, nil
+	case Zstd:
+		// the window is capped as RFC 8878 recommends for HTTP, so a crafted frame header can't make
+		// the decoder allocate hundreds of megabytes before anything is read
+		zr, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(8<<20))
+		if err != nil {
+			return nil, err
+		}
+		return &decompressReader{Reader: zr, body: body, close: func() error { zr.Close(); return nil }}, nil
+	case "identity":
+		return body, nil
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
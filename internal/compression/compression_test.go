@@ -0,0 +1,278 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/problem"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: Gzip},
+		{acceptEncoding: "gzip, deflate, br, zstd", want: Brotli},
+		{acceptEncoding: "gzip;q=1.0, br;q=0.5", want: Gzip},
+		{acceptEncoding: "br;q=0, gzip;q=0.1", want: Gzip},
+		{acceptEncoding: "*", want: Brotli},
+		{acceptEncoding: "*;q=0.5, zstd;q=0.8, br;q=0", want: Zstd},
+		{acceptEncoding: "X-GZIP", want: Gzip},
+		{acceptEncoding: "identity", want: ""},
+		{acceptEncoding: "gzip;q=0", want: ""},
+		{acceptEncoding: "gzip;q=abc, deflate", want: Deflate},
+		{acceptEncoding: "compress", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			enc, ok := negotiate(tt.acceptEncoding)
+			assert.Equal(t, tt.want != "", ok)
+			assert.Equal(t, tt.want, enc.name)
+		})
+	}
+}
+
+// decode decompresses the body sent in the content coding.
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch encoding {
+	case Gzip:
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case Deflate:
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case Brotli:
+		r = brotli.NewReader(bytes.NewReader(body))
+	case Zstd:
+		var dec *zstd.Decoder
+		dec, err = zstd.NewReader(bytes.NewReader(body))
+		defer dec.Close()
+		r = dec
+	}
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestMiddleware(t *testing.T) {
+	large := `{"result":"` + strings.Repeat("http://localhost:8080/abcdefgh ", 100) + `"}`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		// written in pieces to cross the threshold in the middle of a write
+		_, _ = io.WriteString(w, large[:100])
+		_, _ = io.WriteString(w, large[100:])
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "http://localhost:8080/abc")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Location", "https://example.com")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/png", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 2048)...))
+	})
+	mux.HandleFunc("/sniffed", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "<html><body>"+strings.Repeat("<p>hello</p>", 200)+"</body></html>")
+	})
+	handler := New(&configuration.NetworkCfg{CompressMinSize: DefaultMinSize}).Middleware(mux)
+
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		return w
+	}
+
+	for _, encoding := range []string{Gzip, Deflate, Brotli, Zstd} {
+		t.Run(encoding, func(t *testing.T) {
+			// twice, so the second response is compressed by a pooled encoder
+			for i := 0; i < 2; i++ {
+				w := serve("/json", encoding)
+				assert.Equal(t, http.StatusCreated, w.Code)
+				assert.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+				assert.Less(t, w.Body.Len(), len(large))
+				assert.Equal(t, large, decode(t, encoding, w.Body.Bytes()))
+			}
+		})
+	}
+
+	t.Run("not accepted", func(t *testing.T) {
+		w := serve("/json", "")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, large, w.Body.String())
+	})
+
+	t.Run("below the minimum size", func(t *testing.T) {
+		w := serve("/small", "gzip")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "http://localhost:8080/abc", w.Body.String())
+	})
+
+	t.Run("empty redirect", func(t *testing.T) {
+		w := serve("/redirect", "gzip")
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Zero(t, w.Body.Len())
+	})
+
+	t.Run("incompressible type", func(t *testing.T) {
+		w := serve("/png", "gzip")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("sniffed type", func(t *testing.T) {
+		w := serve("/sniffed", "gzip")
+		assert.Equal(t, Gzip, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	})
+}
+
+func TestMiddlewareRequestBody(t *testing.T) {
+	handler := New(&configuration.NetworkCfg{CompressMinSize: DefaultMinSize}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	}))
+	serve := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", encoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("https://practicum.yandex.ru/"))
+	require.NoError(t, zw.Close())
+	w := serve(Gzip, buf.Bytes())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://practicum.yandex.ru/", w.Body.String())
+
+	buf.Reset()
+	bw := brotli.NewWriter(&buf)
+	_, _ = bw.Write([]byte("https://go.dev/"))
+	require.NoError(t, bw.Close())
+	w = serve(Brotli, buf.Bytes())
+	assert.Equal(t, "https://go.dev/", w.Body.String())
+
+	for _, tt := range []struct {
+		encoding string
+		status   int
+		code     string
+	}{
+		{encoding: Gzip, status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{encoding: "compress", status: http.StatusUnsupportedMediaType, code: problem.CodeUnsupportedMediaType},
+	} {
+		w = serve(tt.encoding, []byte("not compressed"))
+		assert.Equal(t, tt.status, w.Code)
+		assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		var p problem.Problem
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+		assert.Equal(t, tt.code, p.Code)
+		assert.Equal(t, tt.status, p.Status)
+	}
+}
+
+// gzipBomb returns size zero bytes compressed with gzip, which shrink to about a thousandth of their size.
+func gzipBomb(t *testing.T, size int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	require.NoError(t, err)
+	chunk := make([]byte, 1<<20)
+	for size > 0 {
+		n := min(size, len(chunk))
+		_, err := zw.Write(chunk[:n])
+		require.NoError(t, err)
+		size -= n
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestMiddlewareLimits(t *testing.T) {
+	const (
+		maxBodySize         = 64 << 10
+		maxDecompressedSize = 1 << 20
+	)
+	var read int64
+	handler := New(&configuration.NetworkCfg{
+		CompressMinSize:         DefaultMinSize,
+		MaxBodySize:             maxBodySize,
+		MaxDecompressedBodySize: maxDecompressedSize,
+	}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the handlers read the whole body and answer a *http.MaxBytesError with 413
+		var err error
+		read, err = io.Copy(io.Discard, r.Body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		require.NoError(t, err)
+	}))
+	serve := func(encoding string, body []byte, chunked bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", bytes.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	bomb := gzipBomb(t, 64<<20)
+	require.Less(t, len(bomb), maxBodySize, "the bomb passes the limit of the compressed size")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(Gzip, bomb, false))
+	assert.Equal(t, int64(maxDecompressedSize), read, "the decompression stops at the limit")
+
+	assert.Equal(t, http.StatusOK, serve(Gzip, gzipBomb(t, maxDecompressedSize), false), "a body of exactly the limit is accepted")
+	assert.Equal(t, int64(maxDecompressedSize), read)
+
+	large := bytes.Repeat([]byte("a"), maxBodySize+1)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("", large, false), "a declared length is checked up front")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("", large, true), "a chunked body is cut off while it is read")
+	assert.Equal(t, http.StatusOK, serve("", large[:maxBodySize], true))
+
+	var nested bytes.Buffer
+	zw := gzip.NewWriter(&nested)
+	_, _ = zw.Write(bomb)
+	require.NoError(t, zw.Close())
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve("gzip, gzip", nested.Bytes(), false), "stacked codings aren't decoded")
+
+	var zstdBomb bytes.Buffer
+	enc, err := zstd.NewWriter(&zstdBomb)
+	require.NoError(t, err)
+	_, _ = enc.Write(make([]byte, 16<<20))
+	require.NoError(t, enc.Close())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(Zstd, zstdBomb.Bytes(), false))
+}
@@ -0,0 +1,123 @@
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings supported by the middleware.
+const (
+	Brotli  = "br"
+	Zstd    = "zstd"
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+// brotliLevel is lower than the default of the library, as the responses are compressed on the fly.
+const brotliLevel = 4
+
+// encoder is a compressing writer which can be reused for another stream after Reset.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoding is a content coding the responses can be compressed with.
+type encoding struct {
+	name string
+	pool *sync.Pool
+}
+
+// encodings are the supported content codings in the order of preference of the server,
+// which decides between codings the client accepts equally.
+var encodings = []encoding{
+	{name: Brotli, pool: newPool(func() encoder { return brotli.NewWriterLevel(io.Discard, brotliLevel) })},
+	{name: Zstd, pool: newPool(func() encoder {
+		// the window is limited to what browsers accept, a single goroutine suits a single response
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+		return enc
+	})},
+	{name: Gzip, pool: newPool(func() encoder { return gzip.NewWriter(io.Discard) })},
+	// the deflate content coding is the zlib format rather than raw deflate, see RFC 9110 section 8.4.1.2
+	{name: Deflate, pool: newPool(func() encoder { return zlib.NewWriter(io.Discard) })},
+}
+
+// newPool creates a pool of encoders, which are expensive to allocate.
+func newPool(create func() encoder) *sync.Pool {
+	return &sync.Pool{New: func() any { return create() }}
+}
+
+// acquire takes an encoder writing to w from the pool.
+func (e encoding) acquire(w io.Writer) encoder {
+	enc := e.pool.Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+// release returns a closed encoder to the pool.
+func (e encoding) release(enc encoder) {
+	enc.Reset(io.Discard)
+	e.pool.Put(enc)
+}
+
+// negotiate picks the content coding of the response from the Accept-Encoding header of the request,
+// following RFC 9110: the coding with the highest q-value wins, "*" stands for the codings not listed,
+// and a q-value of 0 rules a coding out. It returns false if the response must not be compressed.
+func negotiate(acceptEncoding string) (encoding, bool) {
+	if acceptEncoding == "" {
+		return encoding{}, false
+	}
+	accepted := parseAcceptEncoding(acceptEncoding)
+	var (
+		best  encoding
+		bestQ float64
+	)
+	for _, enc := range encodings {
+		q, ok := accepted[enc.name]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// parseAcceptEncoding returns the q-value of every coding listed in the Accept-Encoding header.
+// Malformed q-values rule the coding out.
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, item := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = Gzip
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+		accepted[coding] = q
+	}
+	return accepted
+}
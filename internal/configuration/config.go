@@ -5,6 +5,9 @@ package configuration
 import (
 	"flag"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -15,25 +18,136 @@ type Config struct {
 	Network *NetworkCfg `yaml:"network"`
 	Logger  *LoggerCfg  `yaml:"logger"`
 	Storage *Storage    `yaml:"storage"`
+	Tracing *TracingCfg `yaml:"tracing"`
 }
 
 // LoggerCfg structure
 type LoggerCfg struct {
-	Level  string `yaml:"level"`
+	Level string `yaml:"level"`
+	// Output is stdout, stderr or the path of a log file.
 	Output string `yaml:"output"`
+	// Format is json or console.
+	Format string `yaml:"format"`
+	// MaxSizeMB is the size in megabytes the log file is rotated at, zero disables rotation.
+	MaxSizeMB int `yaml:"max-size-mb"`
+	// MaxBackups is the number of rotated log files kept.
+	MaxBackups int `yaml:"max-backups"`
+	// SamplingInitial is the number of entries with the same message logged every second before sampling starts,
+	// zero disables sampling.
+	SamplingInitial int `yaml:"sampling-initial"`
+	// SamplingThereafter is the sampling rate: every SamplingThereafter-th entry beyond SamplingInitial is logged.
+	SamplingThereafter int `yaml:"sampling-thereafter"`
+	// AccessLog is stdout, stderr or the path of the access log file, empty disables the access log.
+	AccessLog string `yaml:"access-log"`
+	// AccessLogFormat is combined or json.
+	AccessLogFormat string `yaml:"access-log-format"`
+}
+
+// TracingCfg structure
+type TracingCfg struct {
+	// Exporter is where the spans go: none, stdout, file or otlp.
+	Exporter string `yaml:"exporter"`
+	// FilePath is the file the spans are appended to by the file exporter.
+	FilePath string `yaml:"file"`
+	// OTLPEndpoint is the OTLP/HTTP endpoint of the collector, e.g. http://localhost:4318.
+	OTLPEndpoint string `yaml:"otlp-endpoint"`
+	// ServiceName is the name the spans are reported under.
+	ServiceName string `yaml:"service-name"`
 }
 
 // NetworkCfg structure
 type NetworkCfg struct {
 	ServerAddress string `yaml:"address"`
 	BaseURL       string `yaml:"base-url"`
+	// MetricsAddress is the address the Prometheus metrics are served on, separately from the API.
+	// An empty address disables the metrics endpoint.
+	MetricsAddress string `yaml:"metrics-address"`
+	// AdminAddress is the address the admin endpoints, such as changing the log level, are served on.
+	// They are not authenticated, so it should be a loopback address. An empty address disables them.
+	AdminAddress string `yaml:"admin-address"`
+	// HealthCheckTimeout bounds every dependency check of the readiness endpoint.
+	HealthCheckTimeout time.Duration `yaml:"health-check-timeout"`
+	// ShutdownDelay is how long the server keeps serving with a failing readiness endpoint after a shutdown signal,
+	// so load balancers stop routing requests to it before it stops accepting them.
+	ShutdownDelay time.Duration `yaml:"shutdown-delay"`
+	// ShutdownTimeout bounds the wait for the in-flight requests on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout"`
+	// CompressMinSize is the size in bytes a response body must reach to be compressed.
+	CompressMinSize int `yaml:"compress-min-size"`
+	// MaxBodySize is the size in bytes a request body may have as sent, zero means no limit.
+	MaxBodySize int64 `yaml:"max-body-size"`
+	// MaxDecompressedBodySize is the size in bytes a compressed request body may expand to, zero means no limit.
+	MaxDecompressedBodySize int64 `yaml:"max-decompressed-body-size"`
+	// MaxBatchSize is the number of URLs a batch shorten or delete request may carry, zero means no limit.
+	MaxBatchSize int `yaml:"max-batch-size"`
 }
 
+// Storage types supported by the application.
+const (
+	// StorageMemory keeps the data in memory and appends every change to FileStoragePath.
+	StorageMemory = "memory"
+	// StoragePostgres keeps the data in the PostgreSQL database given by ConnString.
+	StoragePostgres = "postgres"
+	// StorageBolt keeps the data in the embedded key-value database at BoltFilePath.
+	StorageBolt = "bolt"
+	// StorageSQLite keeps the data in the SQLite database file given by a ConnString with the SQLitePrefix.
+	StorageSQLite = "sqlite"
+)
+
+// SQLitePrefix is the scheme of the ConnString of a SQLite database, e.g. sqlite:///var/lib/short-url.db.
+const SQLitePrefix = "sqlite://"
+
 // Storage structure
 type Storage struct {
+	// Type is one of StorageMemory, StoragePostgres, StorageBolt or StorageSQLite.
+	// If it is empty, it is derived from ConnString, see StorageType.
+	Type            string `yaml:"type"`
 	FileStoragePath string `yaml:"address"`
+	BoltFilePath    string `yaml:"bolt-file"`
 	ConnString      string
-	PGPoolCfg       *pgxpool.Config
+	// SkipMigrations disables applying the pending migrations on startup,
+	// so they can be run as a separate step with the migrate command.
+	SkipMigrations bool `yaml:"skip-migrations"`
+	// PGPoolCfg is the base configuration of the PostgreSQL pool, it is parsed from ConnString when it is nil.
+	PGPoolCfg *pgxpool.Config
+	// PGMaxConns is the maximum size of the PostgreSQL pool, zero keeps the default of pgxpool.
+	PGMaxConns int32 `yaml:"pg-max-conns"`
+	// PGMinConns is the number of PostgreSQL connections kept open even when idle.
+	PGMinConns int32 `yaml:"pg-min-conns"`
+	// PGMaxConnLifetime is how long a PostgreSQL connection is used before it is replaced, zero keeps the default of pgxpool.
+	PGMaxConnLifetime time.Duration `yaml:"pg-max-conn-lifetime"`
+	// PGStatementTimeout aborts PostgreSQL statements running longer, zero means no timeout.
+	PGStatementTimeout time.Duration `yaml:"pg-statement-timeout"`
+	// ReplicaConnStrings are the connection strings of the PostgreSQL read replicas redirect lookups are sent to.
+	ReplicaConnStrings []string `yaml:"replica-conn-strings"`
+	// ReplicaCheckInterval is how often the replicas are pinged to find out which of them can serve reads.
+	ReplicaCheckInterval time.Duration `yaml:"replica-check-interval"`
+	// DeletedGracePeriod is how long a deleted URL can be restored before it is purged.
+	DeletedGracePeriod time.Duration `yaml:"deleted-grace-period"`
+	// PurgeInterval is how often URLs deleted longer than DeletedGracePeriod ago are purged.
+	PurgeInterval time.Duration `yaml:"purge-interval"`
+	// CompactInterval is how often the file storage log is compacted into a snapshot.
+	CompactInterval time.Duration `yaml:"compact-interval"`
+	// CacheSize is the number of redirect lookups kept in memory in front of the storage, zero disables the cache.
+	CacheSize int `yaml:"cache-size"`
+	// CacheTTL is how long a cached redirect lookup is used before the storage is asked again.
+	CacheTTL time.Duration `yaml:"cache-ttl"`
+}
+
+// StorageType returns the configured storage type.
+// If no type is set, it is StorageSQLite when ConnString starts with SQLitePrefix,
+// StoragePostgres when ConnString is set and StorageMemory otherwise.
+func (s *Storage) StorageType() string {
+	if s.Type != "" {
+		return s.Type
+	}
+	if strings.HasPrefix(s.ConnString, SQLitePrefix) {
+		return StorageSQLite
+	}
+	if s.ConnString != "" {
+		return StoragePostgres
+	}
+	return StorageMemory
 }
 
 // New creates a new Config instance, loading data from
@@ -43,22 +157,113 @@ func New() *Config {
 	networkCfg := NetworkCfg{}
 	loggerCfg := LoggerCfg{}
 	storageCfg := Storage{}
+	tracingCfg := TracingCfg{}
 	flag.StringVar(&networkCfg.ServerAddress, "a", "localhost:8080", "address and port to run server")
 	flag.StringVar(&networkCfg.BaseURL, "b", "http://localhost:8080", "base address")
+	flag.StringVar(&networkCfg.MetricsAddress, "metrics-address", "localhost:9090", "address and port to serve metrics on, empty to disable")
+	flag.StringVar(&networkCfg.AdminAddress, "admin-address", "", "address and port to serve the unauthenticated log level endpoint on, keep it on localhost, empty to disable")
+	flag.DurationVar(&networkCfg.HealthCheckTimeout, "health-check-timeout", 2*time.Second, "timeout of a readiness check")
+	flag.DurationVar(&networkCfg.ShutdownDelay, "shutdown-delay", 0, "time to keep serving with failing readiness before shutting down")
+	flag.DurationVar(&networkCfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "time to wait for in-flight requests on shutdown")
+	flag.IntVar(&networkCfg.CompressMinSize, "compress-min-size", 1024, "size in bytes a response must reach to be compressed")
+	flag.Int64Var(&networkCfg.MaxBodySize, "max-body-size", 1<<20, "maximum size in bytes of a request body, 0 for no limit")
+	flag.Int64Var(&networkCfg.MaxDecompressedBodySize, "max-decompressed-body-size", 4<<20, "maximum size in bytes of a decompressed request body, 0 for no limit")
+	flag.IntVar(&networkCfg.MaxBatchSize, "max-batch-size", 1000, "maximum number of urls in a batch request, 0 for no limit")
 	flag.StringVar(&loggerCfg.Level, "l", "info", "logger level")
+	flag.StringVar(&loggerCfg.Output, "log-output", "stdout", "log output: stdout, stderr or a file path")
+	flag.StringVar(&loggerCfg.Format, "log-format", "json", "log format: json or console")
+	flag.IntVar(&loggerCfg.MaxSizeMB, "log-max-size", 100, "size in megabytes the log file is rotated at, 0 disables rotation")
+	flag.IntVar(&loggerCfg.MaxBackups, "log-max-backups", 5, "number of rotated log files kept")
+	flag.IntVar(&loggerCfg.SamplingInitial, "log-sampling-initial", 0, "entries with the same message logged per second before sampling, 0 disables sampling")
+	flag.IntVar(&loggerCfg.SamplingThereafter, "log-sampling-thereafter", 100, "log every n-th sampled entry")
+	flag.StringVar(&loggerCfg.AccessLog, "access-log", "", "access log output: stdout, stderr or a file path, empty to disable")
+	flag.StringVar(&loggerCfg.AccessLogFormat, "access-log-format", "combined", "access log format: combined or json")
 	flag.StringVar(&storageCfg.FileStoragePath, "f", "/tmp/short-url-db.json", "file to store data")
-	flag.StringVar(&storageCfg.ConnString, "d", "", "file to store data")
+	flag.StringVar(&storageCfg.ConnString, "d", "", "database connection string, sqlite:///path/to/file.db selects the sqlite storage")
+	flag.StringVar(&storageCfg.Type, "storage", "", "storage type: memory, postgres, bolt or sqlite")
+	flag.StringVar(&storageCfg.BoltFilePath, "bolt-file", "/tmp/short-url.db", "file of the embedded key-value storage")
+	flag.DurationVar(&storageCfg.DeletedGracePeriod, "deleted-grace-period", 7*24*time.Hour, "period during which deleted urls can be restored")
+	flag.DurationVar(&storageCfg.PurgeInterval, "purge-interval", time.Hour, "interval between purges of deleted urls")
+	flag.DurationVar(&storageCfg.CompactInterval, "compact-interval", 10*time.Minute, "interval between compactions of the storage file")
+	migrate := flag.Bool("migrate", true, "apply pending database migrations on startup")
+	flag.Func("pg-max-conns", "maximum number of postgres connections", int32Flag(&storageCfg.PGMaxConns))
+	flag.Func("pg-min-conns", "minimum number of idle postgres connections", int32Flag(&storageCfg.PGMinConns))
+	flag.DurationVar(&storageCfg.PGMaxConnLifetime, "pg-max-conn-lifetime", 0, "lifetime of a postgres connection")
+	flag.DurationVar(&storageCfg.PGStatementTimeout, "pg-statement-timeout", 0, "timeout of a postgres statement")
+	flag.Func("replica-dsn", "comma separated connection strings of postgres read replicas", func(value string) error {
+		storageCfg.ReplicaConnStrings = splitList(value)
+		return nil
+	})
+	flag.DurationVar(&storageCfg.ReplicaCheckInterval, "replica-check-interval", 5*time.Second, "interval between health checks of the read replicas")
+	flag.IntVar(&storageCfg.CacheSize, "cache-size", 10000, "number of cached redirect lookups, 0 disables the cache")
+	flag.DurationVar(&storageCfg.CacheTTL, "cache-ttl", time.Minute, "time to live of a cached redirect lookup")
+	flag.StringVar(&tracingCfg.Exporter, "trace-exporter", "none", "trace exporter: none, stdout, file or otlp")
+	flag.StringVar(&tracingCfg.FilePath, "trace-file", "/tmp/short-url-traces.json", "file the file trace exporter writes to")
+	flag.StringVar(&tracingCfg.OTLPEndpoint, "otlp-endpoint", "", "OTLP/HTTP endpoint of the trace collector")
+	flag.StringVar(&tracingCfg.ServiceName, "service-name", "short-url", "service name reported in traces")
 
 	flag.Parse()
+	storageCfg.SkipMigrations = !*migrate
 	if serverAddress := os.Getenv("SERVER_ADDRESS"); serverAddress != "" {
 		networkCfg.ServerAddress = serverAddress
 	}
 	if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
 		networkCfg.BaseURL = baseURL
 	}
+	if metricsAddress, ok := os.LookupEnv("METRICS_ADDRESS"); ok {
+		networkCfg.MetricsAddress = metricsAddress
+	}
+	if adminAddress, ok := os.LookupEnv("ADMIN_ADDRESS"); ok {
+		networkCfg.AdminAddress = adminAddress
+	}
+	if healthCheckTimeout, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_TIMEOUT")); err == nil {
+		networkCfg.HealthCheckTimeout = healthCheckTimeout
+	}
+	if shutdownDelay, err := time.ParseDuration(os.Getenv("SHUTDOWN_DELAY")); err == nil {
+		networkCfg.ShutdownDelay = shutdownDelay
+	}
+	if shutdownTimeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil {
+		networkCfg.ShutdownTimeout = shutdownTimeout
+	}
+	if compressMinSize, err := strconv.Atoi(os.Getenv("COMPRESS_MIN_SIZE")); err == nil {
+		networkCfg.CompressMinSize = compressMinSize
+	}
+	if maxBodySize, err := strconv.ParseInt(os.Getenv("MAX_BODY_SIZE"), 10, 64); err == nil {
+		networkCfg.MaxBodySize = maxBodySize
+	}
+	if maxDecompressedBodySize, err := strconv.ParseInt(os.Getenv("MAX_DECOMPRESSED_BODY_SIZE"), 10, 64); err == nil {
+		networkCfg.MaxDecompressedBodySize = maxDecompressedBodySize
+	}
+	if maxBatchSize, err := strconv.Atoi(os.Getenv("MAX_BATCH_SIZE")); err == nil {
+		networkCfg.MaxBatchSize = maxBatchSize
+	}
 	if loggerLevel := os.Getenv("LOG_LEVEL"); loggerLevel != "" {
 		loggerCfg.Level = loggerLevel
 	}
+	if logOutput := os.Getenv("LOG_OUTPUT"); logOutput != "" {
+		loggerCfg.Output = logOutput
+	}
+	if logFormat := os.Getenv("LOG_FORMAT"); logFormat != "" {
+		loggerCfg.Format = logFormat
+	}
+	if maxSize, err := strconv.Atoi(os.Getenv("LOG_MAX_SIZE")); err == nil {
+		loggerCfg.MaxSizeMB = maxSize
+	}
+	if maxBackups, err := strconv.Atoi(os.Getenv("LOG_MAX_BACKUPS")); err == nil {
+		loggerCfg.MaxBackups = maxBackups
+	}
+	if samplingInitial, err := strconv.Atoi(os.Getenv("LOG_SAMPLING_INITIAL")); err == nil {
+		loggerCfg.SamplingInitial = samplingInitial
+	}
+	if samplingThereafter, err := strconv.Atoi(os.Getenv("LOG_SAMPLING_THEREAFTER")); err == nil {
+		loggerCfg.SamplingThereafter = samplingThereafter
+	}
+	if accessLog := os.Getenv("ACCESS_LOG"); accessLog != "" {
+		loggerCfg.AccessLog = accessLog
+	}
+	if accessLogFormat := os.Getenv("ACCESS_LOG_FORMAT"); accessLogFormat != "" {
+		loggerCfg.AccessLogFormat = accessLogFormat
+	}
 
 	if filaStoragePath := os.Getenv("FILE_STORAGE_PATH"); filaStoragePath != "" {
 		storageCfg.FileStoragePath = filaStoragePath
@@ -66,9 +271,90 @@ func New() *Config {
 	if connString := os.Getenv("DATABASE_DSN"); connString != "" {
 		storageCfg.ConnString = connString
 	}
+	if migrateOnStart, err := strconv.ParseBool(os.Getenv("MIGRATE")); err == nil {
+		storageCfg.SkipMigrations = !migrateOnStart
+	}
+	if maxConns, err := strconv.ParseInt(os.Getenv("PG_MAX_CONNS"), 10, 32); err == nil {
+		storageCfg.PGMaxConns = int32(maxConns)
+	}
+	if minConns, err := strconv.ParseInt(os.Getenv("PG_MIN_CONNS"), 10, 32); err == nil {
+		storageCfg.PGMinConns = int32(minConns)
+	}
+	if maxConnLifetime, err := time.ParseDuration(os.Getenv("PG_MAX_CONN_LIFETIME")); err == nil {
+		storageCfg.PGMaxConnLifetime = maxConnLifetime
+	}
+	if statementTimeout, err := time.ParseDuration(os.Getenv("PG_STATEMENT_TIMEOUT")); err == nil {
+		storageCfg.PGStatementTimeout = statementTimeout
+	}
+	if replicaConnStrings := os.Getenv("DATABASE_REPLICA_DSN"); replicaConnStrings != "" {
+		storageCfg.ReplicaConnStrings = splitList(replicaConnStrings)
+	}
+	if replicaCheckInterval, err := time.ParseDuration(os.Getenv("REPLICA_CHECK_INTERVAL")); err == nil {
+		storageCfg.ReplicaCheckInterval = replicaCheckInterval
+	}
+	if storageType := os.Getenv("STORAGE_TYPE"); storageType != "" {
+		storageCfg.Type = storageType
+	}
+	if boltFilePath := os.Getenv("BOLT_FILE_PATH"); boltFilePath != "" {
+		storageCfg.BoltFilePath = boltFilePath
+	}
+	if gracePeriod, err := time.ParseDuration(os.Getenv("DELETED_GRACE_PERIOD")); err == nil {
+		storageCfg.DeletedGracePeriod = gracePeriod
+	}
+	if purgeInterval, err := time.ParseDuration(os.Getenv("PURGE_INTERVAL")); err == nil {
+		storageCfg.PurgeInterval = purgeInterval
+	}
+	if compactInterval, err := time.ParseDuration(os.Getenv("COMPACT_INTERVAL")); err == nil {
+		storageCfg.CompactInterval = compactInterval
+	}
+	if cacheSize, err := strconv.Atoi(os.Getenv("CACHE_SIZE")); err == nil {
+		storageCfg.CacheSize = cacheSize
+	}
+	if cacheTTL, err := time.ParseDuration(os.Getenv("CACHE_TTL")); err == nil {
+		storageCfg.CacheTTL = cacheTTL
+	}
+	if traceExporter := os.Getenv("TRACE_EXPORTER"); traceExporter != "" {
+		tracingCfg.Exporter = traceExporter
+	}
+	if traceFile := os.Getenv("TRACE_FILE"); traceFile != "" {
+		tracingCfg.FilePath = traceFile
+	}
+	if otlpEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); otlpEndpoint != "" {
+		tracingCfg.OTLPEndpoint = otlpEndpoint
+	}
+	if serviceName := os.Getenv("OTEL_SERVICE_NAME"); serviceName != "" {
+		tracingCfg.ServiceName = serviceName
+	}
+	if tracingCfg.OTLPEndpoint != "" && tracingCfg.Exporter == "none" {
+		tracingCfg.Exporter = "otlp"
+	}
 	return &Config{
 		Network: &networkCfg,
 		Logger:  &loggerCfg,
 		Storage: &storageCfg,
+		Tracing: &tracingCfg,
+	}
+}
+
+// splitList splits a comma separated list and drops the empty items.
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// int32Flag returns a flag.Func parser storing the value in p.
+func int32Flag(p *int32) func(string) error {
+	return func(value string) error {
+		v, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return err
+		}
+		*p = int32(v)
+		return nil
 	}
 }
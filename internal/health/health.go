@@ -0,0 +1,178 @@
+// Package health implements the liveness and readiness endpoints of the service.
+//
+// Liveness only tells that the process serves HTTP, so an orchestrator restarts it when it hangs.
+// Readiness runs the registered dependency checks, e.g. a ping of the database, and fails while any of them
+// fails or while the service shuts down, so a load balancer stops routing requests to it:
+//
+//	checker := health.New(2 * time.Second)
+//	checker.Register("storage", urlService.Ping)
+//	r.Get("/healthz", checker.LivenessHandler)
+//	r.Get("/readyz", checker.ReadinessHandler)
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses of the service and of the checks.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// ErrShuttingDown is reported by the readiness check once the service started shutting down.
+var ErrShuttingDown = errors.New("shutting down")
+
+// Check checks a dependency of the service and returns an error if it is unavailable.
+// It must return when ctx is done.
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of a single check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the response of the health endpoints.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// namedCheck is a registered check.
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the registered checks for the readiness endpoint.
+type Checker struct {
+	timeout      time.Duration
+	mutex        sync.RWMutex
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+// New creates a Checker which gives every check timeout to complete.
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Register adds a check the readiness of the service depends on.
+func (c *Checker) Register(name string, check Check) {
+	defer c.mutex.Unlock()
+	c.mutex.Lock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// SetShuttingDown makes the readiness endpoint fail from then on, so no new requests are routed
+// to the service while it drains the in-flight ones.
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Ready runs all checks concurrently, each one bounded by the timeout of the Checker,
+// and reports the service ready if all of them pass and it isn't shutting down.
+func (c *Checker) Ready(ctx context.Context) Report {
+	checks := c.registered()
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks)+1)}
+	if c.shuttingDown.Load() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = CheckResult{Status: StatusFail, Error: ErrShuttingDown.Error(), Duration: "0s"}
+	}
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, check.check)
+	}
+	wg.Wait()
+	for i, check := range checks {
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+		report.Checks[check.name] = results[i]
+	}
+	return report
+}
+
+// registered returns the registered checks.
+func (c *Checker) registered() []namedCheck {
+	defer c.mutex.RUnlock()
+	c.mutex.RLock()
+	return c.checks
+}
+
+// run runs a single check with the timeout of the Checker.
+// A check which doesn't return in time is reported as failed, it is left running in the background.
+func (c *Checker) run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{Status: StatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LivenessHandler responds with 200 OK as long as the process serves HTTP. It checks no dependencies,
+// a database outage must not make the orchestrator restart the service.
+func (c *Checker) LivenessHandler(w http.ResponseWriter, _ *http.Request) {
+	writeReport(w, Report{Status: StatusOK})
+}
+
+// ReadinessHandler responds with 200 OK and the result of every check if the service is ready,
+// or with 503 Service Unavailable otherwise.
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, c.Ready(r.Context()))
+}
+
+// writeReport writes the report as JSON with the status code matching its status.
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == StatusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// FileWritable returns a check that the directory of path accepts new files, e.g. for a storage file
+// which is rewritten on compaction.
+func FileWritable(path string) Check {
+	return func(_ context.Context) error {
+		file, err := os.CreateTemp(filepath.Dir(path), ".healthcheck-*")
+		if err != nil {
+			return err
+		}
+		name := file.Name()
+		err = file.Close()
+		return errors.Join(err, os.Remove(name))
+	}
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ready(t *testing.T, checker *Checker) (int, Report) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	checker.ReadinessHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var report Report
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	return w.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	checker := New(50 * time.Millisecond)
+	var dbErr error
+	checker.Register("database", func(ctx context.Context) error { return dbErr })
+	checker.Register("file", FileWritable(filepath.Join(t.TempDir(), "db.json")))
+
+	code, report := ready(t, checker)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+	assert.Equal(t, StatusOK, report.Checks["file"].Status)
+
+	dbErr = errors.New("connection refused")
+	code, report = ready(t, checker)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, CheckResult{Status: StatusFail, Error: "connection refused", Duration: report.Checks["database"].Duration}, report.Checks["database"])
+	assert.Equal(t, StatusOK, report.Checks["file"].Status, "every check is reported")
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	checker := New(20 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	checker.Register("hanging", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	start := time.Now()
+	code, report := ready(t, checker)
+	assert.Less(t, time.Since(start), time.Second, "a check ignoring its context doesn't block the endpoint")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["hanging"].Error)
+}
+
+func TestShuttingDown(t *testing.T) {
+	checker := New(time.Second)
+	checker.Register("database", func(ctx context.Context) error { return nil })
+	code, _ := ready(t, checker)
+	require.Equal(t, http.StatusOK, code)
+
+	checker.SetShuttingDown()
+	code, report := ready(t, checker)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, ErrShuttingDown.Error(), report.Checks["shutdown"].Error)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+
+	w := httptest.NewRecorder()
+	checker.LivenessHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "the service is alive while it shuts down")
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestFileWritable(t *testing.T) {
+	dir := t.TempDir()
+	check := FileWritable(filepath.Join(dir, "db.json"))
+	require.NoError(t, check(context.Background()))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the probe file is removed")
+
+	assert.Error(t, FileWritable(filepath.Join(dir, "missing", "db.json"))(context.Background()))
+}
@@ -0,0 +1,238 @@
+package logger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Access log formats supported by NewAccessLog.
+const (
+	// AccessFormatCombined is the Apache Combined Log Format, followed by the duration of the request
+	// in microseconds and the resolved short key, as nginx-style tooling expects it.
+	AccessFormatCombined = "combined"
+	// AccessFormatJSON writes a request per line as a JSON object.
+	AccessFormatJSON = "json"
+)
+
+const (
+	// accessLogQueueSize bounds the number of lines waiting to be written; lines beyond it are dropped
+	// rather than blocking the request.
+	accessLogQueueSize = 4096
+	// accessLogBufferSize is the size of the write buffer in front of the access log file.
+	accessLogBufferSize = 64 << 10
+	// accessLogFlushInterval is how often the buffered lines are flushed to the file.
+	accessLogFlushInterval = time.Second
+	// clfTimeFormat is the time format of the Common Log Format.
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// accessEntry holds what the handlers learn about a request that the access log middleware can't see,
+// it is filled in through SetAccessUserID and SetAccessShortKey.
+type accessEntry struct {
+	mutex    sync.Mutex
+	userID   int
+	shortKey string
+}
+
+// SetAccessUserID records the ID of the user making the request for the access log.
+// It does nothing if the access log is disabled.
+func SetAccessUserID(ctx context.Context, userID int) {
+	if entry, ok := ctx.Value(accessEntryKey).(*accessEntry); ok {
+		defer entry.mutex.Unlock()
+		entry.mutex.Lock()
+		entry.userID = userID
+	}
+}
+
+// SetAccessShortKey records the short key the request resolved for the access log.
+// It does nothing if the access log is disabled.
+func SetAccessShortKey(ctx context.Context, key string) {
+	if entry, ok := ctx.Value(accessEntryKey).(*accessEntry); ok {
+		defer entry.mutex.Unlock()
+		entry.mutex.Lock()
+		entry.shortKey = key
+	}
+}
+
+// values returns the recorded user ID and short key.
+func (entry *accessEntry) values() (int, string) {
+	defer entry.mutex.Unlock()
+	entry.mutex.Lock()
+	return entry.userID, entry.shortKey
+}
+
+// accessRecord is a line of the access log.
+type accessRecord struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remote_ip"`
+	UserID    int       `json:"user_id,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+	ShortKey  string    `json:"short_key,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// AccessLog writes a line per request to a stream separate from the application log.
+// The lines are queued and written by a background goroutine through a buffer, so a slow disk doesn't add
+// to the latency of the requests; when the queue is full the lines are dropped and counted.
+type AccessLog struct {
+	format  string
+	out     io.WriteCloser
+	queue   chan []byte
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// NewAccessLog creates an access log writing lines in the format, combined or json, to out
+// and starts its background writer. Close stops it.
+func NewAccessLog(out io.WriteCloser, format string) (*AccessLog, error) {
+	switch format {
+	case "":
+		format = AccessFormatCombined
+	case AccessFormatCombined, AccessFormatJSON:
+	default:
+		return nil, errors.New("unsupported access log format " + format)
+	}
+	a := &AccessLog{
+		format: format,
+		out:    out,
+		queue:  make(chan []byte, accessLogQueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+// Dropped returns the number of lines dropped because the queue was full.
+func (a *AccessLog) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Close writes the queued lines, flushes the buffer and closes the underlying stream.
+// Lines logged after Close are discarded.
+func (a *AccessLog) Close() error {
+	a.once.Do(func() { close(a.stop) })
+	<-a.done
+	return a.out.Close()
+}
+
+// log formats the record and queues it without blocking.
+func (a *AccessLog) log(record *accessRecord) {
+	var line []byte
+	if a.format == AccessFormatJSON {
+		line, _ = json.Marshal(record)
+		line = append(line, '\n')
+	} else {
+		line = appendCombined(nil, record)
+	}
+	select {
+	case a.queue <- line:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// run writes the queued lines until the access log is closed.
+func (a *AccessLog) run() {
+	defer close(a.done)
+	w := bufio.NewWriterSize(a.out, accessLogBufferSize)
+	ticker := time.NewTicker(accessLogFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line := <-a.queue:
+			_, _ = w.Write(line)
+		case <-ticker.C:
+			_ = w.Flush()
+		case <-a.stop:
+			for {
+				select {
+				case line := <-a.queue:
+					_, _ = w.Write(line)
+				default:
+					_ = w.Flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// appendCombined appends the record in the Combined Log Format followed by the duration and the short key:
+//
+//	203.0.113.7 - 42 [19/Oct/2026:10:00:00 +0000] "GET /abc HTTP/1.1" 307 0 "-" "curl/8.0" 512 "abc"
+func appendCombined(b []byte, record *accessRecord) []byte {
+	b = append(b, record.RemoteIP...)
+	b = append(b, " - "...)
+	if record.UserID != 0 {
+		b = strconv.AppendInt(b, int64(record.UserID), 10)
+	} else {
+		b = append(b, '-')
+	}
+	b = append(b, " ["...)
+	b = record.Time.AppendFormat(b, clfTimeFormat)
+	b = append(b, "] \""...)
+	b = appendEscaped(b, record.Method+" "+record.URI+" "+record.Proto)
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(record.Status), 10)
+	b = append(b, ' ')
+	if record.Bytes > 0 {
+		b = strconv.AppendInt(b, int64(record.Bytes), 10)
+	} else {
+		b = append(b, '-')
+	}
+	b = append(b, " \""...)
+	b = appendEscaped(b, record.Referer)
+	b = append(b, "\" \""...)
+	b = appendEscaped(b, record.UserAgent)
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(record.Duration*1000), 10)
+	b = append(b, " \""...)
+	b = appendEscaped(b, record.ShortKey)
+	return append(b, "\"\n"...)
+}
+
+// appendEscaped appends a quoted field of the Combined Log Format: empty values become "-", and quotes,
+// backslashes and non-printable bytes are escaped the way nginx does, so a client can't forge log lines.
+func appendEscaped(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, '-')
+	}
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' || c < ' ' || c > '~' {
+			b = append(b, '\\', 'x', hex[c>>4], hex[c&0xF])
+			continue
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// remoteIP returns the IP address of the client without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
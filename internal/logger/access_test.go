@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// buffer is an in-memory access log stream.
+type buffer struct {
+	mutex  sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	defer b.mutex.Unlock()
+	b.mutex.Lock()
+	return b.buf.Write(p)
+}
+
+func (b *buffer) Close() error {
+	defer b.mutex.Unlock()
+	b.mutex.Lock()
+	b.closed = true
+	return nil
+}
+
+func newAccessRouter(t *testing.T, format string) (*chi.Mux, *Logger, *buffer) {
+	t.Helper()
+	out := &buffer{}
+	access, err := NewAccessLog(out, format)
+	require.NoError(t, err)
+	log := &Logger{Log: zap.NewNop(), access: access}
+	r := chi.NewRouter()
+	r.Use(log.RequestIDMiddleware)
+	r.Use(log.Middleware)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		SetAccessUserID(r.Context(), 42)
+		SetAccessShortKey(r.Context(), chi.URLParam(r, "id"))
+		w.Header().Set("Location", "https://example.com")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	r.Get("/ping", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+	return r, log, out
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	r, log, out := newAccessRouter(t, AccessFormatCombined)
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.Header.Set("Referer", "https://ref.example/")
+	req.Header.Set("User-Agent", `evil"agent`+"\n")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.RemoteAddr = "[2001:db8::1]:443"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, log.Close())
+
+	assert.True(t, out.closed)
+	lines := strings.Split(out.buf.String(), "\n")
+	require.Len(t, lines, 3)
+	assert.Regexp(t, `^203\.0\.113\.7 - 42 \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /abc HTTP/1\.1" 307 - "https://ref\.example/" "evil\\x22agent\\x0A" \d+ "abc"$`, lines[0])
+	assert.Regexp(t, `^2001:db8::1 - - \[.+\] "GET /ping HTTP/1\.1" 200 4 "-" "-" \d+ "-"$`, lines[1])
+	assert.Empty(t, lines[2])
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	r, log, out := newAccessRouter(t, AccessFormatJSON)
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	req.Header.Set("User-Agent", "curl/8.0")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, log.Close())
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(out.buf.Bytes(), &line))
+	assert.Equal(t, "192.0.2.1", line["remote_ip"])
+	assert.Equal(t, float64(42), line["user_id"])
+	assert.Equal(t, "GET", line["method"])
+	assert.Equal(t, "/abc", line["uri"])
+	assert.Equal(t, float64(http.StatusTemporaryRedirect), line["status"])
+	assert.Equal(t, "curl/8.0", line["user_agent"])
+	assert.Equal(t, "abc", line["short_key"])
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Contains(t, line, "duration_ms")
+	assert.NotContains(t, line, "referer")
+}
+
+func TestAccessLogDisabled(t *testing.T) {
+	log := &Logger{Log: zap.NewNop()}
+	r := chi.NewRouter()
+	r.Use(log.Middleware)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		SetAccessShortKey(r.Context(), "abc")
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abc", nil))
+	assert.Nil(t, log.AccessLog())
+	assert.NoError(t, log.Close())
+
+	_, err := NewAccessLog(&buffer{}, "xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader is the header carrying the ID of a request, honored when sent by the client and echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds the length of a request ID accepted from a client.
+const maxRequestIDLen = 128
+
+// contextKey is the type of the context keys of the package.
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+	accessEntryKey
+)
+
+// WithContext returns a copy of ctx carrying the logger, which Ctx returns for the context from then on.
+func WithContext(ctx context.Context, log *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, log)
+}
+
+// Ctx returns the logger of the request ctx belongs to, which carries the request ID, route and user ID,
+// or the base logger if ctx belongs to no request.
+//
+// Example usage:
+//
+//	h.urlService.Log.Ctx(req.Context()).Error("error during saving url", zap.Error(err))
+func (logger *Logger) Ctx(ctx context.Context) *zap.Logger {
+	if log, ok := ctx.Value(loggerKey).(*zap.Logger); ok {
+		return log
+	}
+	return logger.Log
+}
+
+// With returns a copy of ctx carrying the logger of ctx with the fields added.
+func (logger *Logger) With(ctx context.Context, fields ...zap.Field) context.Context {
+	return WithContext(ctx, logger.Ctx(ctx).With(fields...))
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, or an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// RequestIDMiddleware assigns an ID to every request: the X-Request-ID sent by the client if it is valid,
+// or a newly generated one. The ID is echoed in the response and stored in the request context along with
+// a logger carrying it, so every line logged for the request through Ctx can be correlated.
+func (logger *Logger) RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = logger.With(ctx, zap.String("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RouteMiddleware adds the chi route pattern of the request, e.g. /{id}, to the logger of the request context.
+// The pattern is only known once the route is matched, so it must be installed on a chi group or with chi.With
+// rather than on the top-level router.
+func (logger *Logger) RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			r = r.WithContext(logger.With(r.Context(), zap.String("route", rctx.RoutePattern())))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether a request ID received from a client is safe to log and echo:
+// it must be non-empty, not too long and consist of printable ASCII characters other than space.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := &Logger{Log: zap.New(core)}
+	r := chi.NewRouter()
+	r.Use(log.RequestIDMiddleware)
+	r.Use(log.Middleware)
+	r.Group(func(r chi.Router) {
+		r.Use(log.RouteMiddleware)
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			ctx := log.With(r.Context(), zap.Int("user_id", 7))
+			log.Ctx(ctx).Info("handled", zap.String("request_id_in_context", RequestIDFromContext(ctx)))
+		})
+	})
+
+	tests := []struct {
+		name     string
+		incoming string
+		honored  bool
+	}{
+		{name: "honored", incoming: "req-42", honored: true},
+		{name: "generated", incoming: ""},
+		{name: "too long", incoming: strings.Repeat("a", maxRequestIDLen+1)},
+		{name: "control characters", incoming: "req\n42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.TakeAll()
+			req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			require.NotEmpty(t, id, "the request ID is echoed")
+			if tt.honored {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+			entries := logs.AllUntimed()
+			require.Len(t, entries, 2)
+			handled := entries[0].ContextMap()
+			assert.Equal(t, "handled", entries[0].Message)
+			assert.Equal(t, id, handled["request_id"])
+			assert.Equal(t, id, handled["request_id_in_context"])
+			assert.Equal(t, "/{id}", handled["route"])
+			assert.Equal(t, int64(7), handled["user_id"])
+			access := entries[1].ContextMap()
+			assert.Equal(t, id, access["request_id"], "the request line is correlated as well")
+			assert.Equal(t, "/{id}", access["route"])
+		})
+	}
+}
+
+func TestCtx(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := &Logger{Log: zap.New(core)}
+	log.Ctx(context.Background()).Info("background")
+	ctx := log.With(context.Background(), zap.String("request_id", "req-1"))
+	log.Ctx(ctx).Info("request")
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+	assert.Empty(t, entries[0].ContextMap(), "without a request the base logger is used")
+	assert.Equal(t, map[string]any{"request_id": "req-1"}, entries[1].ContextMap())
+	assert.Empty(t, RequestIDFromContext(ctx), "only the middleware assigns request IDs")
+}
@@ -1,10 +1,14 @@
 package logger
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
@@ -14,6 +18,11 @@ import (
 // Logger is a type that represents a logger instance.
 type Logger struct {
 	Log *zap.Logger
+	// Level is the minimum level of the logged entries, which can be changed while the service runs.
+	// It serves HTTP as well: GET returns the current level and PUT with a {"level":"debug"} body changes it.
+	Level  zap.AtomicLevel
+	closer io.Closer
+	access *AccessLog
 }
 
 // LevelMap is a map that associates string keys with zapcore.Level values. It is used to map logging levels from string representations to their corresponding zapcore.Level constants
@@ -26,15 +35,22 @@ var (
 	}
 )
 
+// Log formats supported by CreateLogger.
+const (
+	// FormatJSON writes an entry per line as a JSON object, for log collectors.
+	FormatJSON = "json"
+	// FormatConsole writes human-readable lines, for development.
+	FormatConsole = "console"
+)
+
 // CreateLogger creates a new Logger instance based on the provided configuration.
 // It takes a pointer to a LoggerCfg structure that specifies the logging configuration.
+// The entries are written to the standard output, the standard error or a file rotated by size, see LoggerCfg.Output.
+// With sampling enabled, only the first SamplingInitial entries with the same message and level
+// are logged every second and every SamplingThereafter-th one after that.
 // The function returns a pointer to a Logger instance and an error if any occurred.
 func CreateLogger(cfg *configuration.LoggerCfg) (*Logger, error) {
-	encoderCfg := zap.NewProductionEncoderConfig()
-	encoderCfg.TimeKey = "timestamp"
-	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
 	level := zapcore.DebugLevel
-	stdout := "stdout"
 	if cfg.Level != "" {
 		var ok bool
 		level, ok = LevelMap[cfg.Level]
@@ -42,18 +58,95 @@ func CreateLogger(cfg *configuration.LoggerCfg) (*Logger, error) {
 			return nil, errors.New("unsupported logging level " + cfg.Level)
 		}
 	}
-	config := zap.Config{
-		Level:         zap.NewAtomicLevelAt(level),
-		OutputPaths:   []string{stdout},
-		Encoding:      "json",
-		EncoderConfig: encoderCfg,
+	var encoder zapcore.Encoder
+	switch cfg.Format {
+	case "", FormatJSON:
+		encoderCfg := zap.NewProductionEncoderConfig()
+		encoderCfg.TimeKey = "timestamp"
+		encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(encoderCfg)
+	case FormatConsole:
+		encoderCfg := zap.NewDevelopmentEncoderConfig()
+		encoderCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderCfg)
+	default:
+		return nil, errors.New("unsupported logging format " + cfg.Format)
+	}
+	output, err := openOutput(cfg.Output, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var access *AccessLog
+	if cfg.AccessLog != "" {
+		accessOutput, err := openOutput(cfg.AccessLog, cfg)
+		if err != nil {
+			output.Close()
+			return nil, err
+		}
+		access, err = NewAccessLog(accessOutput, cfg.AccessLogFormat)
+		if err != nil {
+			accessOutput.Close()
+			output.Close()
+			return nil, err
+		}
+	}
+	atomicLevel := zap.NewAtomicLevelAt(level)
+	core := zapcore.NewCore(encoder, output, atomicLevel)
+	if cfg.SamplingInitial > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, cfg.SamplingInitial, cfg.SamplingThereafter)
 	}
-	logger := zap.Must(config.Build())
 	return &Logger{
-		Log: logger,
+		Log:    zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel), zap.ErrorOutput(zapcore.Lock(os.Stderr))),
+		Level:  atomicLevel,
+		closer: output,
+		access: access,
 	}, nil
 }
 
+// AccessLog returns the access log the Middleware writes to, or nil if it is disabled.
+func (logger *Logger) AccessLog() *AccessLog {
+	return logger.access
+}
+
+// Close flushes the buffered entries and closes the log files, if any.
+func (logger *Logger) Close() error {
+	var err error
+	if logger.access != nil {
+		err = logger.access.Close()
+	}
+	_ = logger.Log.Sync()
+	if logger.closer != nil {
+		err = errors.Join(err, logger.closer.Close())
+	}
+	return err
+}
+
+// output is a stream the log entries are written to.
+type output interface {
+	zapcore.WriteSyncer
+	io.Closer
+}
+
+// openOutput opens the standard output, the standard error or a file rotated as configured.
+func openOutput(path string, cfg *configuration.LoggerCfg) (output, error) {
+	switch path {
+	case "", "stdout":
+		return nopCloser{zapcore.Lock(os.Stdout)}, nil
+	case "stderr":
+		return nopCloser{zapcore.Lock(os.Stderr)}, nil
+	}
+	return openRotatingFile(path, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups)
+}
+
+// nopCloser is an output whose Close does nothing, so the standard streams stay open.
+type nopCloser struct {
+	zapcore.WriteSyncer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
 // Middleware is a method of the Logger struct that creates an HTTP middleware.
 // It takes an http.Handler as input and returns an http.Handler.
 // The returned handler performs logging for the incoming request and the corresponding response.
@@ -61,9 +154,15 @@ func CreateLogger(cfg *configuration.LoggerCfg) (*Logger, error) {
 // The captured information is then logged using the logger's Log.Info method.
 // The capturing is done using a loggingResponseWriter, which wraps the original http.ResponseWriter.
 // The captured response status and size are stored in a struct called responseData.
+// If the access log is enabled, a line is written to it as well, see AccessLog.
 func (logger *Logger) Middleware(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		var entry *accessEntry
+		if logger.access != nil {
+			entry = &accessEntry{}
+			r = r.WithContext(context.WithValue(r.Context(), accessEntryKey, entry))
+		}
 		uri := r.RequestURI
 		method := r.Method
 		responseData := &responseData{
@@ -76,17 +175,49 @@ func (logger *Logger) Middleware(next http.Handler) http.Handler {
 		}
 		next.ServeHTTP(&lw, r)
 		duration := time.Since(start)
-		logger.Log.Info("shorten path service ",
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		logger.Ctx(r.Context()).Info("shorten path service ",
 			zap.String("uri", uri),
+			zap.String("route", route),
 			zap.String("method", method),
 			zap.Duration("duration", duration),
 			zap.Int("status", responseData.status),
 			zap.Int("size", responseData.size),
 		)
+		if entry != nil {
+			logger.logAccess(r, entry, responseData, start, duration)
+		}
 	}
 	return http.HandlerFunc(fn)
 }
 
+// logAccess writes the access log line of the request.
+func (logger *Logger) logAccess(r *http.Request, entry *accessEntry, data *responseData, start time.Time, duration time.Duration) {
+	status := data.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	userID, shortKey := entry.values()
+	logger.access.log(&accessRecord{
+		Time:      start,
+		RemoteIP:  remoteIP(r),
+		UserID:    userID,
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Proto:     r.Proto,
+		Status:    status,
+		Bytes:     data.size,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		Duration:  float64(duration) / float64(time.Millisecond),
+		ShortKey:  shortKey,
+		RequestID: RequestIDFromContext(r.Context()),
+	})
+}
+
 // responseData is a type that represents the response data of an HTTP request.
 //
 // Fields:
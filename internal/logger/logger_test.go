@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/lookeme/short-url/internal/configuration"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3", "backups beyond the limit are removed")
+
+	f, err = openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "fifth\n", read(path), "the size of an existing file counts")
+	assert.Equal(t, "fourth\n", read(path+".1"))
+}
+
+func TestCreateLogger(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		cfg      configuration.LoggerCfg
+		contains string
+		wantErr  bool
+	}{
+		{name: "json", cfg: configuration.LoggerCfg{Level: "info", Format: FormatJSON}, contains: `"msg":"hello"`},
+		{name: "console", cfg: configuration.LoggerCfg{Level: "info", Format: FormatConsole}, contains: "\thello\t"},
+		{name: "unknown level", cfg: configuration.LoggerCfg{Level: "trace"}, wantErr: true},
+		{name: "unknown format", cfg: configuration.LoggerCfg{Format: "xml"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output = filepath.Join(dir, tt.name+".log")
+			log, err := CreateLogger(&tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			log.Log.Info("hello", zap.String("key", "value"))
+			log.Log.Debug("below the level")
+			require.NoError(t, log.Close())
+			data, err := os.ReadFile(tt.cfg.Output)
+			require.NoError(t, err)
+			assert.Contains(t, string(data), tt.contains)
+			assert.Equal(t, 1, strings.Count(string(data), "\n"))
+		})
+	}
+}
+
+func TestSampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	log, err := CreateLogger(&configuration.LoggerCfg{
+		Level:              "info",
+		Output:             path,
+		SamplingInitial:    2,
+		SamplingThereafter: 5,
+	})
+	require.NoError(t, err)
+	for i := 0; i < 12; i++ {
+		log.Log.Info("repeated")
+	}
+	require.NoError(t, log.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 4, strings.Count(string(data), "repeated"), "the 1st, 2nd, 7th and 12th entries are logged")
+}
+
+func TestLevelHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	log, err := CreateLogger(&configuration.LoggerCfg{Level: "info", Output: path})
+	require.NoError(t, err)
+	defer log.Close()
+	assert.False(t, log.Log.Core().Enabled(zapcore.DebugLevel))
+
+	w := httptest.NewRecorder()
+	log.Level.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader(`{"level":"debug"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, log.Log.Core().Enabled(zapcore.DebugLevel), "the level changes without recreating the logger")
+
+	w = httptest.NewRecorder()
+	log.Level.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/log/level", nil))
+	assert.JSONEq(t, `{"level":"debug"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	log.Level.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader(`{"level":"loud"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
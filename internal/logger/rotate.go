@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file which is rotated once it grows beyond maxSize bytes:
+// the file is renamed to path.1, the older backups are shifted to path.2, path.3 and so on,
+// and the backups beyond maxBackups are removed.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mutex      sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// openRotatingFile opens the log file at path for appending; a maxSize of zero disables rotation.
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends the entry to the file, rotating it first if the entry doesn't fit.
+// An entry larger than maxSize is written to a fresh file of its own.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	defer f.mutex.Unlock()
+	f.mutex.Lock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Sync flushes the file to the disk.
+func (f *rotatingFile) Sync() error {
+	defer f.mutex.Unlock()
+	f.mutex.Lock()
+	return f.file.Sync()
+}
+
+// Close closes the file.
+func (f *rotatingFile) Close() error {
+	defer f.mutex.Unlock()
+	f.mutex.Lock()
+	return f.file.Close()
+}
+
+// open opens the file at path and remembers its current size.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file to the first backup and opens a new one.
+// It must be called with the mutex held.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups > 0 {
+		os.Remove(f.backup(f.maxBackups))
+		for i := f.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(f.path, f.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// backup returns the path of the i-th backup.
+func (f *rotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	// httpRequests counts the handled requests by route pattern, method and status.
+	httpRequests = Default.NewCounterVec("shortener_http_requests_total",
+		"Number of handled HTTP requests.", "route", "method", "status")
+	// httpDuration measures the time spent handling requests by route pattern, method and status.
+	httpDuration = Default.NewHistogramVec("shortener_http_request_duration_seconds",
+		"Time spent handling HTTP requests.", DefBuckets, "route", "method", "status")
+)
+
+// unmatchedRoute labels the requests which didn't match any route, so scanners can't blow up the number of series.
+const unmatchedRoute = "unmatched"
+
+// Middleware counts the requests and measures their latency by chi route pattern, e.g. /{id}, rather than by path.
+// It must be installed on a chi router, which resolves the pattern while the request is handled.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := strconv.Itoa(sw.status())
+		httpRequests.Inc(route, r.Method, status)
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method, status)
+	})
+}
+
+// statusWriter captures the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+// WriteHeader captures the status code and writes it to the response.
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.code == 0 {
+		w.code = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the body, implying 200 OK if no status was written.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// status returns the captured status code; a handler which wrote nothing responded with 200 OK.
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
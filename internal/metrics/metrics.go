@@ -0,0 +1,375 @@
+// Package metrics implements a small registry of counters, gauges and histograms
+// exposed in the Prometheus text exposition format.
+//
+// Metrics are usually declared as package variables registered in the Default registry:
+//
+//	var urlsCreated = metrics.Default.NewCounter("shortener_urls_created_total", "Number of shortened URLs created.")
+//
+//	func create() {
+//	    urlsCreated.Inc()
+//	}
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry served on the metrics endpoint of the service.
+var Default = NewRegistry()
+
+// DefBuckets are the default upper bounds of histogram buckets, in seconds, suited to request latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric types of the exposition format.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Sample is a single value of a metric collected by a function.
+type Sample struct {
+	// LabelValues are the values of the labels of the metric, in the order of the label names.
+	LabelValues []string
+	Value       float64
+}
+
+// metric is a family of samples sharing a name.
+type metric interface {
+	// write writes the samples of the metric with the given name in the exposition format,
+	// without the HELP and TYPE lines.
+	write(w *bufio.Writer, name string)
+}
+
+// family describes a registered metric.
+type family struct {
+	name   string
+	help   string
+	typ    string
+	metric metric
+}
+
+// Registry is a set of metrics written together.
+type Registry struct {
+	families map[string]family
+	mutex    sync.RWMutex
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// register adds the metric to the registry, replacing a metric with the same name.
+// Replacing makes function metrics follow the latest instance of the object they observe, e.g. a reopened storage.
+func (r *Registry) register(name, help, typ string, m metric) {
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	r.families[name] = family{name: name, help: help, typ: typ, metric: m}
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return &Counter{vec: r.NewCounterVec(name, help)}
+}
+
+// NewCounterVec registers a counter partitioned by the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{labels: labels, values: make(map[string]*sample)}
+	r.register(name, help, typeCounter, c)
+	return c
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds partitioned by the given labels.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+	r.register(name, help, typeHistogram, h)
+	return h
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is returned by fn when the metrics are written.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.NewFunc(name, help, false, nil, func() []Sample {
+		return []Sample{{Value: fn()}}
+	})
+}
+
+// NewFunc registers a gauge, or a counter if counter is set, whose samples are returned by fn when the metrics are written.
+// It suits values kept by other components, like the statistics of a connection pool.
+func (r *Registry) NewFunc(name, help string, counter bool, labels []string, fn func() []Sample) {
+	typ := typeGauge
+	if counter {
+		typ = typeCounter
+	}
+	r.register(name, help, typ, &funcMetric{labels: labels, fn: fn})
+}
+
+// WriteTo writes all metrics ordered by name in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.RLock()
+	families := make([]family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.RUnlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		f.metric.write(bw, f.name)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns an http.Handler serving the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	vec *CounterVec
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.vec.Add(1)
+}
+
+// Add increases the counter by v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	c.vec.Add(v)
+}
+
+// sample is the value of a metric for a single combination of label values.
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	labels []string
+	values map[string]*sample
+	mutex  sync.Mutex
+}
+
+// Inc increments the counter with the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter with the given label values by v, which must not be negative.
+// It panics if the number of label values doesn't match the number of labels.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+	defer c.mutex.Unlock()
+	c.mutex.Lock()
+	s, ok := c.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = s
+	}
+	s.value += v
+}
+
+// write implements metric.
+func (c *CounterVec) write(w *bufio.Writer, name string) {
+	c.mutex.Lock()
+	samples := make([]sample, 0, len(c.values))
+	for _, s := range c.values {
+		samples = append(samples, *s)
+	}
+	c.mutex.Unlock()
+	sortSamples(samples)
+	for _, s := range samples {
+		writeSample(w, name, c.labels, s.labelValues, s.value)
+	}
+}
+
+// histogram is the state of a histogram for a single combination of label values.
+type histogram struct {
+	labelValues []string
+	// counts holds the number of observations in every bucket, not cumulative; the last one is +Inf.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec counts observations in configurable buckets, partitioned by labels.
+type HistogramVec struct {
+	labels  []string
+	buckets []float64
+	values  map[string]*histogram
+	mutex   sync.Mutex
+}
+
+// Observe adds a single observation to the histogram with the given label values.
+// It panics if the number of label values doesn't match the number of labels.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := labelKey(h.labels, labelValues)
+	i := sort.SearchFloat64s(h.buckets, v)
+	defer h.mutex.Unlock()
+	h.mutex.Lock()
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogram{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// write implements metric.
+func (h *HistogramVec) write(w *bufio.Writer, name string) {
+	h.mutex.Lock()
+	values := make([]histogram, 0, len(h.values))
+	for _, s := range h.values {
+		c := *s
+		c.counts = append([]uint64(nil), s.counts...)
+		values = append(values, c)
+	}
+	h.mutex.Unlock()
+	sort.Slice(values, func(i, j int) bool {
+		return lessLabelValues(values[i].labelValues, values[j].labelValues)
+	})
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, s := range values {
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatFloat(h.buckets[i])
+			}
+			writeSample(w, name+"_bucket", bucketLabels, append(append([]string(nil), s.labelValues...), le), float64(cumulative))
+		}
+		writeSample(w, name+"_sum", h.labels, s.labelValues, s.sum)
+		writeSample(w, name+"_count", h.labels, s.labelValues, float64(s.count))
+	}
+}
+
+// funcMetric collects its samples from a function when the metrics are written.
+type funcMetric struct {
+	labels []string
+	fn     func() []Sample
+}
+
+// write implements metric.
+func (f *funcMetric) write(w *bufio.Writer, name string) {
+	samples := f.fn()
+	sorted := make([]sample, 0, len(samples))
+	for _, s := range samples {
+		sorted = append(sorted, sample{labelValues: s.LabelValues, value: s.Value})
+	}
+	sortSamples(sorted)
+	for _, s := range sorted {
+		writeSample(w, name, f.labels, s.labelValues, s.value)
+	}
+}
+
+// writeSample writes a single sample line.
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabelValue(labelValues[i]))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// labelKey joins the label values into a map key.
+// It panics if the number of label values doesn't match the number of labels, like a misspelled metric would.
+func labelKey(labels, labelValues []string) string {
+	if len(labels) != len(labelValues) {
+		panic(fmt.Sprintf("metrics: %d label values given for labels %v", len(labelValues), labels))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// sortSamples orders the samples by their label values, so the output is stable.
+func sortSamples(samples []sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return lessLabelValues(samples[i].labelValues, samples[j].labelValues)
+	})
+}
+
+// lessLabelValues compares label values lexicographically.
+func lessLabelValues(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// formatFloat formats a value the way Prometheus expects it.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelValueReplacer escapes backslashes, double quotes and line feeds in label values.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes a label value for the exposition format.
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+// helpReplacer escapes backslashes and line feeds in help texts.
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// escapeHelp escapes a help text for the exposition format.
+func escapeHelp(v string) string {
+	return helpReplacer.Replace(v)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Number of requests.", "route", "status")
+	requests.Inc("/{id}", "307")
+	requests.Inc("/{id}", "307")
+	requests.Add(3, `/"quoted"`, "200")
+	created := r.NewCounter("created_total", "Number of\ncreated URLs.")
+	created.Inc()
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, "/")
+	latency.Observe(0.5, "/")
+	latency.Observe(5, "/")
+	r.NewGaugeFunc("size", "Size.", func() float64 { return 42 })
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, `# HELP created_total Number of\ncreated URLs.
+# TYPE created_total counter
+created_total 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/",le="0.1"} 1
+latency_seconds_bucket{route="/",le="1"} 2
+latency_seconds_bucket{route="/",le="+Inf"} 3
+latency_seconds_sum{route="/"} 5.55
+latency_seconds_count{route="/"} 3
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="/\"quoted\"",status="200"} 3
+requests_total{route="/{id}",status="307"} 2
+# HELP size Size.
+# TYPE size gauge
+size 42
+`, buf.String())
+
+	assert.Panics(t, func() { requests.Inc("/") })
+}
+
+func TestMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	r.Get("/ping", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	for _, path := range []string{"/abc", "/def", "/ping", "/a/b/c"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	Default.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(t, body, `shortener_http_requests_total{route="/{id}",method="GET",status="307"} 2`)
+	assert.Contains(t, body, `shortener_http_requests_total{route="/ping",method="GET",status="200"} 1`)
+	assert.Contains(t, body, `shortener_http_requests_total{route="unmatched",method="GET",status="404"} 1`)
+	assert.Contains(t, body, `shortener_http_request_duration_seconds_count{route="/{id}",method="GET",status="307"} 2`)
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RegisterPgxPools registers the statistics of the connection pools returned by pools, keyed by the pool label,
+// e.g. "primary" and the addresses of the replicas.
+func (r *Registry) RegisterPgxPools(pools func() map[string]*pgxpool.Stat) {
+	gauge := func(name, help string, value func(*pgxpool.Stat) float64) {
+		r.NewFunc(name, help, false, []string{"pool"}, poolSamples(pools, value))
+	}
+	counter := func(name, help string, value func(*pgxpool.Stat) float64) {
+		r.NewFunc(name, help, true, []string{"pool"}, poolSamples(pools, value))
+	}
+	gauge("shortener_pgxpool_total_conns", "Number of connections in the pool.",
+		func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) })
+	gauge("shortener_pgxpool_idle_conns", "Number of idle connections in the pool.",
+		func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) })
+	gauge("shortener_pgxpool_acquired_conns", "Number of connections currently in use.",
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) })
+	gauge("shortener_pgxpool_max_conns", "Maximum size of the pool.",
+		func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) })
+	counter("shortener_pgxpool_acquires_total", "Number of successful acquires from the pool.",
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) })
+	counter("shortener_pgxpool_empty_acquires_total", "Number of acquires which waited for a connection because the pool was empty.",
+		func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) })
+	counter("shortener_pgxpool_acquire_duration_seconds_total", "Total time spent acquiring connections.",
+		func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() })
+}
+
+// poolSamples returns a function collecting a value of every pool.
+func poolSamples(pools func() map[string]*pgxpool.Stat, value func(*pgxpool.Stat) float64) func() []Sample {
+	return func() []Sample {
+		stats := pools()
+		samples := make([]Sample, 0, len(stats))
+		for pool, stat := range stats {
+			samples = append(samples, Sample{LabelValues: []string{pool}, Value: value(stat)})
+		}
+		return samples
+	}
+}
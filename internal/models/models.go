@@ -3,17 +3,20 @@ package models
 
 import (
 	"fmt"
+	"time"
 )
 
 // Request represents the request for URL shortening with a single URL.
 type Request struct {
-	URL string `json:"url"`
+	URL  string   `json:"url"`
+	Tags []string `json:"tags,omitempty"`
 }
 
 // BatchRequest represents a request for URL shortening with multiple URLs, each associated with a correlation ID.
 type BatchRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string   `json:"correlation_id"`
+	OriginalURL   string   `json:"original_url"`
+	Tags          []string `json:"tags,omitempty"`
 }
 
 // BatchResponse represents the response to a BatchRequest, including the correlation ID and the shortened URL.
@@ -29,12 +32,35 @@ type Response struct {
 
 // ShortenData encapsulates all data associated with a shortened URL, including metadata like the user ID who created it.
 type ShortenData struct {
-	ID            int64  `json:"-"`
-	CorrelationID string `json:"-"`
-	ShortURL      string `json:"short_url"`
-	OriginalURL   string `json:"original_url"`
-	UserID        int    `json:"-"`
-	DeletedFlag   bool   `db:"is_deleted"`
+	ID            int64      `json:"-"`
+	CorrelationID string     `json:"-"`
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	UserID        int        `json:"-"`
+	DeletedFlag   bool       `db:"is_deleted"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" db:"-"`
+	Tags          []string   `json:"tags,omitempty" db:"-"`
+}
+
+// UpdateRequest represents a partial update of a shortened URL owned by a user.
+// Fields left nil are not changed.
+type UpdateRequest struct {
+	OriginalURL *string   `json:"original_url,omitempty"`
+	Tags        *[]string `json:"tags,omitempty"`
+}
+
+// URLHistory represents a previous destination of a shortened URL and the user who replaced it.
+type URLHistory struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	ChangedBy   int       `json:"changed_by"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// TagCount represents a tag together with the number of user URLs marked with it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
 
 // User represents the details of a user in the system, including their ID, name, and password.
@@ -0,0 +1,72 @@
+// Package problem provides the RFC 7807 problem details the API responds with on errors,
+// so the handlers and the middleware share a single error model.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType is the media type of the error responses, see RFC 7807.
+const ContentType = "application/problem+json"
+
+// typePrefix prefixes the machine-readable code of a problem to form its type URI.
+const typePrefix = "urn:short-url:problem:"
+
+// Machine-readable codes of the problems the API responds with.
+const (
+	CodeMalformedRequest     = "malformed_request"
+	CodeInvalidURL           = "invalid_url"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeGone                 = "gone"
+	CodeConflict             = "conflict"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeBatchTooLarge        = "batch_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInternal             = "internal"
+)
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	// Type is a URI identifying the problem type, it ends with Code.
+	Type string `json:"type"`
+	// Title is a short human-readable summary of the problem type.
+	Title string `json:"title"`
+	// Status is the HTTP status code of the response.
+	Status int `json:"status"`
+	// Detail explains this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request the problem occurred with.
+	Instance string `json:"instance,omitempty"`
+	// Code is the machine-readable code of the problem type.
+	Code string `json:"code"`
+}
+
+// New returns the problem with the status and the machine-readable code, explained by detail.
+func New(status int, code, detail string) Problem {
+	return Problem{
+		Type:   typePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// PayloadTooLarge returns the problem of a request body beyond the limit of err.
+// Both the middleware and the handlers answer a body too large with it, wherever the limit trips.
+func PayloadTooLarge(err *http.MaxBytesError) Problem {
+	return New(http.StatusRequestEntityTooLarge, CodePayloadTooLarge, err.Error())
+}
+
+// Write responds to r with p, taking the instance of the problem from the path of r.
+func Write(w http.ResponseWriter, r *http.Request, p Problem) {
+	p.Instance = r.URL.Path
+	b, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(b)
+}
@@ -2,12 +2,15 @@
 package security
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/lookeme/short-url/internal/app/domain/user"
 	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/problem"
 	"github.com/lookeme/short-url/internal/utils"
 	"go.uber.org/zap"
 )
@@ -28,12 +31,7 @@ type Authorization struct {
 //   - `GetUserID`: Parses and verifies a JWT token string and returns the user ID.
 //     Example usage:
 //     ```go
-//     userID := GetUserID(tokenString)
-//     ```
-//   - `verifyToken`: Verifies the validity of a JWT token string.
-//     Example usage:
-//     ```go
-//     isValid := auth.verifyToken(tokenString)
+//     userID, err := GetUserID(tokenString)
 //     ```
 const SecretKey = "secret-key"
 
@@ -62,6 +60,26 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// ErrInvalidToken is returned for a token which doesn't carry a user ID.
+var ErrInvalidToken = errors.New("token is invalid")
+
+// contextKey is the type of the context keys of the package.
+type contextKey int
+
+const userIDKey contextKey = iota
+
+// WithUserID returns a copy of ctx carrying the ID of the verified user, which UserIDFromContext returns.
+func WithUserID(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// UserIDFromContext returns the ID of the user AuthMiddleware verified for the request ctx belongs to.
+// It returns false if the request didn't pass through AuthMiddleware.
+func UserIDFromContext(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDKey).(int)
+	return userID, ok
+}
+
 // New constructs a new instance of Authorization with a user service and logger.
 func New(userService *user.UsrService, logger *logger.Logger) *Authorization {
 	return &Authorization{
@@ -71,30 +89,39 @@ func New(userService *user.UsrService, logger *logger.Logger) *Authorization {
 }
 
 // AuthMiddleware is a middleware function that checks for a valid JWT in the Authorization header of the HTTP request.
-// If no token is provided, a new user is created and a JWT is generated and sent back in the response.
+// If no token or an invalid one is provided, a new user is created and a JWT is generated and sent back in the response.
+// The ID of the verified or created user is stored in the request context, see UserIDFromContext;
+// the handlers must take it from there rather than from the header, which is sent by the client as is.
 func (auth *Authorization) AuthMiddleware(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		var bearer = "Bearer "
-		token := r.Header.Get("Authorization")
-		token, err := utils.GetToken(token)
-		if err != nil || !auth.verifyToken(token) {
-			usr, err := auth.userService.CreateUser()
+		token, err := utils.GetToken(r.Header.Get("Authorization"))
+		var userID int
+		if err == nil {
+			if userID, err = GetUserID(token); err != nil {
+				auth.Log.Ctx(r.Context()).Info("invalid token, creating a new user", zap.Error(err))
+			}
+		}
+		if err != nil {
+			usr, err := auth.userService.CreateUser(r.Context())
 			if err != nil {
-				w.WriteHeader(http.StatusUnauthorized)
+				auth.Log.Ctx(r.Context()).Error("error during creating user", zap.Error(err))
+				problem.Write(w, r, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "user can't be created"))
 				return
 			}
 			token, err = auth.BuildJWTString(usr.UserID)
 			if err != nil {
-				w.WriteHeader(http.StatusUnauthorized)
+				auth.Log.Ctx(r.Context()).Error("error during building token", zap.Error(err))
+				problem.Write(w, r, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "token can't be issued"))
 				return
 			}
-			bearer += token
-			r.Header.Add("Authorization", bearer)
-		} else {
-			bearer += token
+			userID = usr.UserID
 		}
+		bearer += token
 		w.Header().Set("Authorization", bearer)
-		next.ServeHTTP(w, r)
+		logger.SetAccessUserID(r.Context(), userID)
+		ctx := WithUserID(r.Context(), userID)
+		next.ServeHTTP(w, r.WithContext(auth.Log.With(ctx, zap.Int("user_id", userID))))
 	}
 	return http.HandlerFunc(fn)
 }
@@ -114,26 +141,18 @@ func (auth *Authorization) BuildJWTString(userID int) (string, error) {
 	return tokenString, nil
 }
 
-// GetUserID retrieves a user ID from a given JWT.
-func GetUserID(tokenString string) int {
+// GetUserID retrieves a user ID from a given JWT. It returns an error unless the token is signed with SecretKey,
+// hasn't expired and carries a user ID, so the claims of a rejected token are never trusted.
+func GetUserID(tokenString string) (int, error) {
 	var claims Claims
-	jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
 		return []byte(SecretKey), nil
-	})
-	return claims.UserID
-}
-
-// verifyToken is a method that takes a token string as input and verifies its validity using JWT.
-// It returns true if the token is valid, otherwise false.
-// If there is an error during verification, it logs the error and returns false.
-func (auth *Authorization) verifyToken(tokenString string) bool {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(SecretKey), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
-		auth.Log.Log.Error("Error during verifying token", zap.String("error", err.Error()))
-		return false
+		return 0, err
+	}
+	if !token.Valid || claims.UserID == 0 {
+		return 0, ErrInvalidToken
 	}
-	return token.Valid
+	return claims.UserID, nil
 }
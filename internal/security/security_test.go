@@ -0,0 +1,111 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/app/domain/user"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/problem"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/storage/inmemory"
+)
+
+// failingUserRepository fails to save every user.
+type failingUserRepository struct {
+	storage.UserRepository
+}
+
+func (failingUserRepository) SaveUser(context.Context, string, string) (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingUserRepository) FindByID(context.Context, int) (models.User, error) {
+	return models.User{}, errors.New("connection refused")
+}
+
+func TestAuthMiddlewareFailure(t *testing.T) {
+	zlog := &logger.Logger{Log: zap.NewNop()}
+	usrService := user.NewUserService(failingUserRepository{}, zlog)
+	auth := New(&usrService, zlog)
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Fatal("the request is passed on without a user")
+	})
+
+	w := httptest.NewRecorder()
+	auth.AuthMiddleware(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/user/urls", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	var p problem.Problem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+	assert.Equal(t, problem.CodeUnauthorized, p.Code)
+	assert.Equal(t, "/api/user/urls", p.Instance)
+	assert.NotContains(t, p.Detail, "connection refused", "the cause isn't disclosed")
+}
+
+func TestGetUserID(t *testing.T) {
+	auth := New(nil, &logger.Logger{Log: zap.NewNop()})
+	token, err := auth.BuildJWTString(7)
+	require.NoError(t, err)
+	userID, err := GetUserID(token)
+	require.NoError(t, err)
+	assert.Equal(t, 7, userID)
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))},
+		UserID:           7,
+	}).SignedString([]byte(SecretKey))
+	require.NoError(t, err)
+	withoutUser, err := auth.BuildJWTString(0)
+	require.NoError(t, err)
+	for name, token := range map[string]string{"expired": expired, "without user": withoutUser, "malformed": "token"} {
+		userID, err := GetUserID(token)
+		assert.Error(t, err, name)
+		assert.Zero(t, userID, "%s: the claims of a rejected token aren't returned", name)
+	}
+}
+
+func TestAuthMiddlewareStoresUser(t *testing.T) {
+	zlog := &logger.Logger{Log: zap.NewNop()}
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, zlog)
+	require.NoError(t, err)
+	usrService := user.NewUserService(usrStorage, zlog)
+	auth := New(&usrService, zlog)
+	var seen []int
+	handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := UserIDFromContext(r.Context())
+		require.True(t, ok)
+		seen = append(seen, userID)
+	}))
+	serve := func(token string) string {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return strings.TrimPrefix(w.Header().Get("Authorization"), "Bearer ")
+	}
+
+	token := serve("")
+	assert.Equal(t, token, serve(token), "a valid token is kept")
+	assert.NotEqual(t, token, serve(token+"junk"), "an invalid token is replaced")
+	require.Len(t, seen, 3)
+	assert.Equal(t, seen[0], seen[1])
+	assert.NotEqual(t, seen[0], seen[2])
+
+	_, ok := UserIDFromContext(context.Background())
+	assert.False(t, ok)
+}
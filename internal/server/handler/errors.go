@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/app/domain/shorten"
+	"github.com/lookeme/short-url/internal/app/domain/user"
+	"github.com/lookeme/short-url/internal/problem"
+	"github.com/lookeme/short-url/internal/storage"
+)
+
+// errMalformedRequest is wrapped by the errors caused by a request the handlers can't make sense of.
+var errMalformedRequest = errors.New("malformed request")
+
+// errGone is returned for a short URL which was deleted.
+var errGone = errors.New("short url is deleted")
+
+// malformed marks err as caused by a malformed request.
+func malformed(err error) error {
+	return fmt.Errorf("%w: %w", errMalformedRequest, err)
+}
+
+// problemTypes maps the domain errors to the status codes and problem codes of the responses.
+// The first entry err matches wins.
+var problemTypes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{err: errMalformedRequest, status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+	{err: shorten.ErrInvalidURL, status: http.StatusBadRequest, code: problem.CodeInvalidURL},
+	{err: user.ErrUnauthorized, status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+	{err: shorten.ErrNotOwner, status: http.StatusForbidden, code: problem.CodeForbidden},
+	{err: storage.ErrNotFound, status: http.StatusNotFound, code: problem.CodeNotFound},
+	{err: errGone, status: http.StatusGone, code: problem.CodeGone},
+	{err: storage.ErrConflict, status: http.StatusConflict, code: problem.CodeConflict},
+	{err: shorten.ErrBatchTooLarge, status: http.StatusRequestEntityTooLarge, code: problem.CodeBatchTooLarge},
+}
+
+// problemFor maps err to the problem the API responds with.
+// The details of unexpected errors aren't disclosed to the client.
+func problemFor(err error) problem.Problem {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return problem.PayloadTooLarge(maxBytesErr)
+	}
+	status, code, detail := http.StatusInternalServerError, problem.CodeInternal, "internal server error"
+	for _, problemType := range problemTypes {
+		if errors.Is(err, problemType.err) {
+			status, code, detail = problemType.status, problemType.code, err.Error()
+			break
+		}
+	}
+	return problem.New(status, code, detail)
+}
+
+// writeError responds with the problem err maps to. Unexpected errors are logged.
+func (h *URLHandler) writeError(res http.ResponseWriter, req *http.Request, err error) {
+	p := problemFor(err)
+	if p.Status >= http.StatusInternalServerError {
+		h.urlService.Log.Ctx(req.Context()).Error("request failed", zap.Error(err))
+	}
+	problem.Write(res, req, p)
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/app/domain/shorten"
+	"github.com/lookeme/short-url/internal/app/domain/user"
+	"github.com/lookeme/short-url/internal/compression"
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/problem"
+	"github.com/lookeme/short-url/internal/security"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/storage/inmemory"
+)
+
+// failingRepository fails every lookup, listing and restoration with errStorage.
+type failingRepository struct {
+	storage.ShortenRepository
+}
+
+var errStorage = errors.New("connection refused")
+
+func (failingRepository) SaveAll(context.Context, []models.ShortenData) error {
+	return errStorage
+}
+
+func (failingRepository) FindByKey(context.Context, string) (models.ShortenData, error) {
+	return models.ShortenData{}, errStorage
+}
+
+func (failingRepository) FindAllByUserID(context.Context, int) ([]models.ShortenData, error) {
+	return nil, errStorage
+}
+
+func (failingRepository) CountTagsByUserID(context.Context, int) ([]models.TagCount, error) {
+	return nil, errStorage
+}
+
+func (failingRepository) RestoreByShortURL(context.Context, string, int, time.Time) (bool, error) {
+	return false, errStorage
+}
+
+func TestProblemFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{name: "malformed", err: malformed(errors.New("unexpected EOF")), status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{name: "invalid url", err: shorten.ErrInvalidURL, status: http.StatusBadRequest, code: problem.CodeInvalidURL},
+		{name: "unauthorized", err: user.ErrUnauthorized, status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "not owner", err: shorten.ErrNotOwner, status: http.StatusForbidden, code: problem.CodeForbidden},
+		{name: "not found", err: storage.ErrNotFound, status: http.StatusNotFound, code: problem.CodeNotFound},
+		{name: "gone", err: errGone, status: http.StatusGone, code: problem.CodeGone},
+		{name: "duplicate", err: storage.ErrConflict, status: http.StatusConflict, code: problem.CodeConflict},
+		{name: "batch too large", err: shorten.ErrBatchTooLarge, status: http.StatusRequestEntityTooLarge, code: problem.CodeBatchTooLarge},
+		{name: "body too large", err: &http.MaxBytesError{Limit: 1}, status: http.StatusRequestEntityTooLarge, code: problem.CodePayloadTooLarge},
+		{name: "unexpected", err: errStorage, status: http.StatusInternalServerError, code: problem.CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := problemFor(tt.err)
+			assert.Equal(t, tt.status, p.Status)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, "urn:short-url:problem:"+tt.code, p.Type)
+			assert.Equal(t, http.StatusText(tt.status), p.Title)
+		})
+	}
+	assert.Equal(t, "internal server error", problemFor(errStorage).Detail, "unexpected errors aren't disclosed")
+	wrapped := problemFor(errors.Join(errors.New("saving"), storage.ErrNotFound))
+	assert.Equal(t, problem.CodeNotFound, wrapped.Code, "wrapped errors are matched")
+}
+
+func TestHandlerFailures(t *testing.T) {
+	netCfg := configuration.NetworkCfg{BaseURL: "http://localhost:8080/", MaxBatchSize: 2}
+	stCfg := configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json"), DeletedGracePeriod: time.Hour}
+	cfg := configuration.Config{Network: &netCfg, Storage: &stCfg}
+	zlog := logger.Logger{Log: zap.NewNop()}
+	storageURL, err := inmemory.NewInMemShortenStorage(&stCfg, &zlog)
+	require.NoError(t, err)
+	defer storageURL.Close()
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, &zlog)
+	require.NoError(t, err)
+	urlService := shorten.NewURLService(storageURL, &zlog, &cfg)
+	usrService := user.NewUserService(usrStorage, &zlog)
+	urlHandler := NewURLHandler(&urlService, &usrService)
+	failingService := shorten.NewURLService(failingRepository{}, &zlog, &cfg)
+	failingHandler := NewURLHandler(&failingService, &usrService)
+	auth := security.New(&usrService, &zlog)
+
+	owner, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+	other, err := auth.BuildJWTString(2)
+	require.NoError(t, err)
+	shortURL, err := urlService.CreateAndSave(context.Background(), "https://go.dev/", 1)
+	require.NoError(t, err)
+	key := shortURL[strings.LastIndex(shortURL, "/")+1:]
+	deletedURL, err := urlService.CreateAndSave(context.Background(), "https://go.dev/blog", 1)
+	require.NoError(t, err)
+	deletedKey := deletedURL[strings.LastIndex(deletedURL, "/")+1:]
+	require.NoError(t, urlService.DeleteByShortURLs(context.Background(), 1, []string{deletedKey}))
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		token   string
+		id      string
+		body    string
+		status  int
+		code    string
+	}{
+		{name: "shorten malformed json", handler: urlHandler.HandleShorten, method: http.MethodPost, body: `{"url":`,
+			status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{name: "shorten invalid url", handler: urlHandler.HandleShorten, method: http.MethodPost, body: `{"url":"http://[::1"}`,
+			status: http.StatusBadRequest, code: problem.CodeInvalidURL},
+		{name: "shorten unauthorized", handler: urlHandler.HandleShorten, method: http.MethodPost, body: `{"url":"https://go.dev/doc"}`,
+			status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "post invalid url", handler: urlHandler.HandlePOST, method: http.MethodPost, token: owner, body: "http://[::1",
+			status: http.StatusBadRequest, code: problem.CodeInvalidURL},
+		{name: "post unauthorized", handler: urlHandler.HandlePOST, method: http.MethodPost, body: "https://go.dev/doc",
+			status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "get not found", handler: urlHandler.HandleGet, method: http.MethodGet, id: "missing",
+			status: http.StatusNotFound, code: problem.CodeNotFound},
+		{name: "get gone", handler: urlHandler.HandleGet, method: http.MethodGet, id: deletedKey,
+			status: http.StatusGone, code: problem.CodeGone},
+		{name: "get storage failure", handler: failingHandler.HandleGet, method: http.MethodGet, id: key,
+			status: http.StatusInternalServerError, code: problem.CodeInternal},
+		{name: "get without id", handler: urlHandler.HandleGet, method: http.MethodGet,
+			status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{name: "user urls unauthorized", handler: urlHandler.HandleUserURLs, method: http.MethodGet,
+			status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "user urls storage failure", handler: failingHandler.HandleUserURLs, method: http.MethodGet, token: owner,
+			status: http.StatusInternalServerError, code: problem.CodeInternal},
+		{name: "batch unauthorized", handler: urlHandler.HandleShortenBatch, method: http.MethodPost, body: `[]`,
+			status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "batch malformed json", handler: urlHandler.HandleShortenBatch, method: http.MethodPost, token: owner, body: `[{]`,
+			status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{name: "batch too large", handler: urlHandler.HandleShortenBatch, method: http.MethodPost, token: owner, body: `[{},{},{}]`,
+			status: http.StatusRequestEntityTooLarge, code: problem.CodeBatchTooLarge},
+		{name: "batch storage failure", handler: failingHandler.HandleShortenBatch, method: http.MethodPost, token: owner,
+			body: `[{"correlation_id":"1","original_url":"https://go.dev/"}]`, status: http.StatusInternalServerError, code: problem.CodeInternal},
+		{name: "delete unauthorized", handler: urlHandler.HandleDeleteURLs, method: http.MethodDelete, body: `["a"]`,
+			status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "delete malformed json", handler: urlHandler.HandleDeleteURLs, method: http.MethodDelete, token: owner, body: `"a"`,
+			status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{name: "delete too large", handler: urlHandler.HandleDeleteURLs, method: http.MethodDelete, token: owner, body: `["a","b","c"]`,
+			status: http.StatusRequestEntityTooLarge, code: problem.CodeBatchTooLarge},
+		{name: "restore unauthorized", handler: urlHandler.HandleRestoreURLs, method: http.MethodPost, body: `["a"]`,
+			status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "restore malformed json", handler: urlHandler.HandleRestoreURLs, method: http.MethodPost, token: owner, body: `{`,
+			status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{name: "restore storage failure", handler: failingHandler.HandleRestoreURLs, method: http.MethodPost, token: owner, body: `["a"]`,
+			status: http.StatusInternalServerError, code: problem.CodeInternal},
+		{name: "update unauthorized", handler: urlHandler.HandleUpdateURL, method: http.MethodPatch, id: key, body: `{}`,
+			status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "update without id", handler: urlHandler.HandleUpdateURL, method: http.MethodPatch, token: owner, body: `{}`,
+			status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{name: "update malformed json", handler: urlHandler.HandleUpdateURL, method: http.MethodPatch, token: owner, id: key, body: `{"tags":`,
+			status: http.StatusBadRequest, code: problem.CodeMalformedRequest},
+		{name: "update not found", handler: urlHandler.HandleUpdateURL, method: http.MethodPatch, token: owner, id: "missing", body: `{}`,
+			status: http.StatusNotFound, code: problem.CodeNotFound},
+		{name: "update not owner", handler: urlHandler.HandleUpdateURL, method: http.MethodPatch, token: other, id: key, body: `{}`,
+			status: http.StatusForbidden, code: problem.CodeForbidden},
+		{name: "update invalid url", handler: urlHandler.HandleUpdateURL, method: http.MethodPatch, token: owner, id: key,
+			body: `{"original_url":"not a url"}`, status: http.StatusBadRequest, code: problem.CodeInvalidURL},
+		{name: "update conflict", handler: urlHandler.HandleUpdateURL, method: http.MethodPatch, token: owner, id: key,
+			body: `{"original_url":"https://go.dev/blog"}`, status: http.StatusConflict, code: problem.CodeConflict},
+		{name: "tags unauthorized", handler: urlHandler.HandleUserTags, method: http.MethodGet,
+			status: http.StatusUnauthorized, code: problem.CodeUnauthorized},
+		{name: "tags storage failure", handler: failingHandler.HandleUserTags, method: http.MethodGet, token: owner,
+			status: http.StatusInternalServerError, code: problem.CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/test", strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.id)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			w := httptest.NewRecorder()
+			if tt.token != "" {
+				auth.AuthMiddleware(tt.handler).ServeHTTP(w, req)
+			} else {
+				tt.handler(w, req)
+			}
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+			var p problem.Problem
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, tt.status, p.Status)
+			assert.Equal(t, "/api/test", p.Instance)
+			if tt.status == http.StatusInternalServerError {
+				assert.NotContains(t, p.Detail, errStorage.Error(), "the cause of an internal error isn't disclosed")
+			}
+		})
+	}
+
+	t.Run("body too large", func(t *testing.T) {
+		compressor := &compression.Compressor{MaxBodySize: 4}
+		shortenHandler := compressor.Middleware(auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleShorten)))
+		serve := func(contentLength int64) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://go.dev/"}`))
+			req.ContentLength = contentLength
+			w := httptest.NewRecorder()
+			shortenHandler.ServeHTTP(w, req)
+			return w
+		}
+		// a declared length is rejected by the middleware, a chunked body by the handler reading it
+		declared, chunked := serve(25), serve(-1)
+		for _, w := range []*httptest.ResponseRecorder{declared, chunked} {
+			assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+			assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+			var p problem.Problem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, problem.CodePayloadTooLarge, p.Code)
+		}
+		assert.Equal(t, declared.Body.String(), chunked.Body.String(), "the same limit gives the same response")
+	})
+}
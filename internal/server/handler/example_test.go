@@ -0,0 +1,77 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/app/domain/shorten"
+	"github.com/lookeme/short-url/internal/app/domain/user"
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/security"
+	"github.com/lookeme/short-url/internal/server/handler"
+	"github.com/lookeme/short-url/internal/storage/inmemory"
+)
+
+// newExampleHandler creates a handler over an in-memory storage kept in dir.
+func newExampleHandler(dir string) (*handler.URLHandler, *security.Authorization) {
+	stCfg := &configuration.Storage{FileStoragePath: filepath.Join(dir, "db.json")}
+	cfg := &configuration.Config{Network: &configuration.NetworkCfg{BaseURL: "http://localhost:8080"}, Storage: stCfg}
+	zlog := &logger.Logger{Log: zap.NewNop()}
+	storageURL, err := inmemory.NewInMemShortenStorage(stCfg, zlog)
+	if err != nil {
+		panic(err)
+	}
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, zlog)
+	if err != nil {
+		panic(err)
+	}
+	urlService := shorten.NewURLService(storageURL, zlog, cfg)
+	usrService := user.NewUserService(usrStorage, zlog)
+	return handler.NewURLHandler(&urlService, &usrService), security.New(&usrService, zlog)
+}
+
+// The URL to shorten is sent as a plain text body. The short URL is random, so only its prefix is printed.
+func ExampleURLHandler_HandlePOST() {
+	dir, _ := os.MkdirTemp("", "example")
+	defer os.RemoveAll(dir)
+	urlHandler, auth := newExampleHandler(dir)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"))
+	res := httptest.NewRecorder()
+	auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandlePOST)).ServeHTTP(res, req)
+	fmt.Println(res.Code, res.Header().Get("Content-Type"))
+	fmt.Println(strings.HasPrefix(res.Body.String(), "http://localhost:8080/"))
+
+	// Output:
+	// 201 text/plain
+	// true
+}
+
+// An unknown short URL is answered with RFC 7807 problem details.
+func ExampleURLHandler_HandleGet() {
+	dir, _ := os.MkdirTemp("", "example")
+	defer os.RemoveAll(dir)
+	urlHandler, _ := newExampleHandler(dir)
+
+	req := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "unknown")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	res := httptest.NewRecorder()
+	urlHandler.HandleGet(res, req)
+	fmt.Println(res.Code, res.Header().Get("Content-Type"))
+	fmt.Println(res.Body.String())
+
+	// Output:
+	// 404 application/problem+json
+	// {"type":"urn:short-url:problem:not_found","title":"Not Found","status":404,"detail":"shorten data not found","instance":"/unknown","code":"not_found"}
+}
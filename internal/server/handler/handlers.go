@@ -2,22 +2,28 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgerrcode"
 	"github.com/lookeme/short-url/internal/app/domain/user"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/metrics"
 	"github.com/lookeme/short-url/internal/security"
-	"github.com/lookeme/short-url/internal/utils"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/tracing"
 
 	"github.com/lookeme/short-url/internal/app/domain/shorten"
 	"github.com/lookeme/short-url/internal/models"
 )
 
+// redirects counts the answered redirect lookups by result: redirect, gone, not_found or error.
+var redirects = metrics.Default.NewCounterVec("shortener_redirects_total", "Number of answered redirect lookups.", "result")
+
 // URLHandler struct encapsulates services needed for URL handling.
 type URLHandler struct {
 	urlService *shorten.URLService
@@ -33,198 +39,299 @@ func NewURLHandler(urlService *shorten.URLService, usrService *user.UsrService)
 }
 
 // HandleShorten handles the HTTP request to shorten a specific URL.
+// If the URL is already shortened, it responds with 409 Conflict and the existing short URL.
 func (h *URLHandler) HandleShorten(res http.ResponseWriter, req *http.Request) {
 	var request models.Request
-	body, _ := io.ReadAll(req.Body)
-	if err := json.Unmarshal(body, &request); err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	if err := decodeBody(req, &request); err != nil {
+		h.writeError(res, req, err)
+		return
+	}
+	if _, err := url.Parse(request.URL); err != nil {
+		h.writeError(res, req, fmt.Errorf("%w: %w", shorten.ErrInvalidURL, err))
+		return
 	}
-	_, err := url.Parse(request.URL)
+	userID, err := userIDFromRequest(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		h.writeError(res, req, err)
+		return
 	}
-	val, err := h.urlService.CreateAndSave(request.URL, 1)
-	res.Header().Set("Content-Type", "application/json")
+	status := http.StatusCreated
+	val, err := h.urlService.CreateAndSave(req.Context(), request.URL, userID, request.Tags...)
 	if err != nil {
-		h.urlService.Log.Log.Error(err.Error())
-		code := utils.ErrorCode(err)
-		if code == pgerrcode.UniqueViolation {
-			res.WriteHeader(http.StatusConflict)
-			data, ok := h.urlService.FindByURL(request.URL)
-			if !ok {
-				http.Error(res, err.Error(), http.StatusBadRequest)
-			} else {
-				val = data.ShortURL
-			}
+		if val, err = existingShortURL(err); err != nil {
+			h.writeError(res, req, err)
+			return
 		}
-	} else {
-		res.WriteHeader(http.StatusCreated)
+		status = http.StatusConflict
 	}
-	b, err := json.Marshal(models.Response{
+	h.writeJSON(res, req, status, models.Response{
 		Result: val,
 	})
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
-	}
-	_, err = res.Write(b)
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
-	}
 }
 
 // HandlePOST handles the HTTP POST request to shorten a URL for a specific user.
+// If the URL is already shortened, it responds with 409 Conflict and the existing short URL.
 func (h *URLHandler) HandlePOST(res http.ResponseWriter, req *http.Request) {
-	b, err := io.ReadAll(req.Body)
-	defer req.Body.Close()
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
-	}
-	_, err = url.Parse(string(b))
+	b, err := readBody(req)
 	if err != nil {
-
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		h.writeError(res, req, err)
+		return
 	}
 	urlToSave := string(b)
-	token := req.Header.Get("Authorization")
-	token, err = utils.GetToken(token)
-	if err != nil {
-		res.WriteHeader(http.StatusUnauthorized)
+	if _, err = url.Parse(urlToSave); err != nil {
+		h.writeError(res, req, fmt.Errorf("%w: %w", shorten.ErrInvalidURL, err))
 		return
 	}
-	userID := security.GetUserID(token)
-	if userID == 0 {
-		res.WriteHeader(http.StatusUnauthorized)
+	userID, err := userIDFromRequest(req)
+	if err != nil {
+		h.writeError(res, req, err)
 		return
 	}
-	val, err := h.urlService.CreateAndSave(urlToSave, userID)
-	res.Header().Set("content-type", "text/plain")
+	status := http.StatusCreated
+	val, err := h.urlService.CreateAndSave(req.Context(), urlToSave, userID)
 	if err != nil {
-		h.urlService.Log.Log.Error(err.Error())
-		code := utils.ErrorCode(err)
-		if code == pgerrcode.UniqueViolation {
-			res.WriteHeader(http.StatusConflict)
-			data, ok := h.urlService.FindByURL(urlToSave)
-			if !ok {
-				http.Error(res, err.Error(), http.StatusBadRequest)
-			} else {
-				val = data.ShortURL
-			}
+		if val, err = existingShortURL(err); err != nil {
+			h.writeError(res, req, err)
+			return
 		}
-	} else {
-		res.WriteHeader(http.StatusCreated)
+		status = http.StatusConflict
 	}
-	if err != nil {
-		h.urlService.Log.Log.Error(err.Error())
+	res.Header().Set("content-type", "text/plain")
+	res.WriteHeader(status)
+	if _, err = res.Write([]byte(val)); err != nil {
+		h.urlService.Log.Ctx(req.Context()).Error(err.Error())
 	}
-	_, err = res.Write([]byte(val))
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+}
+
+// existingShortURL returns the short URL of the original URL if saving it failed with err because it is already shortened,
+// otherwise it returns err.
+func existingShortURL(err error) (string, error) {
+	var conflict *storage.ConflictError
+	if !errors.As(err, &conflict) || conflict.Existing.ShortURL == "" {
+		return "", err
 	}
+	return conflict.Existing.ShortURL, nil
 }
 
 // HandlePing provides a simple ping endpoint for checking server status.
-func (h *URLHandler) HandlePing(res http.ResponseWriter, _ *http.Request) {
-	ctx := context.Background()
-	err := h.urlService.Ping(ctx)
-	if err != nil {
+// It responds with 500 Internal Server Error if the storage can't serve requests, see /readyz for the details.
+func (h *URLHandler) HandlePing(res http.ResponseWriter, req *http.Request) {
+	if err := h.urlService.Ping(req.Context()); err != nil {
+		h.urlService.Log.Ctx(req.Context()).Error("storage is unavailable: " + err.Error())
 		res.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 	res.WriteHeader(http.StatusOK)
 }
 
 // HandleGet retrieves a URL by its ID. If the URL is not found or is deleted,
-// it throws an appropriate HTTP error.
+// it responds with 404 Not Found or 410 Gone.
 func (h *URLHandler) HandleGet(res http.ResponseWriter, req *http.Request) {
 	id := chi.URLParam(req, "id")
 	if id == "" {
-		http.Error(res, "ID is not provided in path", http.StatusBadRequest)
+		h.writeError(res, req, malformed(errors.New("id is not provided in path")))
 		return
 	}
-	val, ok := h.urlService.FindByKey(id)
-	if !ok {
-		http.Error(res, "Value is not found", http.StatusBadRequest)
+	tracing.SpanFromContext(req.Context()).SetAttributes(tracing.String("short_key", id))
+	logger.SetAccessShortKey(req.Context(), id)
+	val, err := h.urlService.FindByKey(req.Context(), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			redirects.Inc("not_found")
+		} else {
+			redirects.Inc("error")
+		}
+		h.writeError(res, req, err)
 		return
 	}
 	if val.DeletedFlag {
-		res.WriteHeader(http.StatusGone)
-	} else {
-		res.Header().Set("Location", val.OriginalURL)
-		res.WriteHeader(http.StatusTemporaryRedirect)
+		redirects.Inc("gone")
+		h.writeError(res, req, errGone)
+		return
 	}
+	redirects.Inc("redirect")
+	res.Header().Set("Location", val.OriginalURL)
+	res.WriteHeader(http.StatusTemporaryRedirect)
 }
 
 // HandleUserURLs retrieves all URLs for a specific user.
-func (h *URLHandler) HandleUserURLs(res http.ResponseWriter, r *http.Request) {
-	res.Header().Set("Content-Type", "application/json")
-	token := r.Header.Get("Authorization")
-	token, err := utils.GetToken(token)
+func (h *URLHandler) HandleUserURLs(res http.ResponseWriter, req *http.Request) {
+	userID, err := userIDFromRequest(req)
 	if err != nil {
-		res.WriteHeader(http.StatusUnauthorized)
+		h.writeError(res, req, err)
 		return
 	}
-	userID := security.GetUserID(token)
-	if userID == 0 {
-		http.Error(res, "userID is not presented in token", http.StatusUnauthorized)
+	var urls []models.ShortenData
+	if tag := req.URL.Query().Get("tag"); tag != "" {
+		urls, err = h.urlService.FindAllByUserIDAndTag(req.Context(), userID, tag)
+	} else {
+		urls, err = h.urlService.FindAllByUserID(req.Context(), userID)
 	}
-	urls, err := h.urlService.FindAllByUserID(userID)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		h.writeError(res, req, err)
 		return
 	}
 	if urls == nil {
 		res.WriteHeader(http.StatusNoContent)
 		return
 	}
-	b, err := json.Marshal(urls)
+	h.writeJSON(res, req, http.StatusOK, urls)
+}
+
+// HandleShortenBatch handles a batch of URLs to shorten for a specific user.
+func (h *URLHandler) HandleShortenBatch(res http.ResponseWriter, req *http.Request) {
+	userID, err := userIDFromRequest(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		h.writeError(res, req, err)
 		return
 	}
-	res.WriteHeader(http.StatusOK)
-	_, err = res.Write(b)
+	var request []models.BatchRequest
+	if err := decodeBody(req, &request); err != nil {
+		h.writeError(res, req, err)
+		return
+	}
+	val, err := h.urlService.CreateAndSaveBatch(req.Context(), request, userID)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		h.writeError(res, req, err)
 		return
 	}
+	h.writeJSON(res, req, http.StatusCreated, val)
 }
 
-// HandleShortenBatch handles a batch of URLs to shorten.
-func (h *URLHandler) HandleShortenBatch(res http.ResponseWriter, req *http.Request) {
-	var request []models.BatchRequest
-	body, err := io.ReadAll(req.Body)
-	defer req.Body.Close()
+// HandleDeleteURLs removes a batch of URLs of the user, URLs of other users are left intact.
+func (h *URLHandler) HandleDeleteURLs(res http.ResponseWriter, req *http.Request) {
+	userID, err := userIDFromRequest(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		h.writeError(res, req, err)
+		return
 	}
-	if err := json.Unmarshal(body, &request); err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	var request []string
+	if err := decodeBody(req, &request); err != nil {
+		h.writeError(res, req, err)
+		return
+	}
+	if len(request) != 0 {
+		if err := h.urlService.DeleteByShortURLs(req.Context(), userID, request); err != nil {
+			h.writeError(res, req, err)
+			return
+		}
 	}
-	val, err := h.urlService.CreateAndSaveBatch(request)
+	res.WriteHeader(http.StatusAccepted)
+}
+
+// HandleRestoreURLs restores a batch of URLs of the user deleted within the grace period.
+// It responds with the short URLs which were restored.
+func (h *URLHandler) HandleRestoreURLs(res http.ResponseWriter, req *http.Request) {
+	userID, err := userIDFromRequest(req)
 	if err != nil {
-		h.urlService.Log.Log.Error(err.Error())
+		h.writeError(res, req, err)
+		return
 	}
-	res.Header().Set("Content-Type", "application/json")
-	res.WriteHeader(http.StatusCreated)
-	b, err := json.Marshal(val)
+	var request []string
+	if err := decodeBody(req, &request); err != nil {
+		h.writeError(res, req, err)
+		return
+	}
+	restored, err := h.urlService.RestoreByShortURLs(req.Context(), userID, request)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		h.writeError(res, req, err)
+		return
+	}
+	h.writeJSON(res, req, http.StatusOK, restored)
+}
+
+// HandleUpdateURL applies a partial update, such as a new destination or a new set of tags, to a URL owned by the user.
+func (h *URLHandler) HandleUpdateURL(res http.ResponseWriter, req *http.Request) {
+	userID, err := userIDFromRequest(req)
+	if err != nil {
+		h.writeError(res, req, err)
+		return
+	}
+	id := chi.URLParam(req, "id")
+	if id == "" {
+		h.writeError(res, req, malformed(errors.New("id is not provided in path")))
+		return
 	}
-	_, err = res.Write(b)
+	var request models.UpdateRequest
+	if err := decodeBody(req, &request); err != nil {
+		h.writeError(res, req, err)
+		return
+	}
+	data, err := h.urlService.UpdateURL(req.Context(), userID, id, request)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		h.writeError(res, req, err)
+		return
 	}
+	h.writeJSON(res, req, http.StatusOK, data)
 }
 
-// HandleDeleteURLs removes a batch of URLs.
-func (h *URLHandler) HandleDeleteURLs(res http.ResponseWriter, req *http.Request) {
+// HandleUserTags lists the tags used by the user along with the number of URLs marked with each of them.
+func (h *URLHandler) HandleUserTags(res http.ResponseWriter, req *http.Request) {
+	userID, err := userIDFromRequest(req)
+	if err != nil {
+		h.writeError(res, req, err)
+		return
+	}
+	tags, err := h.urlService.TagsByUserID(req.Context(), userID)
+	if err != nil {
+		h.writeError(res, req, err)
+		return
+	}
+	if len(tags) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.writeJSON(res, req, http.StatusOK, tags)
+}
+
+// writeJSON responds with v encoded as JSON, or with 500 Internal Server Error if it can't be encoded.
+func (h *URLHandler) writeJSON(res http.ResponseWriter, req *http.Request, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		h.writeError(res, req, err)
+		return
+	}
 	res.Header().Set("Content-Type", "application/json")
-	var request []string
-	body, _ := io.ReadAll(req.Body)
-	if err := json.Unmarshal(body, &request); err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	res.WriteHeader(status)
+	if _, err = res.Write(b); err != nil {
+		h.urlService.Log.Ctx(req.Context()).Error(err.Error())
 	}
-	if len(request) != 0 {
-		h.urlService.DeleteByShortURLs(request)
+}
+
+// readBody reads the whole request body. The error is a *http.MaxBytesError if the body exceeds the size limits
+// set by the compression middleware, otherwise it marks the request as malformed.
+func readBody(req *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, bodyError(err)
 	}
-	res.WriteHeader(http.StatusAccepted)
+	return body, nil
+}
+
+// decodeBody decodes the JSON request body into v, failing like readBody.
+func decodeBody(req *http.Request, v any) error {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		return bodyError(err)
+	}
+	return nil
+}
+
+// bodyError marks an error reading the request body as malformed unless the body is too large.
+func bodyError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return malformed(err)
+}
+
+// userIDFromRequest returns the ID of the user the auth middleware verified for the request.
+// The Authorization header isn't consulted, as it carries whatever the client sent.
+// It returns user.ErrUnauthorized if the request didn't pass through the auth middleware.
+func userIDFromRequest(req *http.Request) (int, error) {
+	userID, ok := security.UserIDFromContext(req.Context())
+	if !ok {
+		return 0, fmt.Errorf("%w: the request carries no verified user", user.ErrUnauthorized)
+	}
+	return userID, nil
 }
@@ -2,40 +2,51 @@ package handler
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lookeme/short-url/internal/app/domain/user"
 	"github.com/lookeme/short-url/internal/security"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/lookeme/short-url/internal/app/domain/shorten"
+	"github.com/lookeme/short-url/internal/compression"
 	"github.com/lookeme/short-url/internal/configuration"
 	"github.com/lookeme/short-url/internal/logger"
 	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
 	"github.com/lookeme/short-url/internal/storage/inmemory"
 )
 
 func TestURLHandlerIndex(t *testing.T) {
+	ctx := context.Background()
 	netCfg := configuration.NetworkCfg{
 		ServerAddress: ":8080",
 		BaseURL:       "http://localhost:8080/",
 	}
+	stCfg := configuration.Storage{
+		FileStoragePath:    "/tmp/short-url-db.json",
+		DeletedGracePeriod: time.Hour,
+	}
 	cfg := configuration.Config{
 		Network: &netCfg,
-	}
-
-	stCfg := configuration.Storage{
-		FileStoragePath: "/tmp/short-url-db.json",
+		Storage: &stCfg,
 	}
 
 	log, _ := zap.NewDevelopment()
@@ -44,12 +55,14 @@ func TestURLHandlerIndex(t *testing.T) {
 	}
 	storageURL, err := inmemory.NewInMemShortenStorage(&stCfg, &zlog)
 	require.NoError(t, err)
-	usrStorage, err := inmemory.NewInMemUserStorage(&zlog)
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, &zlog)
 	require.NoError(t, err)
 	urlService := shorten.NewURLService(storageURL, &zlog, &cfg)
 	usrService := user.NewUserService(usrStorage, &zlog)
 	urlHandler := NewURLHandler(&urlService, &usrService)
 	auth := security.New(&usrService, &zlog)
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
 	requestBody := "https://practicum.yandex.ru/"
 	req := models.Request{
 		URL: requestBody,
@@ -89,9 +102,12 @@ func TestURLHandlerIndex(t *testing.T) {
 	})
 
 	t.Run("handler test #2", func(t *testing.T) {
+		body, err := json.Marshal(models.Request{URL: "https://practicum.yandex.ru/learn/"})
+		require.NoError(t, err)
 		req := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
 		w := httptest.NewRecorder()
-		urlHandler.HandleShorten(w, req)
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleShorten)).ServeHTTP(w, req)
 		res := w.Result()
 		assert.Equal(t, http.StatusCreated, res.StatusCode)
 		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
@@ -112,8 +128,472 @@ func TestURLHandlerIndex(t *testing.T) {
 		urlHandler.HandleGet(w, req)
 		res = w.Result()
 		assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
-		assert.Equal(t, requestBody, res.Header.Get("Location"))
+		assert.Equal(t, "https://practicum.yandex.ru/learn/", res.Header.Get("Location"))
 		err = res.Body.Close()
 		require.NoError(t, err)
 	})
+
+	t.Run("shorten conflict", func(t *testing.T) {
+		first, ok := urlService.FindByURL(ctx, requestBody)
+		require.True(t, ok)
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleShorten)).ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+		response := models.Response{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+		assert.Equal(t, first.ShortURL, response.Result)
+
+		req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
+		w = httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandlePOST)).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, first.ShortURL, w.Body.String())
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		tagged, err := json.Marshal(models.Request{URL: "https://go.dev/", Tags: []string{" Promo ", "spring"}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(tagged))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleShorten)).ServeHTTP(w, req)
+		res := w.Result()
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		response := models.Response{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+		require.NoError(t, res.Body.Close())
+		url := strings.Split(response.Result, "/")
+		key := url[len(url)-1]
+
+		req = httptest.NewRequest(http.MethodGet, "/api/user/urls?tag=promo", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w = httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleUserURLs)).ServeHTTP(w, req)
+		res = w.Result()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var urls []models.ShortenData
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&urls))
+		require.NoError(t, res.Body.Close())
+		require.Len(t, urls, 1)
+		assert.Equal(t, "https://go.dev/", urls[0].OriginalURL)
+		assert.Equal(t, []string{"promo", "spring"}, urls[0].Tags)
+
+		req = httptest.NewRequest(http.MethodPatch, "/api/user/urls/{id}", strings.NewReader(`{"tags":["autumn"]}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", key)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w = httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleUpdateURL)).ServeHTTP(w, req)
+		res = w.Result()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+
+		req = httptest.NewRequest(http.MethodGet, "/api/user/tags", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w = httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleUserTags)).ServeHTTP(w, req)
+		res = w.Result()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var tags []models.TagCount
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&tags))
+		require.NoError(t, res.Body.Close())
+		assert.Equal(t, []models.TagCount{{Tag: "autumn", Count: 1}}, tags)
+
+		otherToken, err := auth.BuildJWTString(2)
+		require.NoError(t, err)
+		req = httptest.NewRequest(http.MethodPatch, "/api/user/urls/{id}", strings.NewReader(`{"tags":[]}`))
+		req.Header.Set("Authorization", "Bearer "+otherToken)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w = httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleUpdateURL)).ServeHTTP(w, req)
+		res = w.Result()
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+	})
+
+	t.Run("update destination", func(t *testing.T) {
+		token, err := auth.BuildJWTString(1)
+		require.NoError(t, err)
+		shortURL, err := urlService.CreateAndSave(ctx, "https://go.dev/blog", 1)
+		require.NoError(t, err)
+		url := strings.Split(shortURL, "/")
+		key := url[len(url)-1]
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", key)
+
+		req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/{id}", strings.NewReader(`{"original_url":"not a url"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleUpdateURL)).ServeHTTP(w, req)
+		res := w.Result()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+
+		req = httptest.NewRequest(http.MethodPatch, "/api/user/urls/{id}", strings.NewReader(`{"original_url":"https://go.dev/doc"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w = httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleUpdateURL)).ServeHTTP(w, req)
+		res = w.Result()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, res.Body.Close())
+
+		req = httptest.NewRequest(http.MethodGet, "/{id}", nil)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w = httptest.NewRecorder()
+		urlHandler.HandleGet(w, req)
+		res = w.Result()
+		assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+		assert.Equal(t, "https://go.dev/doc", res.Header.Get("Location"))
+		require.NoError(t, res.Body.Close())
+	})
+
+	t.Run("restore and purge", func(t *testing.T) {
+		token, err := auth.BuildJWTString(1)
+		require.NoError(t, err)
+		shortURL, err := urlService.CreateAndSave(ctx, "https://go.dev/play", 1)
+		require.NoError(t, err)
+		url := strings.Split(shortURL, "/")
+		key := url[len(url)-1]
+		require.NoError(t, urlService.DeleteByShortURLs(ctx, 1, []string{key}))
+
+		req := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(`["`+key+`"]`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleRestoreURLs)).ServeHTTP(w, req)
+		res := w.Result()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var restored []string
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&restored))
+		require.NoError(t, res.Body.Close())
+		assert.Equal(t, []string{shortURL}, restored)
+		data, err := urlService.FindByKey(ctx, key)
+		require.NoError(t, err)
+		assert.False(t, data.DeletedFlag)
+
+		require.NoError(t, urlService.DeleteByShortURLs(ctx, 1, []string{key}))
+		stCfg.DeletedGracePeriod = 0
+		defer func() { stCfg.DeletedGracePeriod = time.Hour }()
+		count, err := urlService.PurgeDeleted(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		_, err = urlService.FindByKey(ctx, key)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("delete only own urls", func(t *testing.T) {
+		shortURL, err := urlService.CreateAndSave(ctx, "https://go.dev/dl", 1)
+		require.NoError(t, err)
+		url := strings.Split(shortURL, "/")
+		key := url[len(url)-1]
+		otherToken, err := auth.BuildJWTString(2)
+		require.NoError(t, err)
+
+		for _, bearer := range []string{otherToken, token} {
+			req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["`+key+`"]`))
+			req.Header.Set("Authorization", "Bearer "+bearer)
+			w := httptest.NewRecorder()
+			auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleDeleteURLs)).ServeHTTP(w, req)
+			require.Equal(t, http.StatusAccepted, w.Code)
+			data, err := urlService.FindByKey(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, bearer == token, data.DeletedFlag, "only the owner deletes the URL")
+		}
+	})
+}
+
+func TestHandlePing(t *testing.T) {
+	stCfg := configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	zlog := logger.Logger{Log: zap.NewNop()}
+	storageURL, err := inmemory.NewInMemShortenStorage(&stCfg, &zlog)
+	require.NoError(t, err)
+	defer storageURL.Close()
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, &zlog)
+	require.NoError(t, err)
+	urlService := shorten.NewURLService(storageURL, &zlog, &configuration.Config{Storage: &stCfg})
+	usrService := user.NewUserService(usrStorage, &zlog)
+	urlHandler := NewURLHandler(&urlService, &usrService)
+
+	w := httptest.NewRecorder()
+	urlHandler.HandlePing(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, os.Remove(stCfg.FileStoragePath))
+	w = httptest.NewRecorder()
+	urlHandler.HandlePing(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "the storage file can't be written anymore")
+}
+
+func TestRequestLimits(t *testing.T) {
+	netCfg := configuration.NetworkCfg{
+		BaseURL:                 "http://localhost:8080/",
+		MaxBodySize:             1 << 10,
+		MaxDecompressedBodySize: 4 << 10,
+		MaxBatchSize:            2,
+	}
+	stCfg := configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	zlog := logger.Logger{Log: zap.NewNop()}
+	storageURL, err := inmemory.NewInMemShortenStorage(&stCfg, &zlog)
+	require.NoError(t, err)
+	defer storageURL.Close()
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, &zlog)
+	require.NoError(t, err)
+	urlService := shorten.NewURLService(storageURL, &zlog, &configuration.Config{Network: &netCfg, Storage: &stCfg})
+	usrService := user.NewUserService(usrStorage, &zlog)
+	urlHandler := NewURLHandler(&urlService, &usrService)
+	compressor := compression.New(&netCfg)
+	auth := security.New(&usrService, &zlog)
+
+	serve := func(handler http.HandlerFunc, body []byte, gzipped bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if gzipped {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = zw.Write(body)
+			require.NoError(t, zw.Close())
+			req = httptest.NewRequest(http.MethodPost, "/", &buf)
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		compressor.Middleware(auth.AuthMiddleware(handler)).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	batch := func(n int) []byte {
+		request := make([]models.BatchRequest, n)
+		for i := range request {
+			request[i] = models.BatchRequest{CorrelationID: strconv.Itoa(i), OriginalURL: "https://example.com/" + strconv.Itoa(i)}
+		}
+		b, err := json.Marshal(request)
+		require.NoError(t, err)
+		return b
+	}
+	assert.Equal(t, http.StatusCreated, serve(urlHandler.HandleShortenBatch, batch(2), false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(urlHandler.HandleShortenBatch, batch(3), false), "too many items")
+	assert.Equal(t, http.StatusAccepted, serve(urlHandler.HandleDeleteURLs, []byte(`["a","b"]`), false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(urlHandler.HandleDeleteURLs, []byte(`["a","b","c"]`), false), "too many items")
+
+	long, err := json.Marshal(models.Request{URL: "https://example.com/" + strings.Repeat("a", 2<<10)})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(urlHandler.HandleShorten, long, false), "the body is too large as sent")
+	assert.Equal(t, http.StatusCreated, serve(urlHandler.HandleShorten, long, true), "the compressed body is small enough")
+	huge, err := json.Marshal(models.Request{URL: "https://example.com/" + strings.Repeat("a", 8<<10)})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(urlHandler.HandleShorten, huge, true), "the body expands beyond the limit")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(urlHandler.HandleDeleteURLs, huge, true))
+}
+
+// forgedTokens returns tokens claiming to be of the user which weren't signed with security.SecretKey.
+func forgedTokens(t *testing.T, userID int) map[string]string {
+	t.Helper()
+	claims := security.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		UserID:           userID,
+	}
+	otherKey, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("another-key"))
+	require.NoError(t, err)
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	return map[string]string{
+		"other key":      otherKey,
+		"junk signature": otherKey[:strings.LastIndex(otherKey, ".")+1] + "junk",
+		"unsigned":       unsigned,
+	}
+}
+
+// TestForgedToken checks that a token which isn't signed by the service doesn't act as the user it claims to be.
+func TestForgedToken(t *testing.T) {
+	stCfg := configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	zlog := logger.Logger{Log: zap.NewNop()}
+	storageURL, err := inmemory.NewInMemShortenStorage(&stCfg, &zlog)
+	require.NoError(t, err)
+	defer storageURL.Close()
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, &zlog)
+	require.NoError(t, err)
+	netCfg := configuration.NetworkCfg{BaseURL: "http://localhost:8080"}
+	urlService := shorten.NewURLService(storageURL, &zlog, &configuration.Config{Network: &netCfg, Storage: &stCfg})
+	usrService := user.NewUserService(usrStorage, &zlog)
+	urlHandler := NewURLHandler(&urlService, &usrService)
+	auth := security.New(&usrService, &zlog)
+	victim, err := usrService.CreateUser(context.Background())
+	require.NoError(t, err)
+	_, err = urlService.CreateAndSave(context.Background(), "https://go.dev/", victim.UserID)
+	require.NoError(t, err)
+
+	handlers := map[string]struct {
+		handler http.HandlerFunc
+		body    string
+	}{
+		"shorten":   {handler: urlHandler.HandleShorten, body: `{"url":"https://go.dev/doc"}`},
+		"post":      {handler: urlHandler.HandlePOST, body: "https://go.dev/doc"},
+		"batch":     {handler: urlHandler.HandleShortenBatch, body: `[]`},
+		"user urls": {handler: urlHandler.HandleUserURLs},
+		"delete":    {handler: urlHandler.HandleDeleteURLs, body: `["a"]`},
+		"restore":   {handler: urlHandler.HandleRestoreURLs, body: `["a"]`},
+		"update":    {handler: urlHandler.HandleUpdateURL, body: `{}`},
+		"tags":      {handler: urlHandler.HandleUserTags},
+	}
+	for name, forged := range forgedTokens(t, victim.UserID) {
+		t.Run(name, func(t *testing.T) {
+			_, err := security.GetUserID(forged)
+			assert.Error(t, err)
+
+			for route, h := range handlers {
+				req := httptest.NewRequest(http.MethodPost, "/api/test", strings.NewReader(h.body))
+				req.Header.Set("Authorization", "Bearer "+forged)
+				rctx := chi.NewRouteContext()
+				rctx.URLParams.Add("id", "a")
+				req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+				w := httptest.NewRecorder()
+				h.handler(w, req)
+				assert.Equal(t, http.StatusUnauthorized, w.Code, "%s trusts the header instead of the verified user", route)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			req.Header.Set("Authorization", "Bearer "+forged)
+			w := httptest.NewRecorder()
+			auth.AuthMiddleware(http.HandlerFunc(urlHandler.HandleUserURLs)).ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNoContent, w.Code, "a new user is created instead, who has no URLs")
+			issued := strings.TrimPrefix(w.Header().Get("Authorization"), "Bearer ")
+			assert.NotEqual(t, forged, issued)
+			userID, err := security.GetUserID(issued)
+			require.NoError(t, err)
+			assert.NotEqual(t, victim.UserID, userID)
+		})
+	}
+}
+
+// TestUpdateURLForgedToken checks that a token forged for the owner of a URL can't change it.
+func TestUpdateURLForgedToken(t *testing.T) {
+	netCfg := configuration.NetworkCfg{BaseURL: "http://localhost:8080"}
+	stCfg := configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	zlog := logger.Logger{Log: zap.NewNop()}
+	storageURL, err := inmemory.NewInMemShortenStorage(&stCfg, &zlog)
+	require.NoError(t, err)
+	defer storageURL.Close()
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, &zlog)
+	require.NoError(t, err)
+	urlService := shorten.NewURLService(storageURL, &zlog, &configuration.Config{Network: &netCfg, Storage: &stCfg})
+	usrService := user.NewUserService(usrStorage, &zlog)
+	urlHandler := NewURLHandler(&urlService, &usrService)
+	auth := security.New(&usrService, &zlog)
+	router := chi.NewRouter()
+	router.Use(auth.AuthMiddleware)
+	router.Patch("/api/user/urls/{id}", urlHandler.HandleUpdateURL)
+
+	owner, err := usrService.CreateUser(context.Background())
+	require.NoError(t, err)
+	shortURL, err := urlService.CreateAndSave(context.Background(), "https://go.dev/", owner.UserID, "go")
+	require.NoError(t, err)
+	key := shortURL[strings.LastIndex(shortURL, "/")+1:]
+
+	for name, forged := range forgedTokens(t, owner.UserID) {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/"+key,
+				strings.NewReader(`{"original_url":"https://evil.example/","tags":[]}`))
+			req.Header.Set("Authorization", "Bearer "+forged)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusForbidden, w.Code, "the forged token acts as a new user, who doesn't own the URL")
+
+			data, err := urlService.FindByKey(context.Background(), key)
+			require.NoError(t, err)
+			assert.Equal(t, "https://go.dev/", data.OriginalURL)
+			assert.Equal(t, []string{"go"}, data.Tags)
+		})
+	}
+}
+
+// TestTags goes through the tag routes as one user: URLs are tagged on creation by both shortening routes,
+// then listed, filtered, counted and retagged, while another user sees none of them.
+func TestTags(t *testing.T) {
+	netCfg := configuration.NetworkCfg{BaseURL: "http://localhost:8080"}
+	stCfg := configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	zlog := logger.Logger{Log: zap.NewNop()}
+	storageURL, err := inmemory.NewInMemShortenStorage(&stCfg, &zlog)
+	require.NoError(t, err)
+	defer storageURL.Close()
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, &zlog)
+	require.NoError(t, err)
+	urlService := shorten.NewURLService(storageURL, &zlog, &configuration.Config{Network: &netCfg, Storage: &stCfg})
+	usrService := user.NewUserService(usrStorage, &zlog)
+	urlHandler := NewURLHandler(&urlService, &usrService)
+	auth := security.New(&usrService, &zlog)
+
+	router := chi.NewRouter()
+	router.Use(auth.AuthMiddleware)
+	router.Post("/api/shorten", urlHandler.HandleShorten)
+	router.Post("/api/shorten/batch", urlHandler.HandleShortenBatch)
+	router.Get("/api/user/urls", urlHandler.HandleUserURLs)
+	router.Patch("/api/user/urls/{id}", urlHandler.HandleUpdateURL)
+	router.Get("/api/user/tags", urlHandler.HandleUserTags)
+
+	var token string
+	do := func(method, path, body string, v any) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if token == "" {
+			token = strings.TrimPrefix(w.Header().Get("Authorization"), "Bearer ")
+		}
+		if v != nil && w.Code < http.StatusMultipleChoices && w.Code != http.StatusNoContent {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(v), "%s %s", method, path)
+		}
+		return w.Code
+	}
+	originalURLs := func(urls []models.ShortenData) []string {
+		result := make([]string, 0, len(urls))
+		for _, u := range urls {
+			result = append(result, u.OriginalURL)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	var created models.Response
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten", `{"url":"https://go.dev/","tags":[" Go ","docs"]}`, &created))
+	require.NotEmpty(t, token, "the first request gets a token for the new user")
+	var batch []models.BatchResponse
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://go.dev/blog","tags":["go","blog"]},`+
+			`{"correlation_id":"2","original_url":"https://pkg.go.dev/"}]`, &batch))
+	require.Len(t, batch, 2)
+
+	var urls []models.ShortenData
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/user/urls", "", &urls))
+	assert.Equal(t, []string{"https://go.dev/", "https://go.dev/blog", "https://pkg.go.dev/"}, originalURLs(urls))
+	urls = nil
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/user/urls?tag=go", "", &urls))
+	assert.Equal(t, []string{"https://go.dev/", "https://go.dev/blog"}, originalURLs(urls))
+	urls = nil
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/user/urls?tag=blog", "", &urls))
+	assert.Equal(t, []string{"https://go.dev/blog"}, originalURLs(urls))
+	var tags []models.TagCount
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/user/tags", "", &tags))
+	assert.Equal(t, []models.TagCount{{Tag: "go", Count: 2}, {Tag: "blog", Count: 1}, {Tag: "docs", Count: 1}}, tags)
+
+	key := created.Result[strings.LastIndex(created.Result, "/")+1:]
+	var updated models.ShortenData
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/api/user/urls/"+key, `{"tags":["blog"]}`, &updated))
+	assert.Equal(t, []string{"blog"}, updated.Tags)
+	urls = nil
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/user/urls?tag=go", "", &urls))
+	assert.Equal(t, []string{"https://go.dev/blog"}, originalURLs(urls))
+	tags = nil
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/user/tags", "", &tags))
+	assert.Equal(t, []models.TagCount{{Tag: "blog", Count: 2}, {Tag: "go", Count: 1}}, tags)
+
+	token = ""
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/urls?tag=blog", "", nil), "another user has no tagged URLs")
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/tags", "", nil))
 }
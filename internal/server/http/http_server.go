@@ -1,7 +1,9 @@
 package http
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/lookeme/short-url/internal/security"
 
@@ -10,17 +12,21 @@ import (
 
 	"github.com/lookeme/short-url/internal/compression"
 	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/health"
 	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/metrics"
 	"github.com/lookeme/short-url/internal/server/handler"
+	"github.com/lookeme/short-url/internal/tracing"
 )
 
 // Server represents a server that handles HTTP requests.
 type Server struct {
-	handler *handler.URLHandler
-	config  *configuration.NetworkCfg
-	logger  *logger.Logger
-	gzip    *compression.Compressor
-	auth    *security.Authorization
+	handler    *handler.URLHandler
+	config     *configuration.NetworkCfg
+	logger     *logger.Logger
+	compressor *compression.Compressor
+	auth       *security.Authorization
+	health     *health.Checker
 }
 
 // NewServer creates a new instance of the Server struct.
@@ -30,33 +36,137 @@ func NewServer(
 	logger *logger.Logger,
 	compressor *compression.Compressor,
 	auth *security.Authorization,
+	checker *health.Checker,
 ) *Server {
 	return &Server{
-		handler: handler,
-		config:  cfg,
-		logger:  logger,
-		gzip:    compressor,
-		auth:    auth,
+		handler:    handler,
+		config:     cfg,
+		logger:     logger,
+		compressor: compressor,
+		auth:       auth,
+		health:     checker,
 	}
 }
 
-// Serve runs the HTTP server and listens for incoming requests.
-func (s *Server) Serve() error {
+// Serve runs the HTTP server and listens for incoming requests until ctx is done.
+// Then it shuts down gracefully: the readiness endpoint fails for the configured shutdown delay while requests
+// are still served, after which the server stops accepting connections and waits for the in-flight requests
+// up to the shutdown timeout.
+func (s *Server) Serve(ctx context.Context) error {
+	s.logger.Log.Info("shorten url service ", zap.String("starting serving on ....", s.config.ServerAddress))
+	server := &http.Server{Addr: s.config.ServerAddress, Handler: s.Routes()}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	s.health.SetShuttingDown()
+	s.logger.Log.Info("shutting down", zap.Duration("delay", s.config.ShutdownDelay))
+	time.Sleep(s.config.ShutdownDelay)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// route is an entry of the route tables of the public API.
+type route struct {
+	method  string
+	pattern string
+	handler http.HandlerFunc
+}
+
+// publicRoutes are the routes served to anyone: the redirects, the probes and the API documentation.
+func (s *Server) publicRoutes() []route {
+	return []route{
+		{method: http.MethodGet, pattern: "/{id}", handler: s.handler.HandleGet},
+		{method: http.MethodGet, pattern: "/ping", handler: s.handler.HandlePing},
+		{method: http.MethodGet, pattern: "/healthz", handler: s.health.LivenessHandler},
+		{method: http.MethodGet, pattern: "/readyz", handler: s.health.ReadinessHandler},
+		{method: http.MethodGet, pattern: "/api/openapi.json", handler: handleOpenAPI},
+		{method: http.MethodGet, pattern: "/api/docs", handler: handleDocs},
+	}
+}
+
+// authRoutes are the routes scoped to a user. They are served behind the AuthMiddleware,
+// which creates a new user for a request without a valid token.
+func (s *Server) authRoutes() []route {
+	return []route{
+		{method: http.MethodPost, pattern: "/", handler: s.handler.HandlePOST},
+		{method: http.MethodPost, pattern: "/api/shorten", handler: s.handler.HandleShorten},
+		{method: http.MethodPost, pattern: "/api/shorten/batch", handler: s.handler.HandleShortenBatch},
+		{method: http.MethodGet, pattern: "/api/user/urls", handler: s.handler.HandleUserURLs},
+		{method: http.MethodDelete, pattern: "/api/user/urls", handler: s.handler.HandleDeleteURLs},
+		{method: http.MethodPost, pattern: "/api/user/urls/restore", handler: s.handler.HandleRestoreURLs},
+		{method: http.MethodPatch, pattern: "/api/user/urls/{id}", handler: s.handler.HandleUpdateURL},
+		{method: http.MethodGet, pattern: "/api/user/tags", handler: s.handler.HandleUserTags},
+	}
+}
+
+// Routes builds the handler of the public API out of the route tables, the routes are described by openapi.json.
+func (s *Server) Routes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Use(metrics.Middleware)
+	r.Use(s.logger.RequestIDMiddleware)
 	r.Use(s.logger.Middleware)
-	r.Use(s.gzip.GzipMiddleware)
-	r.Group(func(subRouter chi.Router) {
-		subRouter.Use(s.auth.AuthMiddleware)
-		subRouter.Post("/", s.handler.HandlePOST)
-		subRouter.Get("/api/user/urls", s.handler.HandleUserURLs)
+	r.Use(s.compressor.Middleware)
+	// the route is only known once a group route is matched, so the route logger is set up by a group
+	r.Group(func(r chi.Router) {
+		r.Use(s.logger.RouteMiddleware)
+		for _, rt := range s.publicRoutes() {
+			r.Method(rt.method, rt.pattern, rt.handler)
+		}
+		r.Group(func(r chi.Router) {
+			r.Use(s.auth.AuthMiddleware)
+			for _, rt := range s.authRoutes() {
+				r.Method(rt.method, rt.pattern, rt.handler)
+			}
+		})
 	})
-	r.Delete("/api/user/urls", s.handler.HandleDeleteURLs)
-	r.Get("/api/user/urls", s.handler.HandleUserURLs)
-	r.Post("/api/shorten", s.handler.HandleShorten)
-	r.Post("/api/shorten/batch", s.handler.HandleShortenBatch)
-	r.Get("/{id}", s.handler.HandleGet)
-	r.Get("/ping", s.handler.HandlePing)
-	r.Get("/api/user/urls", s.handler.HandleUserURLs)
-	s.logger.Log.Info("shorten url service ", zap.String("starting serving on ....", s.config.ServerAddress))
-	return http.ListenAndServe(s.config.ServerAddress, r)
+	return r
+}
+
+// ServeMetrics serves the metrics of the default registry on the metrics address until ctx is done,
+// so they are not exposed on the public API port.
+func (s *Server) ServeMetrics(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	s.logger.Log.Info("metrics", zap.String("starting serving on ....", s.config.MetricsAddress))
+	return s.serveInternal(ctx, s.config.MetricsAddress, mux)
+}
+
+// ServeAdmin serves the admin endpoints on the admin address until ctx is done:
+// GET /log/level returns the log level and PUT /log/level with a {"level":"debug"} body changes it without a restart.
+// The endpoints are not authenticated, so the admin address is opt-in and should only be reachable locally.
+func (s *Server) ServeAdmin(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/log/level", s.logger.Level)
+	s.logger.Log.Info("admin", zap.String("starting serving on ....", s.config.AdminAddress))
+	return s.serveInternal(ctx, s.config.AdminAddress, mux)
+}
+
+// internalReadHeaderTimeout bounds reading the request headers on the internal listeners,
+// so idle or slow clients can't hold their connections open.
+const internalReadHeaderTimeout = 5 * time.Second
+
+// serveInternal serves handler on an internal listener, such as the metrics or admin one, until ctx is done.
+// Then it shuts the listener down, waiting for the in-flight requests up to the shutdown timeout.
+func (s *Server) serveInternal(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: internalReadHeaderTimeout}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
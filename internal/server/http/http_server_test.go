@@ -0,0 +1,156 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRoutes(t *testing.T) {
+	// the route table of the API, true marks the routes scoped to a user
+	want := map[string]bool{
+		"GET /{id}":                   false,
+		"GET /ping":                   false,
+		"GET /healthz":                false,
+		"GET /readyz":                 false,
+		"GET /api/openapi.json":       false,
+		"GET /api/docs":               false,
+		"POST /":                      true,
+		"POST /api/shorten":           true,
+		"POST /api/shorten/batch":     true,
+		"GET /api/user/urls":          true,
+		"DELETE /api/user/urls":       true,
+		"POST /api/user/urls/restore": true,
+		"PATCH /api/user/urls/{id}":   true,
+		"GET /api/user/tags":          true,
+	}
+	s := newTestServer(t)
+
+	tables := make(map[string]bool)
+	for _, rt := range s.publicRoutes() {
+		key := rt.method + " " + rt.pattern
+		require.NotContains(t, tables, key, "the route is registered once")
+		tables[key] = false
+	}
+	for _, rt := range s.authRoutes() {
+		key := rt.method + " " + rt.pattern
+		require.NotContains(t, tables, key, "the route is registered once")
+		tables[key] = true
+	}
+	assert.Equal(t, want, tables)
+
+	routes := s.Routes()
+	routed := make(map[string]bool)
+	err := chi.Walk(routes.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed[method+" "+route] = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, routed, len(want))
+
+	for key, authenticated := range want {
+		t.Run(key, func(t *testing.T) {
+			require.True(t, routed[key], "the route is served")
+			method, pattern, _ := strings.Cut(key, " ")
+			req := httptest.NewRequest(method, strings.ReplaceAll(pattern, "{id}", "unknown"), strings.NewReader("[]"))
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, req)
+			// a request without a token gets one from the auth middleware
+			if authenticated {
+				assert.True(t, strings.HasPrefix(w.Header().Get("Authorization"), "Bearer "), "the route is behind the auth middleware")
+			} else {
+				assert.Empty(t, w.Header().Get("Authorization"), "the route is public")
+			}
+		})
+	}
+}
+
+// freeAddress returns a local address nothing listens on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+// testShutdownTimeout is the shutdown timeout of the internal listeners in the tests, much shorter than the wait
+// for their shutdown in serveInternal, so a slow shutdown fails on the error of Shutdown rather than on the wait.
+const testShutdownTimeout = 100 * time.Millisecond
+
+// internalClient sends the requests to the internal listeners. It doesn't keep the connections alive,
+// so none of them is left open to delay the shutdown of a listener.
+var internalClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// serveInternal runs serve on addr until the test ends, checking that the listener is shut down with the context.
+func serveInternal(t *testing.T, addr string, serve func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			assert.NoError(t, err, "the listener is shut down with the context")
+		case <-time.After(testShutdownTimeout + 5*time.Second):
+			t.Fatal("the listener is still serving")
+		}
+		_, err := internalClient.Get("http://" + addr)
+		assert.Error(t, err)
+	})
+	require.Eventually(t, func() bool {
+		res, err := internalClient.Get("http://" + addr)
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// request sends a request to an internal listener, returning the status code.
+func request(t *testing.T, method, url, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	res, err := internalClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	return res.StatusCode
+}
+
+func TestServeMetrics(t *testing.T) {
+	s := newTestServer(t)
+	s.config.MetricsAddress = freeAddress(t)
+	s.config.ShutdownTimeout = testShutdownTimeout
+	serveInternal(t, s.config.MetricsAddress, s.ServeMetrics)
+
+	assert.Equal(t, http.StatusOK, request(t, http.MethodGet, "http://"+s.config.MetricsAddress+"/metrics", ""))
+	assert.Equal(t, http.StatusNotFound, request(t, http.MethodGet, "http://"+s.config.MetricsAddress+"/log/level", ""),
+		"the log level is served on the admin address only")
+}
+
+func TestServeAdmin(t *testing.T) {
+	s := newTestServer(t)
+	s.logger.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+	s.config.AdminAddress = freeAddress(t)
+	s.config.ShutdownTimeout = testShutdownTimeout
+	serveInternal(t, s.config.AdminAddress, s.ServeAdmin)
+
+	levelURL := "http://" + s.config.AdminAddress + "/log/level"
+	assert.Equal(t, http.StatusOK, request(t, http.MethodPut, levelURL, `{"level":"debug"}`))
+	assert.Equal(t, zap.DebugLevel, s.logger.Level.Level())
+	assert.Equal(t, http.StatusNotFound, request(t, http.MethodGet, "http://"+s.config.AdminAddress+"/metrics", ""))
+}
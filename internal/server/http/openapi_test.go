@@ -0,0 +1,295 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/app/domain/shorten"
+	"github.com/lookeme/short-url/internal/app/domain/user"
+	"github.com/lookeme/short-url/internal/compression"
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/health"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/security"
+	"github.com/lookeme/short-url/internal/server/handler"
+	"github.com/lookeme/short-url/internal/storage/inmemory"
+)
+
+// spec is the part of an OpenAPI document the contract test checks.
+type spec struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Responses map[string]response       `json:"responses"`
+		Schemas   map[string]map[string]any `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	OperationID string              `json:"operationId"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type response struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema map[string]any `json:"schema"`
+	} `json:"content"`
+}
+
+func loadSpec(t *testing.T) spec {
+	t.Helper()
+	var doc spec
+	require.NoError(t, json.Unmarshal(openAPISpec, &doc))
+	return doc
+}
+
+// response resolves a response of the document which may refer to a response of the components.
+func (s spec) response(r response) response {
+	if name, ok := strings.CutPrefix(r.Ref, "#/components/responses/"); ok {
+		return s.Components.Responses[name]
+	}
+	return r
+}
+
+// validate checks value decoded from JSON against the schema, reporting the mismatches with the path of the value.
+// It supports the subset of JSON Schema the document uses. Properties the schema doesn't declare are mismatches too,
+// unless the schema has no properties at all or allows them with additionalProperties.
+func (s spec) validate(schema map[string]any, value any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := s.Components.Schemas[name]
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown schema %s", path, ref)}
+		}
+		return s.validate(resolved, value, path)
+	}
+	mismatch := func(format string, args ...any) []string {
+		return []string{path + ": " + fmt.Sprintf(format, args...)}
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return mismatch("want an object, got %T", value)
+		}
+		var errs []string
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required property %s", path, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		for name, v := range obj {
+			if property, ok := properties[name].(map[string]any); ok {
+				errs = append(errs, s.validate(property, v, path+"."+name)...)
+			} else if additional != nil {
+				errs = append(errs, s.validate(additional, v, path+"."+name)...)
+			} else if properties != nil {
+				errs = append(errs, fmt.Sprintf("%s: undeclared property %s", path, name))
+			}
+		}
+		return errs
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return mismatch("want an array, got %T", value)
+		}
+		items, _ := schema["items"].(map[string]any)
+		var errs []string
+		for i, v := range arr {
+			errs = append(errs, s.validate(items, v, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return mismatch("want a string, got %T", value)
+		}
+		if enum, ok := schema["enum"].([]any); ok {
+			for _, allowed := range enum {
+				if allowed == str {
+					return nil
+				}
+			}
+			return mismatch("%q is not one of %v", str, enum)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return mismatch("%q is not a date-time", str)
+			}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			return mismatch("want an integer, got %v", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch("want a boolean, got %T", value)
+		}
+	}
+	return nil
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	netCfg := &configuration.NetworkCfg{BaseURL: "http://localhost:8080", CompressMinSize: compression.DefaultMinSize, MaxBatchSize: 100}
+	stCfg := &configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json"), DeletedGracePeriod: time.Hour}
+	zlog := &logger.Logger{Log: zap.NewNop()}
+	storageURL, err := inmemory.NewInMemShortenStorage(stCfg, zlog)
+	require.NoError(t, err)
+	t.Cleanup(func() { storageURL.Close() })
+	usrStorage, err := inmemory.NewInMemUserStorage(nil, zlog)
+	require.NoError(t, err)
+	urlService := shorten.NewURLService(storageURL, zlog, &configuration.Config{Network: netCfg, Storage: stCfg})
+	usrService := user.NewUserService(usrStorage, zlog)
+	checker := health.New(time.Second)
+	checker.Register("storage", urlService.Ping)
+	return NewServer(
+		handler.NewURLHandler(&urlService, &usrService),
+		netCfg,
+		zlog,
+		compression.New(netCfg),
+		security.New(&usrService, zlog),
+		checker,
+	)
+}
+
+// TestOpenAPIRoutes checks that the document describes exactly the routes of the router.
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadSpec(t)
+	var documented []string
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	var routed []string
+	seen := make(map[string]bool)
+	err := chi.Walk(newTestServer(t).Routes().(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if key := method + " " + route; !seen[key] {
+			seen[key] = true
+			routed = append(routed, key)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(documented)
+	sort.Strings(routed)
+	assert.Equal(t, documented, routed)
+}
+
+// TestOpenAPIContract sends requests covering every documented operation and checks that every response
+// has a documented status code, content type and body.
+func TestOpenAPIContract(t *testing.T) {
+	doc := loadSpec(t)
+	router := newTestServer(t).Routes().(chi.Router)
+	exercised := make(map[string]bool)
+	var token string
+
+	do := func(method, path, body string, authorized bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if authorized && token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if bearer := w.Header().Get("Authorization"); bearer != "" {
+			token = strings.TrimPrefix(bearer, "Bearer ")
+		}
+
+		rctx := chi.NewRouteContext()
+		require.True(t, router.Match(rctx, method, req.URL.Path), "%s %s is routed", method, path)
+		op, ok := doc.Paths[rctx.RoutePattern()][strings.ToLower(method)]
+		require.True(t, ok, "%s %s is documented", method, rctx.RoutePattern())
+		exercised[op.OperationID] = true
+
+		name := fmt.Sprintf("%s %s: %d", method, path, w.Code)
+		documented, ok := op.Responses[fmt.Sprint(w.Code)]
+		require.True(t, ok, "%s: the status is documented, body %s", name, w.Body.String())
+		documented = doc.response(documented)
+		if len(documented.Content) == 0 {
+			assert.Zero(t, w.Body.Len(), "%s: an undocumented body %s", name, w.Body.String())
+			return w
+		}
+		mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		require.NoError(t, err, name)
+		content, ok := documented.Content[mediaType]
+		require.True(t, ok, "%s: the content type %s is documented", name, mediaType)
+		if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+			var value any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &value), name)
+			assert.Empty(t, doc.validate(content.Schema, value, "body"), "%s: the body %s matches the schema", name, w.Body.String())
+		}
+		return w
+	}
+	keyOf := func(shortURL string) string {
+		return shortURL[strings.LastIndex(shortURL, "/")+1:]
+	}
+
+	w := do(http.MethodPost, "/", "https://go.dev/", false)
+	require.Equal(t, http.StatusCreated, w.Code)
+	key := keyOf(w.Body.String())
+	do(http.MethodPost, "/", "https://go.dev/", true)
+	do(http.MethodGet, "/"+key, "", false)
+	do(http.MethodGet, "/missing", "", false)
+
+	do(http.MethodPost, "/api/shorten", `{"url":"https://go.dev/doc","tags":["docs"]}`, false)
+	do(http.MethodPost, "/api/shorten", `{"url":"https://go.dev/doc"}`, false)
+	do(http.MethodPost, "/api/shorten", `{"url":`, false)
+	do(http.MethodPost, "/api/shorten/batch", `[{"correlation_id":"1","original_url":"https://go.dev/blog"}]`, false)
+	do(http.MethodPost, "/api/shorten/batch", `[{"correlation_id":"1","original_url":"https://go.dev/blog"}]`, false)
+
+	do(http.MethodGet, "/api/user/urls", "", true)
+	do(http.MethodGet, "/api/user/urls?tag=docs", "", true)
+	do(http.MethodPatch, "/api/user/urls/"+key, `{"tags":["go"]}`, true)
+	do(http.MethodPatch, "/api/user/urls/missing", `{}`, true)
+	do(http.MethodGet, "/api/user/tags", "", true)
+	do(http.MethodDelete, "/api/user/urls", `["`+key+`"]`, true)
+	do(http.MethodGet, "/"+key, "", false)
+	do(http.MethodPost, "/api/user/urls/restore", `["`+key+`"]`, true)
+
+	do(http.MethodGet, "/ping", "", false)
+	do(http.MethodGet, "/healthz", "", false)
+	do(http.MethodGet, "/readyz", "", false)
+	do(http.MethodGet, "/api/openapi.json", "", false)
+	w = do(http.MethodGet, "/api/docs", "", false)
+	assert.Contains(t, w.Body.String(), "/api/openapi.json")
+
+	for _, operations := range doc.Paths {
+		for method, op := range operations {
+			assert.True(t, exercised[op.OperationID], "%s %s is exercised", method, op.OperationID)
+		}
+	}
+}
+
+// TestOpenAPIServed checks that the document served is valid JSON of OpenAPI 3.
+func TestOpenAPIServed(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleOpenAPI(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+	}
+	require.NoError(t, json.Unmarshal(body, &doc))
+	assert.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+}
@@ -0,0 +1,96 @@
+// Package bolt implements the storage repositories on top of an embedded B+tree key-value database,
+// so the service can keep millions of URLs on a single node without running PostgreSQL.
+package bolt
+
+import (
+	"encoding/binary"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+)
+
+var (
+	// shortBucket stores the shorten data records keyed by short URL.
+	shortBucket = []byte("short")
+	// originalBucket is the secondary index mapping an original URL to its short URL.
+	originalBucket = []byte("short_by_original_url")
+	// userBucket is the secondary index of the short URLs of every user,
+	// its keys are the user ID followed by the record ID, both big endian.
+	userBucket = []byte("short_by_user")
+	// tagBucket is the index of the short URLs marked with every tag,
+	// its keys are the tag followed by a zero byte and the short URL.
+	tagBucket = []byte("short_by_tag")
+	// historyBucket stores the previous destinations of the short URLs,
+	// its keys are the short URL followed by a zero byte and a sequence number.
+	historyBucket = []byte("short_history")
+	// usersBucket stores the users keyed by their ID.
+	usersBucket = []byte("users")
+)
+
+// Bolt is a type representing an open embedded key-value database.
+// It contains a *bbolt.DB object and a *logger.Logger object for logging.
+type Bolt struct {
+	db  *bbolt.DB
+	log *logger.Logger
+}
+
+// New opens or creates the database file given by cfg.BoltFilePath and makes sure all buckets exist.
+func New(log *logger.Logger, cfg *configuration.Storage) (*Bolt, error) {
+	log.Log.Info("opening key-value storage...", zap.String("path", cfg.BoltFilePath))
+	db, err := bbolt.Open(cfg.BoltFilePath, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{shortBucket, originalBucket, userBucket, tagBucket, historyBucket, usersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db, log: log}, nil
+}
+
+// Close closes the database file.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// itob returns an 8-byte big endian representation of v, so that the keys are sorted numerically.
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// userKey returns the key of the user index entry for the given user and record IDs.
+func userKey(userID int, id int64) []byte {
+	return append(itob(uint64(userID)), itob(uint64(id))...)
+}
+
+// tagKey returns the key of the tag index entry for the given tag and short URL.
+func tagKey(tag, shortURL string) []byte {
+	key := make([]byte, 0, len(tag)+len(shortURL)+1)
+	key = append(key, tag...)
+	key = append(key, 0)
+	return append(key, shortURL...)
+}
+
+// historyPrefix returns the common prefix of the history keys of the given short URL.
+func historyPrefix(shortURL string) []byte {
+	return append([]byte(shortURL), 0)
+}
+
+// historyKey returns the key of a history entry of the given short URL.
+func historyKey(shortURL string, seq uint64) []byte {
+	return append(historyPrefix(shortURL), itob(seq)...)
+}
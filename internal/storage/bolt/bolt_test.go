@@ -0,0 +1,125 @@
+package bolt
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/storage/storagetest"
+)
+
+func TestShortenRepository(t *testing.T) {
+	ctx := context.Background()
+	log := &logger.Logger{Log: zap.NewNop()}
+	cfg := &configuration.Storage{BoltFilePath: filepath.Join(t.TempDir(), "short-url.db")}
+	kv, err := New(log, cfg)
+	require.NoError(t, err)
+	repo := NewShortenRepository(kv)
+
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	require.NoError(t, repo.Save(ctx, "http://localhost/b", "https://b.example", 1))
+	require.NoError(t, repo.SaveAll(ctx, []models.ShortenData{
+		{ShortURL: "http://localhost/c", OriginalURL: "https://c.example", UserID: 2, Tags: []string{"promo"}},
+	}))
+	assert.ErrorIs(t, repo.Save(ctx, "http://localhost/d", "https://a.example", 1), storage.ErrConflict)
+
+	data, ok := repo.FindByURL(ctx, "https://b.example")
+	require.True(t, ok)
+	assert.Equal(t, "http://localhost/b", data.ShortURL)
+	assert.Equal(t, int64(2), data.ID)
+
+	urls, err := repo.FindAllByUserID(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal(t, "http://localhost/b", urls[0].ShortURL)
+	assert.Equal(t, "http://localhost/a", urls[1].ShortURL)
+
+	require.NoError(t, repo.Update(ctx, "http://localhost/b", "https://b2.example", 1))
+	_, ok = repo.FindByURL(ctx, "https://b.example")
+	assert.False(t, ok)
+	require.NoError(t, repo.SaveTags(ctx, "http://localhost/b", []string{"promo", "spring"}))
+	require.NoError(t, repo.SaveTags(ctx, "http://localhost/a", []string{"spring"}))
+	tagged, err := repo.FindAllByUserIDAndTag(ctx, 1, "promo")
+	require.NoError(t, err)
+	require.Len(t, tagged, 1)
+	assert.Equal(t, "https://b2.example", tagged[0].OriginalURL)
+	tags, err := repo.CountTagsByUserID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{{Tag: "spring", Count: 2}, {Tag: "promo", Count: 1}}, tags)
+
+	require.True(t, repo.DeleteByShortURL(ctx, "http://localhost/a", 1))
+	assert.False(t, repo.DeleteByShortURL(ctx, "http://localhost/unknown", 1))
+	urls, err = repo.FindAllByUserID(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+	restored, err := repo.RestoreByShortURL(ctx, "http://localhost/a", 2, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.False(t, restored)
+	restored, err = repo.RestoreByShortURL(ctx, "http://localhost/a", 1, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.True(t, restored)
+	require.True(t, repo.DeleteByShortURL(ctx, "http://localhost/a", 1))
+	require.NoError(t, repo.Close())
+
+	kv, err = New(log, cfg)
+	require.NoError(t, err)
+	repo = NewShortenRepository(kv)
+	defer repo.Close()
+	data, err = repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	assert.True(t, data.DeletedFlag)
+	count, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	_, err = repo.FindByKey(ctx, "http://localhost/a")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	tags, err = repo.CountTagsByUserID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{{Tag: "promo", Count: 1}, {Tag: "spring", Count: 1}}, tags)
+	require.NoError(t, repo.Save(ctx, "http://localhost/e", "https://a.example", 1))
+	data, err = repo.FindByKey(ctx, "http://localhost/e")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), data.ID)
+
+	users := NewUserRepository(kv)
+	first, err := users.SaveUser(ctx, "first", "hash")
+	require.NoError(t, err)
+	second, err := users.SaveUser(ctx, "second", "hash")
+	require.NoError(t, err)
+	assert.Greater(t, second, first)
+	user, err := users.FindByID(ctx, second)
+	require.NoError(t, err)
+	assert.Equal(t, "second", user.Name)
+	_, err = users.FindByID(ctx, second+1)
+	assert.Error(t, err)
+}
+
+// openBolt creates an empty database in a temporary directory which is closed when the test ends.
+func openBolt(t *testing.T) *Bolt {
+	t.Helper()
+	db, err := New(&logger.Logger{Log: zap.NewNop()}, &configuration.Storage{BoltFilePath: filepath.Join(t.TempDir(), "short-url.db")})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestShortenRepositoryConformance(t *testing.T) {
+	storagetest.RunShortenRepository(t, func(t *testing.T) storage.ShortenRepository {
+		return NewShortenRepository(openBolt(t))
+	})
+}
+
+func TestUserRepositoryConformance(t *testing.T) {
+	storagetest.RunUserRepository(t, func(t *testing.T) storage.UserRepository {
+		return NewUserRepository(openBolt(t))
+	})
+}
@@ -0,0 +1,543 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+)
+
+// errShortURLExists is returned when a generated short URL is already taken.
+var errShortURLExists = errors.New("short url already exists")
+
+// ShortenRepository represents a repository for storing shortened URLs in the key-value database.
+type ShortenRepository struct {
+	bolt *Bolt
+}
+
+// NewShortenRepository initializes a new instance of ShortenRepository on top of the given database.
+func NewShortenRepository(bolt *Bolt) *ShortenRepository {
+	return &ShortenRepository{
+		bolt: bolt,
+	}
+}
+
+// entry is the stored representation of a ShortenData object.
+type entry struct {
+	ID            int64      `json:"id"`
+	CorrelationID string     `json:"correlation_id,omitempty"`
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	UserID        int        `json:"user_id"`
+	Deleted       bool       `json:"deleted,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+}
+
+// newEntry creates an entry from the ShortenData object.
+func newEntry(data models.ShortenData) entry {
+	return entry{
+		ID:            data.ID,
+		CorrelationID: data.CorrelationID,
+		ShortURL:      data.ShortURL,
+		OriginalURL:   data.OriginalURL,
+		UserID:        data.UserID,
+		Deleted:       data.DeletedFlag,
+		DeletedAt:     data.DeletedAt,
+		Tags:          data.Tags,
+	}
+}
+
+// data returns the ShortenData object described by the entry.
+func (e entry) data() models.ShortenData {
+	return models.ShortenData{
+		ID:            e.ID,
+		CorrelationID: e.CorrelationID,
+		ShortURL:      e.ShortURL,
+		OriginalURL:   e.OriginalURL,
+		UserID:        e.UserID,
+		DeletedFlag:   e.Deleted,
+		DeletedAt:     e.DeletedAt,
+		Tags:          e.Tags,
+	}
+}
+
+// Save stores a new record with the given short URL, original URL and user ID.
+// It returns a *storage.ConflictError if the original URL is already shortened.
+func (r *ShortenRepository) Save(_ context.Context, key, value string, userID int) error {
+	return r.bolt.db.Update(func(tx *bbolt.Tx) error {
+		return insert(tx, models.ShortenData{ShortURL: key, OriginalURL: value, UserID: userID})
+	})
+}
+
+// SaveAll stores multiple records within a single transaction.
+// If any of the records can't be stored, none of them is.
+func (r *ShortenRepository) SaveAll(_ context.Context, rows []models.ShortenData) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return r.bolt.db.Update(func(tx *bbolt.Tx) error {
+		for _, row := range rows {
+			if err := insert(tx, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveTags replaces the tags attached to the record with the given short URL.
+// It returns storage.ErrNotFound if there is no such record.
+func (r *ShortenRepository) SaveTags(_ context.Context, shortURL string, tags []string) error {
+	return r.bolt.db.Update(func(tx *bbolt.Tx) error {
+		prev, ok, err := get(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return storage.ErrNotFound
+		}
+		e := prev
+		e.Tags = tags
+		return put(tx, &prev, e)
+	})
+}
+
+// Update replaces the original URL of the record with the given short URL
+// and stores the previous destination along with the ID of the user who changed it.
+// It returns storage.ErrNotFound if there is no such record and a *storage.ConflictError
+// if the new original URL is already shortened.
+func (r *ShortenRepository) Update(_ context.Context, shortURL, originalURL string, userID int) error {
+	return r.bolt.db.Update(func(tx *bbolt.Tx) error {
+		prev, ok, err := get(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return storage.ErrNotFound
+		}
+		if prev.OriginalURL == originalURL {
+			return nil
+		}
+		if shortURL := tx.Bucket(originalBucket).Get([]byte(originalURL)); shortURL != nil {
+			return conflict(tx, shortURL)
+		}
+		history := tx.Bucket(historyBucket)
+		seq, err := history.NextSequence()
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(models.URLHistory{
+			ShortURL:    shortURL,
+			OriginalURL: prev.OriginalURL,
+			ChangedBy:   userID,
+			ChangedAt:   time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if err := history.Put(historyKey(shortURL, seq), b); err != nil {
+			return err
+		}
+		e := prev
+		e.OriginalURL = originalURL
+		return put(tx, &prev, e)
+	})
+}
+
+// FindByURL searches for a not deleted record by the original URL using the secondary index.
+// It returns the matching record and a flag indicating whether the record was found.
+func (r *ShortenRepository) FindByURL(ctx context.Context, key string) (models.ShortenData, bool) {
+	var (
+		e  entry
+		ok bool
+	)
+	err := r.bolt.db.View(func(tx *bbolt.Tx) error {
+		shortURL := tx.Bucket(originalBucket).Get([]byte(key))
+		if shortURL == nil {
+			return nil
+		}
+		var err error
+		e, ok, err = get(tx, string(shortURL))
+		return err
+	})
+	if err != nil {
+		r.bolt.log.Ctx(ctx).Error(err.Error(), zap.String("during fetching by url", key))
+		return models.ShortenData{}, false
+	}
+	if !ok || e.Deleted {
+		return models.ShortenData{}, false
+	}
+	return e.data(), true
+}
+
+// FindByURLs retrieves the not deleted records matching the given original URLs.
+func (r *ShortenRepository) FindByURLs(_ context.Context, keys []string) ([]models.ShortenData, error) {
+	var result []models.ShortenData
+	err := r.bolt.db.View(func(tx *bbolt.Tx) error {
+		index := tx.Bucket(originalBucket)
+		for _, key := range keys {
+			shortURL := index.Get([]byte(key))
+			if shortURL == nil {
+				continue
+			}
+			e, ok, err := get(tx, string(shortURL))
+			if err != nil {
+				return err
+			}
+			if ok && !e.Deleted {
+				result = append(result, e.data())
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// FindByKey searches for a record by the short URL, including deleted ones.
+// It returns storage.ErrNotFound if there is no such record.
+func (r *ShortenRepository) FindByKey(_ context.Context, key string) (models.ShortenData, error) {
+	var (
+		e  entry
+		ok bool
+	)
+	err := r.bolt.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		e, ok, err = get(tx, key)
+		return err
+	})
+	if err != nil {
+		return models.ShortenData{}, err
+	}
+	if !ok {
+		return models.ShortenData{}, storage.ErrNotFound
+	}
+	return e.data(), nil
+}
+
+// FindAll retrieves all not deleted records ordered from the newest to the oldest.
+func (r *ShortenRepository) FindAll(_ context.Context) ([]models.ShortenData, error) {
+	var result []models.ShortenData
+	err := r.bolt.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(shortBucket).ForEach(func(_, v []byte) error {
+			var e entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if !e.Deleted {
+				result = append(result, e.data())
+			}
+			return nil
+		})
+	})
+	sortNewestFirst(result)
+	return result, err
+}
+
+// FindAllByUserID retrieves all not deleted records of the given user ordered from the newest to the oldest.
+// It walks the user index backwards, so only the records of the user are read.
+func (r *ShortenRepository) FindAllByUserID(_ context.Context, userID int) ([]models.ShortenData, error) {
+	var result []models.ShortenData
+	err := r.bolt.db.View(func(tx *bbolt.Tx) error {
+		entries, err := userEntries(tx, userID)
+		for _, e := range entries {
+			result = append(result, e.data())
+		}
+		return err
+	})
+	return result, err
+}
+
+// FindAllByUserIDAndTag retrieves all not deleted records of the given user marked with the tag,
+// ordered from the newest to the oldest.
+func (r *ShortenRepository) FindAllByUserIDAndTag(_ context.Context, userID int, tag string) ([]models.ShortenData, error) {
+	var result []models.ShortenData
+	err := r.bolt.db.View(func(tx *bbolt.Tx) error {
+		prefix := tagKey(tag, "")
+		c := tx.Bucket(tagBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			e, ok, err := get(tx, string(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			if ok && e.UserID == userID && !e.Deleted {
+				result = append(result, e.data())
+			}
+		}
+		return nil
+	})
+	sortNewestFirst(result)
+	return result, err
+}
+
+// CountTagsByUserID returns every tag used by the given user along with the number of not deleted records marked with it.
+// The result is ordered by count in descending order and then by tag.
+func (r *ShortenRepository) CountTagsByUserID(_ context.Context, userID int) ([]models.TagCount, error) {
+	counts := make(map[string]int)
+	err := r.bolt.db.View(func(tx *bbolt.Tx) error {
+		entries, err := userEntries(tx, userID)
+		for _, e := range entries {
+			for _, tag := range e.Tags {
+				counts[tag]++
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result []models.TagCount
+	for tag, count := range counts {
+		result = append(result, models.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result, nil
+}
+
+// DeleteByShortURL marks the record of the user with the given short URL as deleted and remembers the moment of deletion.
+// It returns false if the user has no such record.
+func (r *ShortenRepository) DeleteByShortURL(ctx context.Context, shortURL string, userID int) bool {
+	found := false
+	err := r.bolt.db.Update(func(tx *bbolt.Tx) error {
+		prev, ok, err := get(tx, shortURL)
+		if err != nil || !ok || prev.UserID != userID {
+			return err
+		}
+		found = true
+		if prev.Deleted {
+			return nil
+		}
+		now := time.Now()
+		e := prev
+		e.Deleted = true
+		e.DeletedAt = &now
+		return put(tx, &prev, e)
+	})
+	if err != nil {
+		r.bolt.log.Ctx(ctx).Error(err.Error(), zap.String("during deleting short url", shortURL))
+		return false
+	}
+	return found
+}
+
+// RestoreByShortURL restores a record of the user deleted after the given moment.
+// It returns true if the record was restored.
+func (r *ShortenRepository) RestoreByShortURL(_ context.Context, shortURL string, userID int, deletedAfter time.Time) (bool, error) {
+	restored := false
+	err := r.bolt.db.Update(func(tx *bbolt.Tx) error {
+		prev, ok, err := get(tx, shortURL)
+		if err != nil || !ok {
+			return err
+		}
+		if !prev.Deleted || prev.UserID != userID || prev.DeletedAt == nil || prev.DeletedAt.Before(deletedAfter) {
+			return nil
+		}
+		e := prev
+		e.Deleted = false
+		e.DeletedAt = nil
+		restored = true
+		return put(tx, &prev, e)
+	})
+	if err != nil {
+		return false, err
+	}
+	return restored, nil
+}
+
+// PurgeDeleted permanently removes the records deleted before the given moment along with their index entries and history.
+// It returns the number of removed records.
+func (r *ShortenRepository) PurgeDeleted(_ context.Context, deletedBefore time.Time) (int, error) {
+	count := 0
+	err := r.bolt.db.Update(func(tx *bbolt.Tx) error {
+		var expired []entry
+		err := tx.Bucket(shortBucket).ForEach(func(_, v []byte) error {
+			var e entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if e.Deleted && e.DeletedAt != nil && e.DeletedAt.Before(deletedBefore) {
+				expired = append(expired, e)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range expired {
+			if err := remove(tx, e); err != nil {
+				return err
+			}
+		}
+		count = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Ping reports whether the database is open.
+func (r *ShortenRepository) Ping(_ context.Context) error {
+	return r.bolt.db.View(func(_ *bbolt.Tx) error { return nil })
+}
+
+// Close closes the underlying database.
+func (r *ShortenRepository) Close() error {
+	return r.bolt.Close()
+}
+
+// get reads the record with the given short URL.
+func get(tx *bbolt.Tx, shortURL string) (entry, bool, error) {
+	v := tx.Bucket(shortBucket).Get([]byte(shortURL))
+	if v == nil {
+		return entry{}, false, nil
+	}
+	var e entry
+	if err := json.Unmarshal(v, &e); err != nil {
+		return entry{}, false, err
+	}
+	return e, true, nil
+}
+
+// conflict returns the *storage.ConflictError carrying the record with the given short URL.
+func conflict(tx *bbolt.Tx, shortURL []byte) error {
+	e, ok, err := get(tx, string(shortURL))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: the index refers to the missing %s", storage.ErrConflict, shortURL)
+	}
+	return &storage.ConflictError{Existing: e.data()}
+}
+
+// insert assigns the next ID to the new record and stores it with its index entries.
+func insert(tx *bbolt.Tx, data models.ShortenData) error {
+	if shortURL := tx.Bucket(originalBucket).Get([]byte(data.OriginalURL)); shortURL != nil {
+		return conflict(tx, shortURL)
+	}
+	shorts := tx.Bucket(shortBucket)
+	if shorts.Get([]byte(data.ShortURL)) != nil {
+		return errShortURLExists
+	}
+	id, err := shorts.NextSequence()
+	if err != nil {
+		return err
+	}
+	data.ID = int64(id)
+	e := newEntry(data)
+	if err := tx.Bucket(userBucket).Put(userKey(e.UserID, e.ID), []byte(e.ShortURL)); err != nil {
+		return err
+	}
+	return put(tx, nil, e)
+}
+
+// put stores the record and brings the original URL and tag indexes from the previous state of the record, if any, to the new one.
+func put(tx *bbolt.Tx, prev *entry, e entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(shortBucket).Put([]byte(e.ShortURL), b); err != nil {
+		return err
+	}
+	originals := tx.Bucket(originalBucket)
+	tags := tx.Bucket(tagBucket)
+	if prev != nil {
+		if prev.OriginalURL != e.OriginalURL {
+			if err := originals.Delete([]byte(prev.OriginalURL)); err != nil {
+				return err
+			}
+		}
+		for _, tag := range prev.Tags {
+			if err := tags.Delete(tagKey(tag, prev.ShortURL)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := originals.Put([]byte(e.OriginalURL), []byte(e.ShortURL)); err != nil {
+		return err
+	}
+	for _, tag := range e.Tags {
+		if err := tags.Put(tagKey(tag, e.ShortURL), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes the record along with its index entries and history.
+func remove(tx *bbolt.Tx, e entry) error {
+	if err := tx.Bucket(shortBucket).Delete([]byte(e.ShortURL)); err != nil {
+		return err
+	}
+	originals := tx.Bucket(originalBucket)
+	if bytes.Equal(originals.Get([]byte(e.OriginalURL)), []byte(e.ShortURL)) {
+		if err := originals.Delete([]byte(e.OriginalURL)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(userBucket).Delete(userKey(e.UserID, e.ID)); err != nil {
+		return err
+	}
+	tags := tx.Bucket(tagBucket)
+	for _, tag := range e.Tags {
+		if err := tags.Delete(tagKey(tag, e.ShortURL)); err != nil {
+			return err
+		}
+	}
+	prefix := historyPrefix(e.ShortURL)
+	history := tx.Bucket(historyBucket)
+	c := history.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := history.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userEntries reads the not deleted records of the user from the newest to the oldest.
+func userEntries(tx *bbolt.Tx, userID int) ([]entry, error) {
+	var result []entry
+	prefix := itob(uint64(userID))
+	c := tx.Bucket(userBucket).Cursor()
+	k, v := c.Seek(itob(uint64(userID) + 1))
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Prev() {
+		e, ok, err := get(tx, string(v))
+		if err != nil {
+			return nil, err
+		}
+		if ok && !e.Deleted {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// sortNewestFirst orders the records by ID in descending order.
+func sortNewestFirst(data []models.ShortenData) {
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].ID > data[j].ID
+	})
+}
@@ -0,0 +1,65 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/lookeme/short-url/internal/models"
+)
+
+// UserRepository represents a repository for managing user data in the key-value database.
+type UserRepository struct {
+	bolt *Bolt
+}
+
+// NewUserRepository creates a new instance of UserRepository on top of the given database.
+func NewUserRepository(bolt *Bolt) *UserRepository {
+	return &UserRepository{
+		bolt: bolt,
+	}
+}
+
+// SaveUser saves a new user with the given name and password and returns the ID assigned to the user.
+// The IDs come from the sequence of the users bucket, so they keep growing across restarts.
+func (u *UserRepository) SaveUser(_ context.Context, name, pass string) (int, error) {
+	var user models.User
+	err := u.bolt.db.Update(func(tx *bbolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		id, err := users.NextSequence()
+		if err != nil {
+			return err
+		}
+		user = models.User{
+			UserID:   int(id),
+			Name:     name,
+			Pass:     pass,
+			IsActive: true,
+		}
+		b, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return users.Put(itob(id), b)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return user.UserID, nil
+}
+
+// FindByID finds a user by their userID.
+// It returns an error if the user doesn't exist.
+func (u *UserRepository) FindByID(_ context.Context, userID int) (models.User, error) {
+	var user models.User
+	err := u.bolt.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(usersBucket).Get(itob(uint64(userID)))
+		if v == nil {
+			return errors.New("user doesn't exist")
+		}
+		return json.Unmarshal(v, &user)
+	})
+	return user, err
+}
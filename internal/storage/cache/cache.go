@@ -0,0 +1,203 @@
+// Package cache implements a read-through cache in front of a storage.ShortenRepository.
+// Redirects look up the same few short URLs over and over, so FindByKey results are kept in a bounded LRU
+// with a time to live, sparing the underlying storage most of the redirect traffic.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+)
+
+// Stats holds the counters of a Repository.
+type Stats struct {
+	// Hits is the number of FindByKey calls answered from the cache.
+	Hits uint64
+	// Misses is the number of FindByKey calls passed to the underlying repository.
+	Misses uint64
+	// Size is the number of cached entries, including the cached absence of a short URL.
+	Size int
+}
+
+// Repository is a storage.ShortenRepository caching the results of FindByKey of the wrapped repository.
+// Both found and missing short URLs are cached, so repeated requests for unknown links don't reach the storage either.
+// Failed lookups aren't cached, so a storage outage doesn't turn into cached misses.
+// Every method changing a short URL invalidates its entry; the other methods are passed through.
+// The time to live bounds how long a change made by another instance of the service may stay unnoticed.
+type Repository struct {
+	storage.ShortenRepository
+	size int
+	ttl  time.Duration
+	// items indexes the elements of order by short URL.
+	items map[string]*list.Element
+	// order keeps the entries from the most to the least recently used.
+	order *list.List
+	// generation is incremented on every invalidation, so a lookup racing with a change doesn't cache a stale result.
+	generation uint64
+	mutex      sync.Mutex
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	now        func() time.Time
+}
+
+// entry is a cached result of FindByKey.
+type entry struct {
+	key     string
+	data    models.ShortenData
+	found   bool
+	expires time.Time
+}
+
+// New wraps the repository with a cache of at most size entries, each kept for ttl.
+// A ttl of zero keeps the entries until they are evicted or invalidated.
+func New(repo storage.ShortenRepository, size int, ttl time.Duration) *Repository {
+	return &Repository{
+		ShortenRepository: repo,
+		size:              size,
+		ttl:               ttl,
+		items:             make(map[string]*list.Element, size),
+		order:             list.New(),
+		now:               time.Now,
+	}
+}
+
+// FindByKey returns the cached result for the short URL, or looks it up in the underlying repository and caches it.
+// Only the data and storage.ErrNotFound are cached, other errors of the underlying repository are returned as is.
+func (r *Repository) FindByKey(ctx context.Context, key string) (models.ShortenData, error) {
+	r.mutex.Lock()
+	if elem, ok := r.items[key]; ok {
+		e := elem.Value.(*entry)
+		if r.ttl == 0 || r.now().Before(e.expires) {
+			r.order.MoveToFront(elem)
+			r.mutex.Unlock()
+			r.hits.Add(1)
+			if !e.found {
+				return models.ShortenData{}, storage.ErrNotFound
+			}
+			return e.data, nil
+		}
+		r.removeElement(elem)
+	}
+	generation := r.generation
+	r.mutex.Unlock()
+
+	r.misses.Add(1)
+	data, err := r.ShortenRepository.FindByKey(ctx, key)
+	found := err == nil
+	if !found && !errors.Is(err, storage.ErrNotFound) {
+		return data, err
+	}
+
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	if generation == r.generation {
+		r.add(&entry{key: key, data: data, found: found, expires: r.now().Add(r.ttl)})
+	}
+	return data, err
+}
+
+// Save stores a new short URL and drops its cached absence.
+func (r *Repository) Save(ctx context.Context, key, value string, userID int) error {
+	defer r.invalidate(key)
+	return r.ShortenRepository.Save(ctx, key, value, userID)
+}
+
+// SaveAll stores the short URLs and drops their cached absence.
+func (r *Repository) SaveAll(ctx context.Context, data []models.ShortenData) error {
+	keys := make([]string, 0, len(data))
+	for _, d := range data {
+		keys = append(keys, d.ShortURL)
+	}
+	defer r.invalidate(keys...)
+	return r.ShortenRepository.SaveAll(ctx, data)
+}
+
+// SaveTags replaces the tags of the short URL and invalidates its entry.
+func (r *Repository) SaveTags(ctx context.Context, shortURL string, tags []string) error {
+	defer r.invalidate(shortURL)
+	return r.ShortenRepository.SaveTags(ctx, shortURL, tags)
+}
+
+// Update replaces the original URL of the short URL and invalidates its entry.
+func (r *Repository) Update(ctx context.Context, shortURL, originalURL string, userID int) error {
+	defer r.invalidate(shortURL)
+	return r.ShortenRepository.Update(ctx, shortURL, originalURL, userID)
+}
+
+// DeleteByShortURL marks the short URL of the user as deleted and invalidates its entry.
+func (r *Repository) DeleteByShortURL(ctx context.Context, shortURL string, userID int) bool {
+	defer r.invalidate(shortURL)
+	return r.ShortenRepository.DeleteByShortURL(ctx, shortURL, userID)
+}
+
+// RestoreByShortURL restores the deleted short URL and invalidates its entry.
+func (r *Repository) RestoreByShortURL(ctx context.Context, shortURL string, userID int, deletedAfter time.Time) (bool, error) {
+	defer r.invalidate(shortURL)
+	return r.ShortenRepository.RestoreByShortURL(ctx, shortURL, userID, deletedAfter)
+}
+
+// PurgeDeleted removes the expired short URLs from the underlying repository.
+// The purged short URLs aren't known in advance, so the whole cache is cleared.
+func (r *Repository) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	defer r.clear()
+	return r.ShortenRepository.PurgeDeleted(ctx, deletedBefore)
+}
+
+// Stats returns the current counters of the cache.
+func (r *Repository) Stats() Stats {
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	return Stats{
+		Hits:   r.hits.Load(),
+		Misses: r.misses.Load(),
+		Size:   r.order.Len(),
+	}
+}
+
+// invalidate drops the entries of the given short URLs.
+// It runs after the change reached the underlying repository, and the generation makes sure
+// that a lookup started before the change doesn't put the old state back.
+func (r *Repository) invalidate(keys ...string) {
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	r.generation++
+	for _, key := range keys {
+		if elem, ok := r.items[key]; ok {
+			r.removeElement(elem)
+		}
+	}
+}
+
+// clear drops all entries.
+func (r *Repository) clear() {
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	r.generation++
+	r.items = make(map[string]*list.Element, r.size)
+	r.order.Init()
+}
+
+// add puts the entry in front of the list and evicts the least recently used entries beyond the size.
+// It must be called with the mutex held.
+func (r *Repository) add(e *entry) {
+	if elem, ok := r.items[e.key]; ok {
+		r.removeElement(elem)
+	}
+	r.items[e.key] = r.order.PushFront(e)
+	for r.order.Len() > r.size {
+		r.removeElement(r.order.Back())
+	}
+}
+
+// removeElement drops the element from the list and the index.
+// It must be called with the mutex held.
+func (r *Repository) removeElement(elem *list.Element) {
+	r.order.Remove(elem)
+	delete(r.items, elem.Value.(*entry).key)
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/storage/inmemory"
+	"github.com/lookeme/short-url/internal/storage/storagetest"
+)
+
+// countingRepository counts the FindByKey calls reaching the wrapped repository.
+// While failing is set, the calls fail as if the storage were unreachable.
+type countingRepository struct {
+	storage.ShortenRepository
+	lookups atomic.Int64
+	failing atomic.Bool
+}
+
+var errUnreachable = errors.New("connection refused")
+
+func (c *countingRepository) FindByKey(ctx context.Context, key string) (models.ShortenData, error) {
+	c.lookups.Add(1)
+	if c.failing.Load() {
+		return models.ShortenData{}, errUnreachable
+	}
+	return c.ShortenRepository.FindByKey(ctx, key)
+}
+
+func newRepository(t *testing.T) *countingRepository {
+	t.Helper()
+	cfg := &configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	repo, err := inmemory.NewInMemShortenStorage(cfg, &logger.Logger{Log: zap.NewNop()})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return &countingRepository{ShortenRepository: repo}
+}
+
+func TestShortenRepositoryConformance(t *testing.T) {
+	storagetest.RunShortenRepository(t, func(t *testing.T) storage.ShortenRepository {
+		return New(newRepository(t), 100, time.Minute)
+	})
+}
+
+func TestFindByKey(t *testing.T) {
+	ctx := context.Background()
+	backend := newRepository(t)
+	repo := New(backend, 100, time.Minute)
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+
+	for i := 0; i < 3; i++ {
+		data, err := repo.FindByKey(ctx, "http://localhost/a")
+		require.NoError(t, err)
+		assert.Equal(t, "https://a.example", data.OriginalURL)
+		_, err = repo.FindByKey(ctx, "http://localhost/unknown")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+	assert.Equal(t, int64(2), backend.lookups.Load(), "found and missing keys are both cached")
+	assert.Equal(t, Stats{Hits: 4, Misses: 2, Size: 2}, repo.Stats())
+}
+
+func TestFailuresAreNotCached(t *testing.T) {
+	ctx := context.Background()
+	backend := newRepository(t)
+	repo := New(backend, 100, time.Minute)
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+
+	backend.failing.Store(true)
+	for i := 0; i < 2; i++ {
+		_, err := repo.FindByKey(ctx, "http://localhost/a")
+		require.ErrorIs(t, err, errUnreachable)
+		assert.NotErrorIs(t, err, storage.ErrNotFound)
+	}
+	assert.Equal(t, int64(2), backend.lookups.Load(), "every failed lookup reaches the storage")
+	assert.Zero(t, repo.Stats().Size)
+
+	backend.failing.Store(false)
+	data, err := repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err, "the URL is found once the storage recovers")
+	assert.Equal(t, "https://a.example", data.OriginalURL)
+}
+
+func TestInvalidation(t *testing.T) {
+	ctx := context.Background()
+	backend := newRepository(t)
+	repo := New(backend, 100, time.Minute)
+
+	_, err := repo.FindByKey(ctx, "http://localhost/a")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	_, err = repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err, "saving drops the cached absence")
+
+	require.NoError(t, repo.Update(ctx, "http://localhost/a", "https://a2.example", 1))
+	data, _ := repo.FindByKey(ctx, "http://localhost/a")
+	assert.Equal(t, "https://a2.example", data.OriginalURL)
+
+	require.True(t, repo.DeleteByShortURL(ctx, "http://localhost/a", 1))
+	data, _ = repo.FindByKey(ctx, "http://localhost/a")
+	assert.True(t, data.DeletedFlag)
+
+	restored, err := repo.RestoreByShortURL(ctx, "http://localhost/a", 1, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.True(t, restored)
+	data, _ = repo.FindByKey(ctx, "http://localhost/a")
+	assert.False(t, data.DeletedFlag)
+
+	require.NoError(t, repo.SaveAll(ctx, []models.ShortenData{{ShortURL: "http://localhost/b", OriginalURL: "https://b.example"}}))
+	_, err = repo.FindByKey(ctx, "http://localhost/b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), backend.lookups.Load())
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	backend := newRepository(t)
+	repo := New(backend, 2, time.Minute)
+	repo.FindByKey(ctx, "a")
+	repo.FindByKey(ctx, "b")
+	repo.FindByKey(ctx, "a")
+	repo.FindByKey(ctx, "c")
+	assert.Equal(t, 2, repo.Stats().Size)
+	assert.Equal(t, int64(3), backend.lookups.Load())
+
+	repo.FindByKey(ctx, "a")
+	assert.Equal(t, int64(3), backend.lookups.Load(), "the recently used key stays")
+	repo.FindByKey(ctx, "b")
+	assert.Equal(t, int64(4), backend.lookups.Load(), "the least recently used key is evicted")
+}
+
+func TestExpiration(t *testing.T) {
+	ctx := context.Background()
+	backend := newRepository(t)
+	repo := New(backend, 10, time.Minute)
+	now := time.Now()
+	repo.now = func() time.Time { return now }
+
+	repo.FindByKey(ctx, "a")
+	now = now.Add(59 * time.Second)
+	repo.FindByKey(ctx, "a")
+	assert.Equal(t, int64(1), backend.lookups.Load())
+	now = now.Add(time.Second)
+	repo.FindByKey(ctx, "a")
+	assert.Equal(t, int64(2), backend.lookups.Load())
+}
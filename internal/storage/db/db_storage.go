@@ -2,10 +2,18 @@ package db
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/lookeme/short-url/internal/storage"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 
@@ -13,39 +21,54 @@ import (
 	"github.com/lookeme/short-url/internal/logger"
 )
 
-var (
-
-	// pgInstance is a pointer to a Postgres struct variable used for storing the initialized instance of the Postgres database connection pool and logger.
-	pgInstance *Postgres
-
-	// pgOnce is a sync.Once variable used for lazy initialization of the pgInstance variable in the New function.
-	pgOnce sync.Once
-)
-
 // Postgres is a type representing a connection pool to a PostgreSQL database.
 // It contains a *pgxpool.Pool object for managing connections and a *logger.Logger object for logging.
+// Writes always go to connPool, the primary; read-only lookups are spread over the healthy replicas.
 type Postgres struct {
 	connPool *pgxpool.Pool
-	log      *logger.Logger
+	replicas []*replica
+	// next is the round-robin counter used to pick a replica.
+	next atomic.Uint64
+	log  *logger.Logger
+	// stopChecks stops the health checks of the replicas, checks waits for them to return.
+	stopChecks context.CancelFunc
+	checks     sync.WaitGroup
 }
 
+// replica is a connection pool to a read replica of the primary database.
+type replica struct {
+	connPool *pgxpool.Pool
+	// addr is the host and port of the replica, identifying it in the logs and metrics
+	// without exposing the credentials of the connection string.
+	addr    string
+	healthy atomic.Bool
+}
+
+// replicaPingTimeout bounds a single health check of a replica.
+const replicaPingTimeout = 2 * time.Second
+
 // Implementation omitted for brevity
 type Storage struct {
 	UserRepository    storage.UserRepository
 	ShortenRepository storage.ShortenRepository
 }
 
-// Close closes the storage by calling the Close method of the underlying ShortenRepository.
-// It returns an error if there is an error closing the repository.
+// Close closes the storage by calling the Close method of the underlying ShortenRepository
+// and of the UserRepository if it has one.
+// It returns an error if there is an error closing the repositories.
 func (s *Storage) Close() error {
-	return s.ShortenRepository.Close()
+	var userErr error
+	if closer, ok := s.UserRepository.(io.Closer); ok {
+		userErr = closer.Close()
+	}
+	return errors.Join(userErr, s.ShortenRepository.Close())
 }
 
 // NewStorage creates a new instance of Storage by accepting an implementation of UserRepository and ShortenRepository.
 // It initializes the UserRepository and ShortenRepository fields of the Storage struct with the provided instances.
 // It returns a pointer to the newly created Storage instance.
 // Example usage:
-// userRepo, err := storage.NewInMemUserStorage(log)
+// userRepo, err := storage.NewInMemUserStorage(cfg, log)
 //
 //	if err != nil {
 //	    log.Error("Failed to create user repository", zap.Error(err))
@@ -67,13 +90,162 @@ func NewStorage(userRepo storage.UserRepository, shortRepo storage.ShortenReposi
 	}
 }
 
-// Close closes the PostgreSQL connection pool by calling the Close method of the connPool.
+// Close closes the PostgreSQL connection pool by calling the Close method of the connPool,
+// along with the pools of the replicas, once the health checks of the replicas have stopped.
 // It does not return any error.
 func (pg *Postgres) Close() error {
+	if pg.stopChecks != nil {
+		pg.stopChecks()
+	}
+	pg.checks.Wait()
+	for _, r := range pg.replicas {
+		r.connPool.Close()
+	}
 	pg.connPool.Close()
 	return nil
 }
 
+// PoolStats returns the statistics of the primary pool, labeled "primary", and of the replica pools,
+// labeled by host and port, so several replicas on one host are told apart.
+func (pg *Postgres) PoolStats() map[string]*pgxpool.Stat {
+	stats := make(map[string]*pgxpool.Stat, len(pg.replicas)+1)
+	stats["primary"] = pg.connPool.Stat()
+	for _, r := range pg.replicas {
+		stats[r.addr] = r.connPool.Stat()
+	}
+	return stats
+}
+
+// reader returns the pool read-only queries should use: the next healthy replica in round-robin order,
+// or the primary when there are no healthy replicas.
+func (pg *Postgres) reader() *pgxpool.Pool {
+	healthy := uint64(0)
+	for _, r := range pg.replicas {
+		if r.healthy.Load() {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return pg.connPool
+	}
+	// count only the healthy replicas, so the load stays even when some of them are down
+	k := pg.next.Add(1) % healthy
+	for _, r := range pg.replicas {
+		if !r.healthy.Load() {
+			continue
+		}
+		if k == 0 {
+			return r.connPool
+		}
+		k--
+	}
+	// a replica became unhealthy in between
+	return pg.connPool
+}
+
+// read runs the read-only lookup on the pool returned by reader.
+// Replicas lag behind the primary, so a row written a moment ago may be missing there:
+// if the lookup on a replica finds no row, i.e. fails with pgx.ErrNoRows, it is run once more on the primary.
+// A replica may also go down between the health checks, so a lookup failing on a replica for any other reason
+// than its context being done is run on the primary too. A replica which can't be reached, i.e. which fails
+// without a PostgreSQL error, is marked unhealthy until the next health check finds it healthy again.
+func (pg *Postgres) read(lookup func(pool *pgxpool.Pool) error) error {
+	pool := pg.reader()
+	err := lookup(pool)
+	if err == nil || pool == pg.connPool || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var pgErr *pgconn.PgError
+	if !errors.Is(err, pgx.ErrNoRows) && !errors.As(err, &pgErr) {
+		pg.markUnhealthy(pool, err)
+	}
+	return lookup(pg.connPool)
+}
+
+// markUnhealthy marks the replica of the pool unhealthy after a read failed with err.
+func (pg *Postgres) markUnhealthy(pool *pgxpool.Pool, err error) {
+	for _, r := range pg.replicas {
+		if r.connPool == pool && r.healthy.Swap(false) {
+			pg.log.Log.Warn("replica failed a read, reads fall back to other replicas or the primary",
+				zap.String("replica", r.addr), zap.Error(err))
+		}
+	}
+}
+
+// checkReplicas pings every replica and marks it healthy or not.
+func (pg *Postgres) checkReplicas(ctx context.Context) {
+	for _, r := range pg.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, replicaPingTimeout)
+		err := r.connPool.Ping(pingCtx)
+		cancel()
+		healthy := err == nil
+		if r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				pg.log.Log.Info("replica is healthy", zap.String("replica", r.addr))
+			} else {
+				pg.log.Log.Warn("replica is unhealthy, reads fall back to other replicas or the primary",
+					zap.String("replica", r.addr), zap.Error(err))
+			}
+		}
+	}
+}
+
+// startReplicaHealthChecks runs runReplicaHealthChecks in the background until Close is called.
+// The checks keep the values of ctx, but not its cancellation, as they are stopped by Close.
+func (pg *Postgres) startReplicaHealthChecks(ctx context.Context, interval time.Duration) {
+	ctx, pg.stopChecks = context.WithCancel(context.WithoutCancel(ctx))
+	pg.checks.Add(1)
+	go func() {
+		defer pg.checks.Done()
+		pg.runReplicaHealthChecks(ctx, interval)
+	}()
+}
+
+// runReplicaHealthChecks calls checkReplicas every interval until the context is done.
+func (pg *Postgres) runReplicaHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pg.checkReplicas(ctx)
+		}
+	}
+}
+
+// newReplicas creates the pools of the replicas given by cfg.ReplicaConnStrings with the pool settings of cfg.
+// The pools connect lazily, so an unreachable replica doesn't prevent the start; it is just not healthy.
+func newReplicas(ctx context.Context, cfg *configuration.Storage) ([]*replica, error) {
+	replicas := make([]*replica, 0, len(cfg.ReplicaConnStrings))
+	for _, connString := range cfg.ReplicaConnStrings {
+		r, err := newReplica(ctx, cfg, connString)
+		if err != nil {
+			for _, r := range replicas {
+				r.connPool.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	return replicas, nil
+}
+
+// newReplica creates the pool of a single replica.
+func newReplica(ctx context.Context, cfg *configuration.Storage, connString string) (*replica, error) {
+	poolCfg, err := poolConfig(cfg, nil, connString)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(poolCfg.ConnConfig.Host, strconv.Itoa(int(poolCfg.ConnConfig.Port)))
+	return &replica{connPool: pool, addr: addr}, nil
+}
+
 // Ping pings the Postgres database by calling the Ping method of the connPool.
 // It takes a context.Context as an argument and returns an error if there is an error pinging the database.
 // Example usage:
@@ -84,18 +256,15 @@ func (pg *Postgres) Ping(ctx context.Context) error {
 }
 
 // New creates a new instance of Postgres by accepting a context, logger, and storage configuration.
-// It logs an informational message indicating the creation of the connection pool.
-// It uses sync.Once to ensure that the connection pool is created only once.
-// Inside the sync.Once's Do() function, it creates a new pgxpool.Pool with the provided context and connection string.
-// If there is an error during the creation of the connection pool, it logs the error and returns it.
-// After the connection pool is created, it assigns it to the pgInstance variable.
-// It then calls the StartMigration function to initialize the database schema using goose.
-// If there is an error during the migration, it returns the error.
-// Finally, it returns the pgInstance and nil error.
+// The pool is built by poolConfig from cfg.PGPoolCfg, or from cfg.ConnString if there is no pool configuration,
+// and the replicas get the same pool settings. Every call creates new pools, so several instances
+// with different databases can be used side by side, e.g. in tests.
+// Unless cfg.SkipMigrations is set, it then calls the StartMigration function to initialize the database schema using goose.
+// If the pool can't be created or the migration fails, the pools are closed and the error is returned.
 // Example usage:
 // ctx := context.Background()
 // log := &logger.Logger{Log: zap.NewNop()}
-// cfg := &configuration.Storage{ConnString: "postgres://localhost:5432/mydb"}
+// cfg := &configuration.Storage{ConnString: "postgres://localhost:5432/mydb", PGMaxConns: 20}
 // pg, err := New(ctx, log, cfg)
 //
 //	if err != nil {
@@ -104,34 +273,64 @@ func (pg *Postgres) Ping(ctx context.Context) error {
 //	}
 //
 // defer pg.Close()
-// err = pg.Ping(ctx)
-//
-//	if err != nil {
-//	    log.Log.Error("Failed to ping database", zap.Error(err))
-//	    return nil, err
-//	}
-//
-// // Perform database operations using the Postgres instance
-// ...
-// // Close the database connection pool when done
-// err = pg.Close()
-//
-//	if err != nil {
-//	    log.Log.Error("Failed to close database connection pool", zap.Error(err))
-//	    return err
-//	}
 func New(ctx context.Context, log *logger.Logger, cfg *configuration.Storage) (*Postgres, error) {
-	log.Log.Info("creating pool of conn to db...", zap.String("connString", cfg.ConnString))
-	pgOnce.Do(func() {
-		db, err := pgxpool.New(ctx, cfg.ConnString)
-		if err != nil {
-			log.Log.Error(err.Error())
-		}
-		pgInstance = &Postgres{db, log}
-	})
-	err := StartMigration(pgInstance.connPool)
+	poolCfg, err := poolConfig(cfg, cfg.PGPoolCfg, cfg.ConnString)
+	if err != nil {
+		return nil, err
+	}
+	log.Log.Info("creating pool of conn to db...",
+		zap.String("host", poolCfg.ConnConfig.Host),
+		zap.String("database", poolCfg.ConnConfig.Database),
+		zap.Int32("maxConns", poolCfg.MaxConns))
+	db, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, err
 	}
-	return pgInstance, nil
+	replicas, err := newReplicas(ctx, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	pg := &Postgres{connPool: db, replicas: replicas, log: log}
+	if !cfg.SkipMigrations {
+		if err := StartMigration(pg.connPool); err != nil {
+			pg.Close()
+			return nil, err
+		}
+	}
+	if len(replicas) > 0 {
+		pg.checkReplicas(ctx)
+		if cfg.ReplicaCheckInterval > 0 {
+			pg.startReplicaHealthChecks(ctx, cfg.ReplicaCheckInterval)
+		}
+	}
+	return pg, nil
+}
+
+// poolConfig returns the pool configuration built from base, or parsed from connString if base is nil,
+// with the pool settings of cfg applied. Zero settings keep the defaults of pgxpool.
+// The base configuration is copied, so it can be shared by several pools.
+func poolConfig(cfg *configuration.Storage, base *pgxpool.Config, connString string) (*pgxpool.Config, error) {
+	var poolCfg *pgxpool.Config
+	if base != nil {
+		poolCfg = base.Copy()
+	} else {
+		var err error
+		if poolCfg, err = pgxpool.ParseConfig(connString); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.PGMaxConns > 0 {
+		poolCfg.MaxConns = cfg.PGMaxConns
+	}
+	if cfg.PGMinConns > 0 {
+		poolCfg.MinConns = cfg.PGMinConns
+	}
+	if cfg.PGMaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.PGMaxConnLifetime
+	}
+	if cfg.PGStatementTimeout > 0 {
+		poolCfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.PGStatementTimeout.Milliseconds(), 10)
+	}
+	return poolCfg, nil
 }
@@ -1,7 +1,13 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"embed"
+	"fmt"
+	"strconv"
+
+	"github.com/lookeme/short-url/internal/configuration"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
@@ -37,12 +43,113 @@ var embedMigrations embed.FS
 // Otherwise, it returns nil.
 func StartMigration(pool *pgxpool.Pool) error {
 	db := stdlib.OpenDBFromPool(pool)
-	goose.SetBaseFS(embedMigrations)
-	if err := goose.SetDialect("postgres"); err != nil {
+	if err := postgresMigrations.setup(); err != nil {
+		return err
+	}
+	if err := goose.Up(db, postgresMigrations.dir); err != nil {
+		return err
+	}
+	return nil
+}
+
+// embedSQLiteMigrations holds the migrations of the SQLite storage.
+// They mirror the PostgreSQL migrations version by version in the SQLite dialect.
+//
+//go:embed migrations_sqlite/*.sql
+var embedSQLiteMigrations embed.FS
+
+// StartSQLiteMigration applies the SQLite migrations to the given database.
+func StartSQLiteMigration(db *sql.DB) error {
+	if err := sqliteMigrations.setup(); err != nil {
 		return err
 	}
-	if err := goose.Up(db, "migrations"); err != nil {
+	if err := goose.Up(db, sqliteMigrations.dir); err != nil {
 		return err
 	}
 	return nil
 }
+
+// migrationSet is the set of embedded migrations of a database dialect.
+type migrationSet struct {
+	fs      embed.FS
+	dir     string
+	dialect string
+}
+
+var (
+	postgresMigrations = migrationSet{fs: embedMigrations, dir: "migrations", dialect: "postgres"}
+	sqliteMigrations   = migrationSet{fs: embedSQLiteMigrations, dir: "migrations_sqlite", dialect: "sqlite3"}
+)
+
+// setup points goose to the migrations of the set.
+func (m migrationSet) setup() error {
+	goose.SetBaseFS(m.fs)
+	return goose.SetDialect(m.dialect)
+}
+
+// Migration commands accepted by RunMigrations.
+const (
+	// MigrateUp applies all pending migrations.
+	MigrateUp = "up"
+	// MigrateDown rolls back the latest applied migration.
+	MigrateDown = "down"
+	// MigrateStatus prints the applied and pending migrations.
+	MigrateStatus = "status"
+	// MigrateRedo rolls back the latest applied migration and applies it again.
+	MigrateRedo = "redo"
+	// MigrateTo applies or rolls back migrations until the version given as the argument is reached.
+	MigrateTo = "to"
+)
+
+// RunMigrations runs a migration command on the database of the given storage type,
+// configuration.StoragePostgres or configuration.StorageSQLite.
+// The MigrateTo command takes the target version as the only argument, the other commands take no arguments.
+func RunMigrations(ctx context.Context, db *sql.DB, storageType, command string, args ...string) error {
+	var set migrationSet
+	switch storageType {
+	case configuration.StoragePostgres:
+		set = postgresMigrations
+	case configuration.StorageSQLite:
+		set = sqliteMigrations
+	default:
+		return fmt.Errorf("storage type %q has no migrations", storageType)
+	}
+	if err := set.setup(); err != nil {
+		return err
+	}
+	switch command {
+	case MigrateUp, MigrateDown, MigrateStatus, MigrateRedo:
+		if len(args) > 0 {
+			return fmt.Errorf("%s takes no arguments", command)
+		}
+		return goose.RunContext(ctx, command, db, set.dir)
+	case MigrateTo:
+		if len(args) != 1 {
+			return fmt.Errorf("%s takes the target version", command)
+		}
+		target, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", args[0], err)
+		}
+		current, err := goose.GetDBVersionContext(ctx, db)
+		if err != nil {
+			return err
+		}
+		if target < current {
+			return goose.DownToContext(ctx, db, set.dir, target)
+		}
+		return goose.UpToContext(ctx, db, set.dir, target)
+	default:
+		return fmt.Errorf("unknown migration command %q", command)
+	}
+}
+
+// OpenDB opens a database/sql connection to the PostgreSQL database of cfg with its pool settings,
+// for the tools which need *sql.DB like the migrations.
+func OpenDB(cfg *configuration.Storage) (*sql.DB, error) {
+	poolCfg, err := poolConfig(cfg, cfg.PGPoolCfg, cfg.ConnString)
+	if err != nil {
+		return nil, err
+	}
+	return stdlib.OpenDB(*poolCfg.ConnConfig), nil
+}
@@ -10,6 +10,4 @@ CREATE TABLE short(
 );
 -- +goose StatementEnd
 -- +goose Down
--- +goose StatementBegin
-SELECT 'down SQL query';
--- +goose StatementEnd
+DROP TABLE short;
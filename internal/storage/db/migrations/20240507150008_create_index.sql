@@ -4,6 +4,4 @@ SELECT 'up SQL query';
 -- +goose StatementEnd
 CREATE UNIQUE INDEX original_url_unique_idx on short (original_url);
 -- +goose Down
--- +goose StatementBegin
-SELECT 'down SQL query';
--- +goose StatementEnd
+DROP INDEX original_url_unique_idx;
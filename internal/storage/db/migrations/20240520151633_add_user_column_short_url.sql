@@ -15,6 +15,5 @@ CREATE TABLE users
 );
 
 -- +goose Down
--- +goose StatementBegin
-SELECT 'down SQL query';
--- +goose StatementEnd
+DROP TABLE users;
+ALTER TABLE short DROP COLUMN user_id;
@@ -4,6 +4,4 @@ SELECT 'up SQL query';
 -- +goose StatementEnd
 ALTER TABLE short ADD COLUMN is_deleted BOOLEAN DEFAULT false;
 -- +goose Down
--- +goose StatementBegin
-SELECT 'down SQL query';
--- +goose StatementEnd
+ALTER TABLE short DROP COLUMN is_deleted;
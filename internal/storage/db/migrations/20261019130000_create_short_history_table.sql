@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE short_history(
+    id           SERIAL PRIMARY KEY,
+    short_id     INTEGER NOT NULL REFERENCES short (id) ON DELETE CASCADE,
+    original_url text    NOT NULL,
+    changed_by   INTEGER NOT NULL,
+    date_change  TIMESTAMP DEFAULT NOW()
+);
+-- +goose StatementEnd
+CREATE INDEX short_history_short_id_idx ON short_history (short_id);
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE short_history;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE short ADD COLUMN deleted_at TIMESTAMP;
+-- +goose StatementEnd
+UPDATE short SET deleted_at = NOW() WHERE is_deleted = true;
+CREATE INDEX short_deleted_at_idx ON short (deleted_at) WHERE is_deleted = true;
+-- +goose Down
+DROP INDEX short_deleted_at_idx;
+ALTER TABLE short DROP COLUMN deleted_at;
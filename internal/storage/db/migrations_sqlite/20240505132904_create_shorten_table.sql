@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE short(
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    correlation_id text NOT NULL DEFAULT (lower(hex(randomblob(16)))),
+    original_url   text,
+    short_url      text,
+    date_create    TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE short;
+-- +goose StatementEnd
@@ -0,0 +1,4 @@
+-- +goose Up
+CREATE UNIQUE INDEX original_url_unique_idx on short (original_url);
+-- +goose Down
+DROP INDEX original_url_unique_idx;
@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE short ADD user_id INTEGER DEFAULT 0;
+
+CREATE TABLE users
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        text NOT NULL,
+    pass        text NOT NULL,
+    date_create TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    is_active   BOOLEAN   DEFAULT true
+);
+
+-- +goose Down
+DROP TABLE users;
+ALTER TABLE short DROP COLUMN user_id;
@@ -0,0 +1,4 @@
+-- +goose Up
+ALTER TABLE short ADD COLUMN is_deleted BOOLEAN DEFAULT false;
+-- +goose Down
+ALTER TABLE short DROP COLUMN is_deleted;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE short_tags(
+    short_id INTEGER NOT NULL REFERENCES short (id) ON DELETE CASCADE,
+    tag      text    NOT NULL,
+    PRIMARY KEY (short_id, tag)
+);
+-- +goose StatementEnd
+CREATE INDEX short_tags_tag_idx ON short_tags (tag);
+-- +goose Down
+DROP TABLE short_tags;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE short_history(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    short_id     INTEGER NOT NULL REFERENCES short (id) ON DELETE CASCADE,
+    original_url text    NOT NULL,
+    changed_by   INTEGER NOT NULL,
+    date_change  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+CREATE INDEX short_history_short_id_idx ON short_history (short_id);
+-- +goose Down
+DROP TABLE short_history;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- deleted_at keeps unix time in nanoseconds, so that it compares correctly as a number
+ALTER TABLE short ADD COLUMN deleted_at INTEGER;
+UPDATE short SET deleted_at = CAST(strftime('%s', 'now') AS INTEGER) * 1000000000 WHERE is_deleted = true;
+CREATE INDEX short_deleted_at_idx ON short (deleted_at) WHERE is_deleted = true;
+-- +goose Down
+DROP INDEX short_deleted_at_idx;
+ALTER TABLE short DROP COLUMN deleted_at;
@@ -2,9 +2,16 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
 	"go.uber.org/zap"
 )
 
@@ -21,37 +28,36 @@ func NewShortenRepository(postgres *Postgres) *ShortenRepository {
 }
 
 // Save inserts a new record into the "short" table with the given original URL, short URL, and user ID.
-// It returns an error if the insertion fails.
-func (r *ShortenRepository) Save(key, value string, userID int) error {
+// It returns a *storage.ConflictError if the original URL is already shortened,
+// or another error if the insertion fails.
+func (r *ShortenRepository) Save(ctx context.Context, key, value string, userID int) error {
 	query := `INSERT INTO short (original_url, short_url, user_id) VALUES (@originalURL, @shortURL, @userID)`
 	args := pgx.NamedArgs{
 		"originalURL": value,
 		"shortURL":    key,
 		"userID":      userID,
 	}
-	_, err := r.postgres.connPool.Exec(context.Background(), query, args)
+	_, err := r.postgres.connPool.Exec(ctx, query, args)
 	if err != nil {
-		return err
+		return r.conflict(ctx, err, models.ShortenData{OriginalURL: value})
 	}
 	return nil
 }
 
 // FindByURL searches for a record in the "short" table based on the original URL.
 // It returns the matching record and a flag indicating whether the record was found.
-func (r *ShortenRepository) FindByURL(key string) (models.ShortenData, bool) {
+// A record missing on a replica is looked up on the primary, see Postgres.read.
+func (r *ShortenRepository) FindByURL(ctx context.Context, key string) (models.ShortenData, bool) {
 	query := `SELECT id, correlation_id, short_url, original_url, user_id, is_deleted FROM short WHERE original_url = @originalURL AND is_deleted = false`
 	args := pgx.NamedArgs{
 		"originalURL": key,
 	}
-	var data models.ShortenData
-	row, err := r.postgres.connPool.Query(context.Background(), query, args)
-	if err != nil {
-		return data, false
-	}
-	data, err = pgx.CollectOneRow(row, pgx.RowToStructByPos[models.ShortenData])
+	data, err := r.findOne(ctx, query, args)
 	if err != nil {
-		r.postgres.log.Log.Error(err.Error(), zap.String("during fetching by url", key))
-		return data, false
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.postgres.log.Ctx(ctx).Error(err.Error(), zap.String("during fetching by url", key))
+		}
+		return models.ShortenData{}, false
 	}
 	return data, true
 }
@@ -59,12 +65,12 @@ func (r *ShortenRepository) FindByURL(key string) (models.ShortenData, bool) {
 // FindByURLs retrieves a list of ShortenData objects from the database by matching the original URLs with the given keys.
 // It executes a SELECT query on the 'short' table.
 // It returns an
-func (r *ShortenRepository) FindByURLs(keys []string) ([]models.ShortenData, error) {
+func (r *ShortenRepository) FindByURLs(ctx context.Context, keys []string) ([]models.ShortenData, error) {
 	query := `SELECT id, short_url, original_url, correlation_id, user_id, is_deleted FROM short WHERE original_url = ANY (@originalURL) AND is_deleted = false`
 	args := pgx.NamedArgs{
 		"originalURL": keys,
 	}
-	rows, err := r.postgres.connPool.Query(context.Background(), query, args)
+	rows, err := r.postgres.connPool.Query(ctx, query, args)
 	if err != nil {
 		return nil, err
 	}
@@ -77,23 +83,35 @@ func (r *ShortenRepository) FindByURLs(keys []string) ([]models.ShortenData, err
 }
 
 // FindByKey searches for a ShortenData object in the database based on a given short URL key.
-// It returns the found ShortenData object and a boolean value indicating whether the data
-func (r *ShortenRepository) FindByKey(key string) (models.ShortenData, bool) {
+// It returns storage.ErrNotFound if there is no such record, neither on a replica nor on the primary.
+func (r *ShortenRepository) FindByKey(ctx context.Context, key string) (models.ShortenData, error) {
 	query := `SELECT id, correlation_id, short_url, original_url, user_id, is_deleted FROM short WHERE short_url = @shortURL`
 	args := pgx.NamedArgs{
 		"shortURL": key,
 	}
-	row, err := r.postgres.connPool.Query(context.Background(), query, args)
-	if err != nil {
-		r.postgres.log.Log.Error(err.Error(), zap.String("during fetching by short key", key))
-		return models.ShortenData{}, false
+	data, err := r.findOne(ctx, query, args)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ShortenData{}, storage.ErrNotFound
 	}
-	data, err := pgx.CollectOneRow(row, pgx.RowToStructByPos[models.ShortenData])
 	if err != nil {
-		r.postgres.log.Log.Error(err.Error(), zap.String("during fetching by short key", key))
-		return models.ShortenData{}, false
+		return models.ShortenData{}, err
 	}
-	return data, true
+	return data, nil
+}
+
+// findOne reads the single record selected by the query, see Postgres.read.
+// It returns pgx.ErrNoRows if there is no such record.
+func (r *ShortenRepository) findOne(ctx context.Context, query string, args pgx.NamedArgs) (models.ShortenData, error) {
+	var data models.ShortenData
+	err := r.postgres.read(func(pool *pgxpool.Pool) error {
+		rows, err := pool.Query(ctx, query, args)
+		if err != nil {
+			return err
+		}
+		data, err = pgx.CollectOneRow(rows, pgx.RowToStructByPos[models.ShortenData])
+		return err
+	})
+	return data, err
 }
 
 // FindAll retrieves all shorten data from the database that are not deleted, ordered by date created in descending order.
@@ -108,9 +126,9 @@ func (r *ShortenRepository) FindByKey(key string) (models.ShortenData, bool) {
 //
 //	for _, shorten := range shortens {
 //	    fmt.Println(shorten)
-func (r *ShortenRepository) FindAll() ([]models.ShortenData, error) {
-	query := `SELECT id, short_url, original_url, correlation_id, user_id, is_deleted FROM short WHERE is_deleted = false ORDER BY date_create DESC`
-	rows, err := r.postgres.connPool.Query(context.Background(), query)
+func (r *ShortenRepository) FindAll(ctx context.Context) ([]models.ShortenData, error) {
+	query := `SELECT id, short_url, original_url, correlation_id, user_id, is_deleted FROM short WHERE is_deleted = false ORDER BY date_create DESC, id DESC`
+	rows, err := r.postgres.reader().Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -121,40 +139,184 @@ func (r *ShortenRepository) FindAll() ([]models.ShortenData, error) {
 	return result, nil
 }
 
-// SaveAll saves multiple rows of ShortenData to the 'short' table in the database.
+// SaveAll saves multiple rows of ShortenData along with their tags to the 'short' table within a single transaction.
 // If the rows parameter is empty, the method returns nil immediately.
-// First, it acquires a connection from the connection pool.
-// Then, it uses the Acquired connection to execute the CopyFrom method, which performs a bulk insert operation.
-// The CopyFrom method copies the provided rows into the 'short' table.
-// The CopyFromSlice method is used as a callback to convert each ShortenData struct into the required format for the CopyFrom
-func (r *ShortenRepository) SaveAll(rows []models.ShortenData) error {
+// The rows and their tags are inserted with a single batch, a row without a correlation ID gets a generated one.
+// If any of the rows can't be saved, none of them is.
+// It returns a *storage.ConflictError if any of the original URLs is already shortened or repeated within the batch.
+func (r *ShortenRepository) SaveAll(ctx context.Context, rows []models.ShortenData) error {
 	if len(rows) == 0 {
 		return nil
 	}
-	conn, err := r.postgres.connPool.Acquire(context.Background())
-	defer conn.Release()
+	tx, err := r.postgres.connPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	batch := &pgx.Batch{}
+	for _, row := range rows {
+		batch.Queue(insertQuery, pgx.NamedArgs{
+			"correlationID": row.CorrelationID,
+			"shortURL":      row.ShortURL,
+			"originalURL":   row.OriginalURL,
+			"userID":        row.UserID,
+		})
+		if len(row.Tags) > 0 {
+			batch.Queue(insertTagsQuery, pgx.NamedArgs{"shortURL": row.ShortURL, "tags": row.Tags})
+		}
+	}
+	if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+		return r.conflict(ctx, err, rows...)
+	}
+	return tx.Commit(ctx)
+}
+
+// insertQuery inserts a row into the "short" table; the correlation ID is generated when it is empty.
+const insertQuery = `INSERT INTO short (correlation_id, short_url, original_url, user_id) VALUES (COALESCE(NULLIF(@correlationID, '')::uuid, gen_random_uuid()), @shortURL, @originalURL, @userID)`
+
+// insertTagsQuery attaches the given tags to the row of the "short" table with the given short URL.
+const insertTagsQuery = `INSERT INTO short_tags (short_id, tag) SELECT id, unnest(@tags::text[]) FROM short WHERE short_url = @shortURL ON CONFLICT DO NOTHING`
+
+// SaveTags replaces the tags attached to the record with the given short URL within a single transaction.
+// It returns storage.ErrNotFound if there is no such record.
+func (r *ShortenRepository) SaveTags(ctx context.Context, shortURL string, tags []string) error {
+	tx, err := r.postgres.connPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var id int64
+	err = tx.QueryRow(ctx, `SELECT id FROM short WHERE short_url = $1`, shortURL).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM short_tags WHERE short_id = $1`, id); err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		args := pgx.NamedArgs{"shortURL": shortURL, "tags": tags}
+		if _, err = tx.Exec(ctx, insertTagsQuery, args); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Update replaces the original URL of the record with the given short URL within a single transaction.
+// The previous destination and the ID of the user who changed it are stored in the "short_history" table.
+// It returns storage.ErrNotFound if there is no such record and a *storage.ConflictError
+// if the new original URL is already shortened.
+func (r *ShortenRepository) Update(ctx context.Context, shortURL, originalURL string, userID int) error {
+	tx, err := r.postgres.connPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var (
+		id          int64
+		previousURL string
+	)
+	err = tx.QueryRow(ctx, `SELECT id, original_url FROM short WHERE short_url = $1 FOR UPDATE`, shortURL).Scan(&id, &previousURL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if previousURL == originalURL {
+		return nil
+	}
+	query := `INSERT INTO short_history (short_id, original_url, changed_by) VALUES (@shortID, @originalURL, @userID)`
+	args := pgx.NamedArgs{
+		"shortID":     id,
+		"originalURL": previousURL,
+		"userID":      userID,
+	}
+	if _, err = tx.Exec(ctx, query, args); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE short SET original_url = $1 WHERE id = $2`, originalURL, id); err != nil {
+		return r.conflict(ctx, err, models.ShortenData{OriginalURL: originalURL})
+	}
+	return tx.Commit(ctx)
+}
+
+// FindAllByUserIDAndTag retrieves all not deleted shorten data of the given user marked with the tag.
+// The tags of every returned record are loaded as well.
+func (r *ShortenRepository) FindAllByUserIDAndTag(ctx context.Context, userID int, tag string) ([]models.ShortenData, error) {
+	query := `SELECT s.id, s.short_url, s.original_url, s.correlation_id, s.user_id, s.is_deleted FROM short s JOIN short_tags t ON t.short_id = s.id WHERE s.user_id = @userID AND t.tag = @tag AND s.is_deleted = false ORDER BY s.date_create DESC, s.id DESC`
+	args := pgx.NamedArgs{
+		"userID": userID,
+		"tag":    tag,
+	}
+	rows, err := r.postgres.connPool.Query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	result, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.ShortenData])
+	if err != nil {
+		return nil, err
+	}
+	return result, r.attachTags(ctx, r.postgres.connPool, result)
+}
+
+// CountTagsByUserID returns every tag used by the given user along with the number of not deleted records marked with it.
+func (r *ShortenRepository) CountTagsByUserID(ctx context.Context, userID int) ([]models.TagCount, error) {
+	query := `SELECT t.tag, COUNT(*) AS count FROM short_tags t JOIN short s ON s.id = t.short_id WHERE s.user_id = @userID AND s.is_deleted = false GROUP BY t.tag ORDER BY count DESC, t.tag`
+	args := pgx.NamedArgs{
+		"userID": userID,
+	}
+	rows, err := r.postgres.connPool.Query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[models.TagCount])
+}
+
+// attachTags loads the tags of the given records from the "short_tags" table using the given pool and sets them in place.
+// The pool must be the one the records were read from, so a lagging replica doesn't miss the tags of its own rows.
+func (r *ShortenRepository) attachTags(ctx context.Context, pool *pgxpool.Pool, data []models.ShortenData) error {
+	if len(data) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(data))
+	for _, d := range data {
+		ids = append(ids, d.ID)
+	}
+	rows, err := pool.Query(ctx, `SELECT short_id, tag FROM short_tags WHERE short_id = ANY ($1) ORDER BY tag`, ids)
 	if err != nil {
 		return err
 	}
-	_, err = conn.CopyFrom(
-		context.Background(),
-		pgx.Identifier{"short"},
-		[]string{"correlation_id", "short_url", "original_url"},
-		pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
-			return []any{rows[i].CorrelationID, rows[i].ShortURL, rows[i].OriginalURL}, nil
-		}),
+	tags := make(map[int64][]string)
+	var (
+		id  int64
+		tag string
 	)
-	return err
+	_, err = pgx.ForEachRow(rows, []any{&id, &tag}, func() error {
+		tags[id] = append(tags[id], tag)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := range data {
+		data[i].Tags = tags[data[i].ID]
+	}
+	return nil
 }
 
 // FindAllByUserID retrieves all shorten data for a given userID that have not been deleted.
 // It returns a slice of models.ShortenData and an error, if any.
-func (r *ShortenRepository) FindAllByUserID(userID int) ([]models.ShortenData, error) {
-	query := `SELECT id, short_url, original_url, correlation_id, user_id, is_deleted FROM short WHERE user_id = (@userID) AND short.is_deleted = false ORDER BY date_create DESC`
+func (r *ShortenRepository) FindAllByUserID(ctx context.Context, userID int) ([]models.ShortenData, error) {
+	query := `SELECT id, short_url, original_url, correlation_id, user_id, is_deleted FROM short WHERE user_id = (@userID) AND short.is_deleted = false ORDER BY date_create DESC, id DESC`
 	args := pgx.NamedArgs{
 		"userID": userID,
 	}
-	rows, err := r.postgres.connPool.Query(context.Background(), query, args)
+	pool := r.postgres.reader()
+	rows, err := pool.Query(ctx, query, args)
 	if err != nil {
 		return nil, err
 	}
@@ -162,19 +324,83 @@ func (r *ShortenRepository) FindAllByUserID(userID int) ([]models.ShortenData, e
 	if err != nil {
 		return nil, err
 	}
-	return result, nil
+	return result, r.attachTags(ctx, pool, result)
 }
 
-// DeleteByShortURL deletes a record from the "short" table in the database based on the short URL.
-func (r *ShortenRepository) DeleteByShortURL(shortURL string) bool {
-	var err error
-	sqlStatement := `UPDATE short SET is_deleted = true WHERE short_url = $1`
-	_, err = r.postgres.connPool.Exec(context.Background(), sqlStatement, shortURL)
-	return err == nil
+// DeleteByShortURL marks a record of the "short" table as deleted based on the short URL and the owner.
+// Deleting an already deleted record keeps its original moment of deletion.
+// It returns false if the user has no such record.
+func (r *ShortenRepository) DeleteByShortURL(ctx context.Context, shortURL string, userID int) bool {
+	sqlStatement := `UPDATE short SET is_deleted = true, deleted_at = COALESCE(deleted_at, NOW()) WHERE short_url = $1 AND user_id = $2`
+	tag, err := r.postgres.connPool.Exec(ctx, sqlStatement, shortURL, userID)
+	if err != nil {
+		r.postgres.log.Ctx(ctx).Error(err.Error(), zap.String("during deleting short url", shortURL))
+		return false
+	}
+	return tag.RowsAffected() == 1
 }
 
-// Close closes the connection pool of the ShortenRepository's Postgres instance.
+// RestoreByShortURL restores a record of the user deleted after the given moment.
+// It returns true if the record was restored.
+func (r *ShortenRepository) RestoreByShortURL(ctx context.Context, shortURL string, userID int, deletedAfter time.Time) (bool, error) {
+	sqlStatement := `UPDATE short SET is_deleted = false, deleted_at = NULL WHERE short_url = $1 AND user_id = $2 AND is_deleted = true AND deleted_at >= $3`
+	tag, err := r.postgres.connPool.Exec(ctx, sqlStatement, shortURL, userID, deletedAfter)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// PurgeDeleted permanently removes the records deleted before the given moment along with their tags and history.
+// It returns the number of removed records.
+func (r *ShortenRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	sqlStatement := `DELETE FROM short WHERE is_deleted = true AND deleted_at < $1`
+	tag, err := r.postgres.connPool.Exec(ctx, sqlStatement, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// Ping checks that a connection of the primary pool can reach the database, see Postgres.Ping.
+func (r *ShortenRepository) Ping(ctx context.Context) error {
+	return r.postgres.Ping(ctx)
+}
+
+// Close closes the connection pools of the ShortenRepository's Postgres instance, the replicas and the primary.
 func (r *ShortenRepository) Close() error {
-	r.postgres.connPool.Close()
-	return nil
+	return r.postgres.Close()
+}
+
+// conflict turns a violation of the unique original URL index into a *storage.ConflictError carrying the record
+// one of the original URLs of rows is stored with, or the earlier row repeating it. Other errors are returned as they are.
+// The record is read outside of the failed statement's transaction, which can't run queries anymore.
+func (r *ShortenRepository) conflict(ctx context.Context, err error, rows ...models.ShortenData) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+		return err
+	}
+	urls := make([]string, 0, len(rows))
+	for _, row := range rows {
+		urls = append(urls, row.OriginalURL)
+	}
+	query := `SELECT id, correlation_id, short_url, original_url, user_id, is_deleted FROM short WHERE original_url = ANY(@urls) LIMIT 1`
+	res, queryErr := r.postgres.connPool.Query(ctx, query, pgx.NamedArgs{"urls": urls})
+	if queryErr == nil {
+		var existing models.ShortenData
+		existing, queryErr = pgx.CollectOneRow(res, pgx.RowToStructByPos[models.ShortenData])
+		if queryErr == nil {
+			return &storage.ConflictError{Existing: existing}
+		}
+	}
+	if errors.Is(queryErr, pgx.ErrNoRows) {
+		seen := make(map[string]models.ShortenData, len(rows))
+		for _, row := range rows {
+			if existing, ok := seen[row.OriginalURL]; ok {
+				return &storage.ConflictError{Existing: existing}
+			}
+			seen[row.OriginalURL] = row
+		}
+	}
+	return fmt.Errorf("%w: %w", storage.ErrConflict, err)
 }
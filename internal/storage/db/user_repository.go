@@ -2,6 +2,9 @@ package db
 
 import (
 	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
 
 	"github.com/lookeme/short-url/internal/models"
 )
@@ -26,10 +29,10 @@ func NewUserRepository(postgres *Postgres) *UserRepository {
 // The values of the name and pass arguments are used as the parameters for the INSERT statement.
 // If the insertion is successful, the last inserted ID is scanned into the lastInsertID variable.
 // If there is an error during the insertion, the function returns the lastInsertID and the error.
-func (u *UserRepository) SaveUser(name, pass string) (int, error) {
+func (u *UserRepository) SaveUser(ctx context.Context, name, pass string) (int, error) {
 	lastInsertID := 0
 	err := u.postgres.connPool.QueryRow(
-		context.Background(),
+		ctx,
 		"INSERT INTO users(name, pass) VALUES($1, $2) RETURNING id",
 		name, pass).Scan(&lastInsertID)
 	if err != nil {
@@ -39,12 +42,18 @@ func (u *UserRepository) SaveUser(name, pass string) (int, error) {
 }
 
 // FindByID finds a user in the database by their userID.
-// It takes an integer argument userID, which represents the ID of the user to find.
-// The function returns a models.User object and an error.
-// It currently returns an empty models.User object and nil error.
-// To retrieve the user from the database, the function executes a query with the given userID and scans the result into a models.User object.
-// If no user is found with the given userID, the function returns an empty models.User object.
-// In case of any error during the query execution, the function returns the empty models.User object and the error.
-func (u *UserRepository) FindByID(userID int) (models.User, error) {
-	return models.User{}, nil
+// It returns an error if the user doesn't exist.
+func (u *UserRepository) FindByID(ctx context.Context, userID int) (models.User, error) {
+	user := models.User{UserID: userID}
+	err := u.postgres.connPool.QueryRow(
+		ctx,
+		"SELECT name, pass, is_active FROM users WHERE id = $1",
+		userID).Scan(&user.Name, &user.Pass, &user.IsActive)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.User{}, errors.New("user doesn't exist")
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
 }
@@ -1,53 +1,48 @@
 package inmemory
 
 import (
-	"bufio"
-	"encoding/json"
-	"errors"
-	"os"
+	"context"
+	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/lookeme/short-url/internal/configuration"
 	"github.com/lookeme/short-url/internal/logger"
 	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
 )
 
 // InMemShortenStorage is an in-memory implementation of a storage for shortened URLs.
 type InMemShortenStorage struct {
 	urlToKey map[string]models.ShortenData
 	keyToURL map[string]models.ShortenData
-	id       int64
-	mutex    sync.RWMutex
-	file     *os.File
-	writer   *bufio.Writer
-	log      *logger.Logger
-}
-
-// InMemUserStorage is an in-memory implementation of a storage for user data.
-// The userMap field is a map that stores users by their ID.
-// The key is the user's ID (integer), and the value is a User object.
-type InMemUserStorage struct {
-	userMap map[int]models.User
-	id      int
+	// tagToKeys indexes short URLs by the tags attached to them.
+	tagToKeys map[string]map[string]struct{}
+	// history keeps the previous destinations of every updated short URL.
+	history map[string][]models.URLHistory
+	id      int64
 	mutex   sync.RWMutex
-	log     *logger.Logger
+	// wal is the write-ahead log every change is appended to.
+	wal *logFile
+	log *logger.Logger
 }
 
-// NewInMemUserStorage creates a new instance of InMemUserStorage with the
-func NewInMemUserStorage(logger *logger.Logger) (*InMemUserStorage, error) {
-	return &InMemUserStorage{
-		userMap: make(map[int]models.User),
-		id:      0,
-		log:     logger,
-	}, nil
-}
-
-// FindAllByUserID retrieves all ShortenData objects associated with a given userID.
-// It returns an empty slice of ShortenData objects and a nil error.
-func (s *InMemShortenStorage) FindAllByUserID(userID int) ([]models.ShortenData, error) {
-	return []models.ShortenData{}, nil
+// FindAllByUserID retrieves the not deleted ShortenData objects associated with a given userID.
+// The result is ordered from the newest to the oldest entry.
+func (s *InMemShortenStorage) FindAllByUserID(_ context.Context, userID int) ([]models.ShortenData, error) {
+	defer s.mutex.RUnlock()
+	s.mutex.RLock()
+	var result []models.ShortenData
+	for _, val := range s.keyToURL {
+		if val.UserID == userID && !val.DeletedFlag {
+			result = append(result, val)
+		}
+	}
+	sortNewestFirst(result)
+	return result, nil
 }
 
 // NewInMemShortenStorage creates a new instance of InMemShortenStorage with the given configuration and logger.
@@ -62,181 +57,474 @@ func (s *InMemShortenStorage) FindAllByUserID(userID int) ([]models.ShortenData,
 //	   PGPoolCfg:       &pgxpool
 func NewInMemShortenStorage(cfg *configuration.Storage, logger *logger.Logger) (*InMemShortenStorage, error) {
 	logger.Log.Info("Creating local storage")
-	file, err := os.OpenFile(cfg.FileStoragePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	wal, err := openLogFile(cfg.FileStoragePath, 0666)
 	if err != nil {
 		return nil, err
 	}
 	return &InMemShortenStorage{
-		urlToKey: make(map[string]models.ShortenData),
-		keyToURL: make(map[string]models.ShortenData),
-		file:     file,
-		writer:   bufio.NewWriter(file),
-		log:      logger,
-		id:       0,
+		urlToKey:  make(map[string]models.ShortenData),
+		keyToURL:  make(map[string]models.ShortenData),
+		tagToKeys: make(map[string]map[string]struct{}),
+		history:   make(map[string][]models.URLHistory),
+		wal:       wal,
+		log:       logger,
+		id:        0,
 	}, nil
 }
 
 // Save method saves a new ShortenData object to the in-memory storage, as well as writes it to a file.
 // It takes the key, value, and userID as parameters.
 // The key is the shortened URL, the value is the original URL, and the userID is the ID of the user who created the shorten URL.
-// It first acquires
-func (s *InMemShortenStorage) Save(key, value string, userID int) error {
+// The create record is appended to the write-ahead log before the object becomes visible.
+// It returns a *storage.ConflictError if the original URL is already shortened.
+func (s *InMemShortenStorage) Save(_ context.Context, key, value string, userID int) error {
 	defer s.mutex.Unlock()
 	s.mutex.Lock()
-	s.id += 1
-	data := models.NewShortenData(s.id, value, key, userID)
-	s.urlToKey[value] = *data
-	s.keyToURL[key] = *data
-	if err := s.writeToFile(data); err != nil {
+	if existing, ok := s.urlToKey[value]; ok {
+		return &storage.ConflictError{Existing: existing}
+	}
+	data := models.NewShortenData(s.id+1, value, key, userID)
+	if err := s.writeRecord(recordCreate, *data); err != nil {
 		return err
 	}
+	s.id = data.ID
+	s.put(*data)
 	return nil
 }
 
 // SaveAll saves multiple ShortenData objects to the in-memory storage.
 // It appends the objects to the existing data and assigns each object a unique ID.
 // It also updates the keyToURL and urlToKey maps.
+// If any of the original URLs is already shortened or repeated within the batch,
+// nothing is stored and a *storage.ConflictError is returned.
 // If writing to the file fails for any object, it returns an error.
 // It returns nil if the operation is successful.
-func (s *InMemShortenStorage) SaveAll(data []models.ShortenData) error {
+func (s *InMemShortenStorage) SaveAll(_ context.Context, data []models.ShortenData) error {
 	defer s.mutex.Unlock()
 	s.mutex.Lock()
+	seen := make(map[string]models.ShortenData, len(data))
+	for _, shorten := range data {
+		if existing, ok := s.urlToKey[shorten.OriginalURL]; ok {
+			return &storage.ConflictError{Existing: existing}
+		}
+		if existing, ok := seen[shorten.OriginalURL]; ok {
+			return &storage.ConflictError{Existing: existing}
+		}
+		seen[shorten.OriginalURL] = shorten
+	}
 	for _, shorten := range data {
-		s.id += 1
-		shorten.ID = s.id
-		s.urlToKey[shorten.OriginalURL] = shorten
-		s.keyToURL[shorten.ShortURL] = shorten
-		if err := s.writeToFile(&shorten); err != nil {
+		shorten.ID = s.id + 1
+		if err := s.writeRecord(recordCreate, shorten); err != nil {
 			return err
 		}
+		s.id = shorten.ID
+		s.put(shorten)
 	}
 	return nil
 }
 
-// FindByURLs retrieves a slice of ShortenData objects associated with the given URLs.
-// It searches the urlToKey map for each URL in the provided `keys
-func (s *InMemShortenStorage) FindByURLs(keys []string) ([]models.ShortenData, error) {
+// FindByURLs retrieves a slice of not deleted ShortenData objects associated with the given URLs.
+// It searches the urlToKey map for each URL in the provided keys.
+func (s *InMemShortenStorage) FindByURLs(_ context.Context, keys []string) ([]models.ShortenData, error) {
 	defer s.mutex.RUnlock()
 	var result []models.ShortenData
 	s.mutex.RLock()
 	for _, key := range keys {
 		value, ok := s.urlToKey[key]
-		if ok {
+		if ok && !value.DeletedFlag {
 			result = append(result, value)
 		}
 	}
 	return result, nil
 }
 
-// FindByURL retrieves the ShortenData object associated with the given URL key.
+// FindByURL retrieves the not deleted ShortenData object associated with the given URL key.
 // It returns the ShortenData object and a boolean value indicating whether the key was found.
-func (s *InMemShortenStorage) FindByURL(key string) (models.ShortenData, bool) {
+func (s *InMemShortenStorage) FindByURL(_ context.Context, key string) (models.ShortenData, bool) {
 	defer s.mutex.RUnlock()
 	s.mutex.RLock()
 	value, ok := s.urlToKey[key]
-	return value, ok
+	if !ok || value.DeletedFlag {
+		return models.ShortenData{}, false
+	}
+	return value, true
 }
 
 // FindByKey retrieves the ShortenData object associated with a given key.
-// It returns the ShortenData object and a boolean indicating whether the object was found or not.
-func (s *InMemShortenStorage) FindByKey(key string) (models.ShortenData, bool) {
+// It returns storage.ErrNotFound if there is no such object.
+func (s *InMemShortenStorage) FindByKey(_ context.Context, key string) (models.ShortenData, error) {
 	defer s.mutex.RUnlock()
 	s.mutex.RLock()
 	value, ok := s.keyToURL[key]
-	return value, ok
+	if !ok {
+		return models.ShortenData{}, storage.ErrNotFound
+	}
+	return value, nil
 }
 
-// FindAll retrieves all ShortenData objects from the InMemShortenStorage.
-// It iterates over the keyToURL map to collect all shorten data and returns them.
+// FindAll retrieves all not deleted ShortenData objects from the InMemShortenStorage.
+// It iterates over the keyToURL map to collect all shorten data and returns them ordered from the newest to the oldest.
 // It returns the result slice of ShortenData objects and a nil error.
-func (s *InMemShortenStorage) FindAll() ([]models.ShortenData, error) {
+func (s *InMemShortenStorage) FindAll(_ context.Context) ([]models.ShortenData, error) {
+	defer s.mutex.RUnlock()
 	var result []models.ShortenData
 	s.mutex.RLock()
 	for _, shorten := range s.keyToURL {
-		result = append(result, shorten)
+		if !shorten.DeletedFlag {
+			result = append(result, shorten)
+		}
 	}
+	sortNewestFirst(result)
 	return result, nil
 }
 
-// writeToFile writes the given ShortenData to a file.
-// It first marshals the shortenData object into JSON format in
-func (s *InMemShortenStorage) writeToFile(shortenData *models.ShortenData) error {
-	b, err := json.Marshal(&shortenData)
-	if err != nil {
+// SaveTags replaces the tags attached to the ShortenData with the given short URL.
+// It keeps the tag index in sync and returns storage.ErrNotFound if the short URL is unknown.
+func (s *InMemShortenStorage) SaveTags(_ context.Context, shortURL string, tags []string) error {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	val, ok := s.keyToURL[shortURL]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	val.Tags = tags
+	if err := s.writeRecord(recordUpdate, val); err != nil {
+		return err
+	}
+	s.put(val)
+	return nil
+}
+
+// Update replaces the original URL of the ShortenData with the given short URL.
+// The previous destination is kept in the history along with the ID of the user who changed it.
+// It returns storage.ErrNotFound if the short URL is unknown and a *storage.ConflictError
+// if the new original URL is already shortened.
+func (s *InMemShortenStorage) Update(_ context.Context, shortURL, originalURL string, userID int) error {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	val, ok := s.keyToURL[shortURL]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if val.OriginalURL == originalURL {
+		return nil
+	}
+	if existing, ok := s.urlToKey[originalURL]; ok {
+		return &storage.ConflictError{Existing: existing}
+	}
+	previous := models.URLHistory{
+		ShortURL:    shortURL,
+		OriginalURL: val.OriginalURL,
+		ChangedBy:   userID,
+		ChangedAt:   time.Now(),
+	}
+	val.OriginalURL = originalURL
+	rec := newRecord(recordUpdate, val)
+	rec.History = []models.URLHistory{previous}
+	if err := s.wal.append(rec); err != nil {
 		return err
 	}
-	b = append(b, '\n')
-	_, err = s.writer.Write(b)
+	s.history[shortURL] = append(s.history[shortURL], previous)
+	s.put(val)
+	return nil
+}
+
+// FindAllByUserIDAndTag retrieves the not deleted ShortenData objects of the given user marked with the tag.
+// The result is ordered from the newest to the oldest entry.
+func (s *InMemShortenStorage) FindAllByUserIDAndTag(_ context.Context, userID int, tag string) ([]models.ShortenData, error) {
+	defer s.mutex.RUnlock()
+	s.mutex.RLock()
+	var result []models.ShortenData
+	for key := range s.tagToKeys[tag] {
+		val := s.keyToURL[key]
+		if val.UserID == userID && !val.DeletedFlag {
+			result = append(result, val)
+		}
+	}
+	sortNewestFirst(result)
+	return result, nil
+}
+
+// CountTagsByUserID returns every tag used by the given user along with the number of not deleted URLs marked with it.
+// The result is ordered by count in descending order and then by tag.
+func (s *InMemShortenStorage) CountTagsByUserID(_ context.Context, userID int) ([]models.TagCount, error) {
+	defer s.mutex.RUnlock()
+	s.mutex.RLock()
+	var result []models.TagCount
+	for tag, keys := range s.tagToKeys {
+		count := 0
+		for key := range keys {
+			val := s.keyToURL[key]
+			if val.UserID == userID && !val.DeletedFlag {
+				count++
+			}
+		}
+		if count > 0 {
+			result = append(result, models.TagCount{Tag: tag, Count: count})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result, nil
+}
+
+// indexTags adds the short URL to the index of every given tag.
+// It must be called with the mutex held.
+func (s *InMemShortenStorage) indexTags(shortURL string, tags []string) {
+	for _, tag := range tags {
+		keys, ok := s.tagToKeys[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			s.tagToKeys[tag] = keys
+		}
+		keys[shortURL] = struct{}{}
+	}
+}
+
+// unindexTags removes the short URL from the index of every given tag.
+// It must be called with the mutex held.
+func (s *InMemShortenStorage) unindexTags(shortURL string, tags []string) {
+	for _, tag := range tags {
+		keys := s.tagToKeys[tag]
+		delete(keys, shortURL)
+		if len(keys) == 0 {
+			delete(s.tagToKeys, tag)
+		}
+	}
+}
+
+// put stores the ShortenData object in both maps and keeps the tag index in sync
+// with the tags of the object, replacing the previous state of the same short URL if any.
+// It must be called with the mutex held.
+func (s *InMemShortenStorage) put(data models.ShortenData) {
+	if prev, ok := s.keyToURL[data.ShortURL]; ok {
+		s.unindexTags(prev.ShortURL, prev.Tags)
+		if prev.OriginalURL != data.OriginalURL {
+			delete(s.urlToKey, prev.OriginalURL)
+		}
+	}
+	s.urlToKey[data.OriginalURL] = data
+	s.keyToURL[data.ShortURL] = data
+	s.indexTags(data.ShortURL, data.Tags)
+}
+
+// remove drops the ShortenData object with the given short URL from the maps, the tag index and the history.
+// It must be called with the mutex held.
+func (s *InMemShortenStorage) remove(shortURL string) {
+	val, ok := s.keyToURL[shortURL]
+	if !ok {
+		return
+	}
+	delete(s.keyToURL, shortURL)
+	if cur, ok := s.urlToKey[val.OriginalURL]; ok && cur.ShortURL == shortURL {
+		delete(s.urlToKey, val.OriginalURL)
+	}
+	delete(s.history, shortURL)
+	s.unindexTags(shortURL, val.Tags)
+}
+
+// writeRecord appends a record of the given type describing the ShortenData object to the write-ahead log.
+// The record is flushed and synced to the disk before it returns.
+// It must be called with the mutex held.
+func (s *InMemShortenStorage) writeRecord(t recordType, data models.ShortenData) error {
+	return s.wal.append(newRecord(t, data))
+}
+
+// apply applies a record read from the write-ahead log to the storage.
+// Records without an ID come from the files written before the log was introduced and get the next free ID.
+// It must be called with the mutex held.
+func (s *InMemShortenStorage) apply(payload []byte) error {
+	rec, err := decodeRecord(payload)
 	if err != nil {
 		return err
 	}
-	return s.writer.Flush()
+	switch rec.Type {
+	case recordCreate, recordUpdate, recordDelete:
+		if prev, ok := s.keyToURL[rec.ShortURL]; ok && rec.ID == 0 {
+			rec.ID = prev.ID
+		}
+		if rec.ID == 0 {
+			rec.ID = s.id + 1
+		}
+		if rec.ID > s.id {
+			s.id = rec.ID
+		}
+		if len(rec.History) > 0 {
+			s.history[rec.ShortURL] = append(s.history[rec.ShortURL], rec.History...)
+		}
+		s.put(rec.data())
+	case recordPurge:
+		s.remove(rec.ShortURL)
+	default:
+		s.log.Log.Warn("unknown record type", zap.String("type", string(rec.Type)), zap.String("shortURL", rec.ShortURL))
+	}
+	return nil
+}
+
+// Ping reports whether the storage file is still writable, so that the changes can be persisted.
+func (s *InMemShortenStorage) Ping(_ context.Context) error {
+	return s.wal.writable()
+}
+
+// Len returns the number of stored ShortenData objects, including the deleted ones not purged yet.
+func (s *InMemShortenStorage) Len() int {
+	defer s.mutex.RUnlock()
+	s.mutex.RLock()
+	return len(s.keyToURL)
+}
+
+// MaxUserID returns the greatest ID among the owners of the stored ShortenData objects.
+func (s *InMemShortenStorage) MaxUserID() int {
+	defer s.mutex.RUnlock()
+	s.mutex.RLock()
+	maxID := 0
+	for _, val := range s.keyToURL {
+		if val.UserID > maxID {
+			maxID = val.UserID
+		}
+	}
+	return maxID
 }
 
-// RecoverFromFile reads data from a file and recovers it into the InMemShortenStorage object.
-// It starts by logging an info message indicating that the recovery process has begun.
-// It then creates a new scanner to read the file line by line.
-// For each line, it increments the ID of the storage object and creates a new ShortenData object with that ID.
-// It reads the line
+// RecoverFromFile reads the write-ahead log and restores the state of the InMemShortenStorage object.
+// The records are applied directly to the in-memory maps and aren't appended to the file again.
+// An incomplete last record left by a crash is ignored and truncated, so the following writes start on a new line.
+// A temporary snapshot left by an interrupted compaction is removed.
 func (s *InMemShortenStorage) RecoverFromFile() error {
-	s.log.Log.Info("Starting recovering data from file....")
-	sc := bufio.NewScanner(s.file)
-	for sc.Scan() {
-		s.id += 1
-		shorten := models.ShortenData{ID: s.id}
-		b := sc.Bytes()
-		if err := json.Unmarshal(b, &shorten); err != nil {
-			s.log.Log.Error(err.Error(), zap.String("shorten :", shorten.String()))
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	s.log.Log.Info("Starting recovering data from file....", zap.String("path", s.wal.path))
+	truncated, err := s.wal.replay(s.apply)
+	if err != nil {
+		return err
+	}
+	if truncated > 0 {
+		s.log.Log.Warn("incomplete record truncated", zap.Int64("bytes", truncated))
+	}
+	s.log.Log.Info("data recovered from file", zap.Int("count", len(s.keyToURL)))
+	return nil
+}
+
+// Compact replaces the write-ahead log with a snapshot of the current state of the storage,
+// so that the log doesn't keep growing with updates, deletions and purged objects.
+// The snapshot keeps the history of the previous destinations of every object.
+// A crash during compaction leaves either the old or the new log intact.
+func (s *InMemShortenStorage) Compact() error {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	data := make([]models.ShortenData, 0, len(s.keyToURL))
+	for _, val := range s.keyToURL {
+		data = append(data, val)
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].ID < data[j].ID
+	})
+	return s.wal.compact(func(w io.Writer) error {
+		for _, val := range data {
+			rec := newRecord(recordCreate, val)
+			rec.History = s.history[val.ShortURL]
+			if err := writeJSONLine(w, rec); err != nil {
+				return err
+			}
 		}
-		s.log.Log.Info("writing ...", zap.String("shorten :", shorten.String()))
-		if err := s.Save(shorten.ShortURL, shorten.OriginalURL, shorten.UserID); err != nil {
-			s.log.Log.Error("Error during saving ", zap.String("data", shorten.String()))
+		return nil
+	})
+}
+
+// RunCompaction calls Compact every interval until the context is done.
+func (s *InMemShortenStorage) RunCompaction(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Compact(); err != nil {
+				s.log.Log.Error("error during compacting file", zap.Error(err))
+			}
 		}
 	}
-	return nil
 }
 
 // Close closes the file associated with the InMemShortenStorage object.
 // It returns an error if the file fails to close.
 func (s *InMemShortenStorage) Close() error {
-	return s.file.Close()
+	return s.wal.close()
 }
 
-// SaveUser saves a user with the given name and password into the user storage.
-// It generates a unique UserID for the user and adds it to the userMap.
-// The method returns the generated UserID and a nil error.
-// It uses
-func (s *InMemUserStorage) SaveUser(name, pass string) (int, error) {
+// DeleteByShortURL deletes a ShortenData object of the user with the specified shortURL.
+// It sets the DeletedFlag to true and remembers the moment of deletion for the specified shortURL.
+// It returns false if the shortURL is unknown, belongs to another user or the deletion can't be written to the file.
+func (s *InMemShortenStorage) DeleteByShortURL(ctx context.Context, shortURL string, userID int) bool {
 	defer s.mutex.Unlock()
 	s.mutex.Lock()
-	s.id += 1
-	user := models.User{
-		UserID: s.id,
-		Name:   name,
-		Pass:   pass,
+	val, ok := s.keyToURL[shortURL]
+	if !ok || val.UserID != userID {
+		return false
+	}
+	if val.DeletedFlag {
+		return true
 	}
-	s.userMap[s.id] = user
-	return user.UserID, nil
+	now := time.Now()
+	val.DeletedFlag = true
+	val.DeletedAt = &now
+	if err := s.writeRecord(recordDelete, val); err != nil {
+		s.log.Ctx(ctx).Error(err.Error(), zap.String("during deleting short url", shortURL))
+		return false
+	}
+	s.put(val)
+	return true
 }
 
-// FindByID retrieves a User object based on the provided userID.
-// It returns the User object and a nil error if the user
-func (s *InMemUserStorage) FindByID(userID int) (models.User, error) {
-	user, ok := s.userMap[userID]
-	if !ok {
-		return user, errors.New("user doesn't exist")
+// RestoreByShortURL restores a ShortenData object of the user deleted after the given moment.
+// It returns true if the object was restored, or an error if the restoration can't be written to the file.
+func (s *InMemShortenStorage) RestoreByShortURL(_ context.Context, shortURL string, userID int, deletedAfter time.Time) (bool, error) {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	val, ok := s.keyToURL[shortURL]
+	if !ok || !val.DeletedFlag || val.UserID != userID {
+		return false, nil
+	}
+	if val.DeletedAt == nil || val.DeletedAt.Before(deletedAfter) {
+		return false, nil
+	}
+	val.DeletedFlag = false
+	val.DeletedAt = nil
+	if err := s.writeRecord(recordUpdate, val); err != nil {
+		return false, err
 	}
-	return user, nil
+	s.put(val)
+	return true, nil
 }
 
-// DeleteByShortURL deletes a ShortenData object with the specified shortURL.
-// It sets the DeletedFlag to true for the specified shortURL in the keyToURL map.
-// It returns true to indicate that the deletion was successful.
-func (s *InMemShortenStorage) DeleteByShortURL(shortURL string) bool {
+// PurgeDeleted permanently removes the ShortenData objects deleted before the given moment.
+// A purge record is written for every removed object, so they aren't recovered on the next start.
+// It returns the number of removed objects.
+func (s *InMemShortenStorage) PurgeDeleted(_ context.Context, deletedBefore time.Time) (int, error) {
 	defer s.mutex.Unlock()
 	s.mutex.Lock()
-	val := s.keyToURL[shortURL]
-	val.DeletedFlag = true
-	s.keyToURL[shortURL] = val
-	return true
+	count := 0
+	for key, val := range s.keyToURL {
+		if !val.DeletedFlag || val.DeletedAt == nil || !val.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+		if err := s.writeRecord(recordPurge, val); err != nil {
+			return count, err
+		}
+		s.remove(key)
+		count++
+	}
+	return count, nil
+}
+
+// sortNewestFirst orders the ShortenData objects by ID in descending order.
+func sortNewestFirst(data []models.ShortenData) {
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].ID > data[j].ID
+	})
 }
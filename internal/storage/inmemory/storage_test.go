@@ -0,0 +1,30 @@
+package inmemory
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/storage/storagetest"
+)
+
+func TestShortenRepositoryConformance(t *testing.T) {
+	storagetest.RunShortenRepository(t, func(t *testing.T) storage.ShortenRepository {
+		return openStorage(t, filepath.Join(t.TempDir(), "db.json"))
+	})
+}
+
+func TestUserRepositoryConformance(t *testing.T) {
+	storagetest.RunUserRepository(t, func(t *testing.T) storage.UserRepository {
+		cfg := &configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+		s, err := NewInMemUserStorage(cfg, &logger.Logger{Log: zap.NewNop()})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
@@ -0,0 +1,138 @@
+package inmemory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/models"
+)
+
+// InMemUserStorage is an in-memory implementation of a storage for user data.
+// The userMap field is a map that stores users by their ID.
+// The key is the user's ID (integer), and the value is a User object.
+// When a file storage path is configured, every user is also appended to a sibling log file,
+// so users and the ID sequence survive restarts.
+type InMemUserStorage struct {
+	userMap map[int]models.User
+	id      int
+	mutex   sync.RWMutex
+	// wal is the log users are appended to, it is nil when users are kept in memory only.
+	wal *logFile
+	log *logger.Logger
+}
+
+// NewInMemUserStorage creates a new instance of InMemUserStorage.
+// If cfg has a file storage path, the users are persisted to the file returned by UserStoragePath.
+func NewInMemUserStorage(cfg *configuration.Storage, logger *logger.Logger) (*InMemUserStorage, error) {
+	s := &InMemUserStorage{
+		userMap: make(map[int]models.User),
+		id:      0,
+		log:     logger,
+	}
+	if cfg == nil || cfg.FileStoragePath == "" {
+		return s, nil
+	}
+	wal, err := openLogFile(UserStoragePath(cfg.FileStoragePath), 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.wal = wal
+	return s, nil
+}
+
+// UserStoragePath returns the location of the users file next to the given shorten storage file,
+// e.g. /tmp/short-url-db.users.json for /tmp/short-url-db.json.
+func UserStoragePath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".users" + ext
+}
+
+// SaveUser saves a user with the given name and password into the user storage.
+// It generates a unique UserID for the user and adds it to the userMap.
+// The user is written to the file before it becomes visible.
+// The method returns the generated UserID.
+func (s *InMemUserStorage) SaveUser(_ context.Context, name, pass string) (int, error) {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	user := models.User{
+		UserID:   s.id + 1,
+		Name:     name,
+		Pass:     pass,
+		IsActive: true,
+	}
+	if s.wal != nil {
+		if err := s.wal.append(user); err != nil {
+			return 0, err
+		}
+	}
+	s.id = user.UserID
+	s.userMap[user.UserID] = user
+	return user.UserID, nil
+}
+
+// FindByID retrieves a User object based on the provided userID.
+// It returns an error if the user doesn't exist.
+func (s *InMemUserStorage) FindByID(_ context.Context, userID int) (models.User, error) {
+	defer s.mutex.RUnlock()
+	s.mutex.RLock()
+	user, ok := s.userMap[userID]
+	if !ok {
+		return user, errors.New("user doesn't exist")
+	}
+	return user, nil
+}
+
+// ReserveIDs makes sure that the IDs of new users are greater than minID.
+// It is used to avoid giving new users the IDs of the owners of already stored URLs.
+func (s *InMemUserStorage) ReserveIDs(minID int) {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	if minID > s.id {
+		s.id = minID
+	}
+}
+
+// RecoverFromFile reads the users file and restores the users and the ID sequence.
+// An incomplete last line left by a crash is ignored and truncated.
+func (s *InMemUserStorage) RecoverFromFile() error {
+	if s.wal == nil {
+		return nil
+	}
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	truncated, err := s.wal.replay(func(payload []byte) error {
+		var user models.User
+		if err := json.Unmarshal(payload, &user); err != nil {
+			return err
+		}
+		s.userMap[user.UserID] = user
+		if user.UserID > s.id {
+			s.id = user.UserID
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if truncated > 0 {
+		s.log.Log.Warn("incomplete user record truncated", zap.Int64("bytes", truncated))
+	}
+	s.log.Log.Info("users recovered from file", zap.Int("count", len(s.userMap)), zap.Int("lastID", s.id))
+	return nil
+}
+
+// Close closes the users file if there is one.
+func (s *InMemUserStorage) Close() error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.wal.close()
+}
@@ -0,0 +1,308 @@
+package inmemory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lookeme/short-url/internal/models"
+)
+
+// recordType identifies the kind of change stored in a write-ahead log record.
+type recordType string
+
+const (
+	// recordCreate stores a new ShortenData object.
+	recordCreate recordType = "create"
+	// recordUpdate replaces the destination, the tags or the deleted state of an existing object.
+	recordUpdate recordType = "update"
+	// recordDelete marks an existing object as deleted.
+	recordDelete recordType = "delete"
+	// recordPurge removes an existing object permanently.
+	recordPurge recordType = "purge"
+)
+
+// checksumLen is the length of the hex encoded CRC-32 checksum which prefixes every record line.
+const checksumLen = 8
+
+// errChecksum is returned when the checksum of a record line doesn't match its payload.
+var errChecksum = errors.New("record checksum mismatch")
+
+// record is a single entry of the write-ahead log of the shorten storage.
+// Every record carries the full state of the ShortenData object it refers to,
+// so applying the records in order restores the storage including IDs, owners and deletions.
+// History holds the previous destinations added by the record: the one replaced by an update,
+// or all of them in a record of a compacted log.
+type record struct {
+	Type          recordType          `json:"type"`
+	ID            int64               `json:"id"`
+	CorrelationID string              `json:"correlation_id,omitempty"`
+	ShortURL      string              `json:"short_url"`
+	OriginalURL   string              `json:"original_url"`
+	UserID        int                 `json:"user_id"`
+	Deleted       bool                `json:"deleted,omitempty"`
+	DeletedAt     *time.Time          `json:"deleted_at,omitempty"`
+	Tags          []string            `json:"tags,omitempty"`
+	History       []models.URLHistory `json:"history,omitempty"`
+}
+
+// newRecord creates a record of the given type from the state of the ShortenData object.
+func newRecord(t recordType, data models.ShortenData) record {
+	return record{
+		Type:          t,
+		ID:            data.ID,
+		CorrelationID: data.CorrelationID,
+		ShortURL:      data.ShortURL,
+		OriginalURL:   data.OriginalURL,
+		UserID:        data.UserID,
+		Deleted:       data.DeletedFlag,
+		DeletedAt:     data.DeletedAt,
+		Tags:          data.Tags,
+	}
+}
+
+// data returns the ShortenData object described by the record.
+func (r record) data() models.ShortenData {
+	return models.ShortenData{
+		ID:            r.ID,
+		CorrelationID: r.CorrelationID,
+		ShortURL:      r.ShortURL,
+		OriginalURL:   r.OriginalURL,
+		UserID:        r.UserID,
+		DeletedFlag:   r.Deleted,
+		DeletedAt:     r.DeletedAt,
+		Tags:          r.Tags,
+	}
+}
+
+// decodeRecord decodes the payload of a shorten storage log line.
+// Files written before the log was introduced contain bare JSON encoded ShortenData objects;
+// they are decoded as create records without an ID.
+func decodeRecord(payload []byte) (record, error) {
+	var rec record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return record{}, err
+	}
+	if rec.Type != "" {
+		return rec, nil
+	}
+	var data models.ShortenData
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return record{}, err
+	}
+	return newRecord(recordCreate, data), nil
+}
+
+// writeLine writes the payload as a single line prefixed with its CRC-32 checksum.
+func writeLine(w io.Writer, payload []byte) error {
+	line := make([]byte, 0, checksumLen+len(payload)+2)
+	line = fmt.Appendf(line, "%08x ", crc32.ChecksumIEEE(payload))
+	line = append(line, payload...)
+	line = append(line, '\n')
+	_, err := w.Write(line)
+	return err
+}
+
+// writeJSONLine encodes v as JSON and writes it with writeLine.
+func writeJSONLine(w io.Writer, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeLine(w, payload)
+}
+
+// decodeLine verifies the checksum of a line and returns its payload.
+// Lines starting with '{' were written before checksums were introduced and are returned as is.
+func decodeLine(line []byte) ([]byte, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) > 0 && line[0] == '{' {
+		return line, nil
+	}
+	if len(line) < checksumLen+1 || line[checksumLen] != ' ' {
+		return nil, errors.New("malformed record")
+	}
+	expected, err := strconv.ParseUint(string(line[:checksumLen]), 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	payload := line[checksumLen+1:]
+	if uint64(crc32.ChecksumIEEE(payload)) != expected {
+		return nil, errChecksum
+	}
+	return payload, nil
+}
+
+// replay reads the lines from r and passes their payloads to apply in order.
+// A crash in the middle of a write may leave the last line incomplete or corrupted;
+// such a line is ignored. A broken line followed by other lines means that the log
+// is corrupted, and an error is returned.
+// It returns the length of the valid part of the log, so the torn tail can be truncated.
+func replay(r io.Reader, apply func(payload []byte) error) (int64, error) {
+	br := bufio.NewReader(r)
+	var offset int64
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a line without the trailing newline was torn by a crash
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			offset += int64(len(line))
+			continue
+		}
+		payload, err := decodeLine(line)
+		if err == nil {
+			err = apply(payload)
+		}
+		if err != nil {
+			if _, peekErr := br.Peek(1); errors.Is(peekErr, io.EOF) {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("corrupted record on line %d: %w", lineNo, err)
+		}
+		offset += int64(len(line))
+	}
+}
+
+// logFile is an append-only file of checksummed lines every change of a storage is written to.
+type logFile struct {
+	path   string
+	perm   os.FileMode
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// openLogFile opens or creates the log file at the given path for appending.
+func openLogFile(path string, perm os.FileMode) (*logFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &logFile{
+		path:   path,
+		perm:   perm,
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+// writable reports whether the log file still exists and can be opened for writing.
+func (l *logFile) writable() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// append writes v as a single line and makes sure it reaches the disk before it returns.
+func (l *logFile) append(v any) error {
+	if err := writeJSONLine(l.writer, v); err != nil {
+		return err
+	}
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// replay passes the payload of every line of the log to apply in order.
+// An incomplete last line left by a crash is truncated, so the following writes start on a new line.
+// A temporary snapshot left by an interrupted compaction is removed.
+// It returns the number of bytes truncated.
+func (l *logFile) replay(apply func(payload []byte) error) (int64, error) {
+	if err := os.Remove(l.snapshotPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	valid, err := replay(l.file, apply)
+	if err != nil {
+		return 0, err
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() <= valid {
+		return 0, nil
+	}
+	return info.Size() - valid, l.file.Truncate(valid)
+}
+
+// compact replaces the log with a snapshot produced by write.
+// The snapshot is written to a temporary file which is synced and then atomically renamed over the log,
+// so a crash during compaction leaves either the old or the new log intact.
+// The directory is synced after the rename, so the new log survives a crash once compact returns.
+func (l *logFile) compact(write func(w io.Writer) error) error {
+	tmp, err := os.OpenFile(l.snapshotPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, l.perm)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.snapshotPath(), l.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(l.path)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, l.perm)
+	if err != nil {
+		return err
+	}
+	old := l.file
+	l.file = file
+	l.writer.Reset(file)
+	return old.Close()
+}
+
+// syncDir flushes the entries of the directory to the disk, e.g. a file renamed within it.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// snapshotPath returns the location of the temporary file a snapshot is written to during compaction.
+func (l *logFile) snapshotPath() string {
+	return l.path + ".tmp"
+}
+
+// close closes the underlying file.
+func (l *logFile) close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,206 @@
+package inmemory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+)
+
+func openStorage(t *testing.T, path string) *InMemShortenStorage {
+	t.Helper()
+	log := &logger.Logger{Log: zap.NewNop()}
+	s, err := NewInMemShortenStorage(&configuration.Storage{FileStoragePath: path}, log)
+	require.NoError(t, err)
+	require.NoError(t, s.RecoverFromFile())
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func fillStorage(t *testing.T, s *InMemShortenStorage) {
+	ctx := context.Background()
+	t.Helper()
+	require.NoError(t, s.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	require.NoError(t, s.Save(ctx, "http://localhost/b", "https://b.example", 2))
+	require.NoError(t, s.SaveAll(ctx, []models.ShortenData{
+		{ShortURL: "http://localhost/c", OriginalURL: "https://c.example", Tags: []string{"promo"}},
+	}))
+	require.NoError(t, s.Update(ctx, "http://localhost/b", "https://b2.example", 2))
+	require.True(t, s.DeleteByShortURL(ctx, "http://localhost/a", 1))
+}
+
+func assertRecovered(t *testing.T, s *InMemShortenStorage) {
+	ctx := context.Background()
+	t.Helper()
+	a, err := s.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), a.ID)
+	assert.Equal(t, 1, a.UserID)
+	assert.True(t, a.DeletedFlag)
+	assert.NotNil(t, a.DeletedAt)
+
+	b, err := s.FindByKey(ctx, "http://localhost/b")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), b.ID)
+	assert.Equal(t, "https://b2.example", b.OriginalURL)
+	_, ok := s.FindByURL(ctx, "https://b.example")
+	assert.False(t, ok)
+
+	c, err := s.FindByKey(ctx, "http://localhost/c")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), c.ID)
+	assert.Equal(t, []string{"promo"}, c.Tags)
+}
+
+func TestRecoverFromFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.json")
+	fillStorage(t, openStorage(t, path))
+	s := openStorage(t, path)
+	assertRecovered(t, s)
+
+	require.NoError(t, s.Save(ctx, "http://localhost/d", "https://d.example", 1))
+	d, err := s.FindByKey(ctx, "http://localhost/d")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), d.ID)
+}
+
+func TestRecoverFromTornLastLine(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.json")
+	fillStorage(t, openStorage(t, path))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		tail string
+	}{
+		{name: "incomplete line", tail: `1a2b3c4d {"type":"create","id":4,"short_url":"http://loc`},
+		{name: "checksum mismatch", tail: `00000000 {"type":"create","id":4,"short_url":"http://localhost/d"}` + "\n"},
+		{name: "partial checksum", tail: "1a2b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, append(append([]byte{}, content...), tt.tail...), 0666))
+			s := openStorage(t, path)
+			assertRecovered(t, s)
+			_, err := s.FindByKey(ctx, "http://localhost/d")
+			assert.ErrorIs(t, err, storage.ErrNotFound)
+
+			require.NoError(t, s.Save(ctx, "http://localhost/e", "https://e.example", 1))
+			s = openStorage(t, path)
+			assertRecovered(t, s)
+			_, err = s.FindByKey(ctx, "http://localhost/e")
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRecoverFromCorruptedLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	fillStorage(t, openStorage(t, path))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(content), "\n")
+	lines[1] = strings.Replace(lines[1], "localhost", "l0calhost", 1)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "")), 0666))
+
+	log := &logger.Logger{Log: zap.NewNop()}
+	s, err := NewInMemShortenStorage(&configuration.Storage{FileStoragePath: path}, log)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.ErrorIs(t, s.RecoverFromFile(), errChecksum)
+}
+
+func TestRecoverFromLegacyFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.json")
+	legacy := `{"short_url":"http://localhost/a","original_url":"https://a.example","DeletedFlag":false}` + "\n" +
+		`{"short_url":"http://localhost/b","original_url":"https://b.example","DeletedFlag":true}` + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0666))
+	s := openStorage(t, path)
+	a, err := s.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), a.ID)
+	b, err := s.FindByKey(ctx, "http://localhost/b")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), b.ID)
+	assert.True(t, b.DeletedFlag)
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.json")
+	s := openStorage(t, path)
+	fillStorage(t, s)
+	count, err := s.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	require.NoError(t, s.Compact())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"))
+	_, err = os.Stat(path + ".tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, s.Save(ctx, "http://localhost/d", "https://d.example", 1))
+	s = openStorage(t, path)
+	_, err = s.FindByKey(ctx, "http://localhost/a")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	for _, key := range []string{"http://localhost/b", "http://localhost/c", "http://localhost/d"} {
+		_, err := s.FindByKey(ctx, key)
+		assert.NoError(t, err, key)
+	}
+}
+
+func TestRecoverAfterInterruptedCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.json")
+	fillStorage(t, openStorage(t, path))
+	require.NoError(t, os.WriteFile(path+".tmp", []byte(`1a2b3c4d {"type":"create","id":1,"sho`), 0666))
+
+	s := openStorage(t, path)
+	assertRecovered(t, s)
+	_, err := os.Stat(path + ".tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestHistorySurvivesRestartAndCompaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.json")
+	s := openStorage(t, path)
+	require.NoError(t, s.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	require.NoError(t, s.Update(ctx, "http://localhost/a", "https://a2.example", 1))
+	require.NoError(t, s.Update(ctx, "http://localhost/a", "https://a3.example", 2))
+
+	assertHistory := func(s *InMemShortenStorage) {
+		t.Helper()
+		history := s.history["http://localhost/a"]
+		require.Len(t, history, 2)
+		assert.Equal(t, "https://a.example", history[0].OriginalURL)
+		assert.Equal(t, 1, history[0].ChangedBy)
+		assert.Equal(t, "https://a2.example", history[1].OriginalURL)
+		assert.Equal(t, 2, history[1].ChangedBy)
+	}
+	assertHistory(s)
+	s = openStorage(t, path)
+	assertHistory(s)
+	require.NoError(t, s.Compact())
+	s = openStorage(t, path)
+	assertHistory(s)
+	data, err := s.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a3.example", data.OriginalURL)
+}
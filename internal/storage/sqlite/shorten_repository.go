@@ -0,0 +1,377 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+)
+
+// ShortenRepository represents a repository for storing shortened URLs in the SQLite database.
+type ShortenRepository struct {
+	sqlite *SQLite
+}
+
+// NewShortenRepository initializes a new instance of ShortenRepository on top of the given database.
+func NewShortenRepository(sqlite *SQLite) *ShortenRepository {
+	return &ShortenRepository{
+		sqlite: sqlite,
+	}
+}
+
+// selectColumns are the columns of the "short" table read into a ShortenData object by scan.
+const selectColumns = `id, correlation_id, short_url, original_url, user_id, is_deleted, deleted_at`
+
+// insertQuery inserts a row into the "short" table; the correlation ID is generated when it is empty.
+const insertQuery = `INSERT INTO short (correlation_id, short_url, original_url, user_id) VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?, ?, ?)`
+
+// Save inserts a new record into the "short" table with the given original URL, short URL, and user ID.
+// It returns a *storage.ConflictError if the original URL is already shortened.
+func (r *ShortenRepository) Save(ctx context.Context, key, value string, userID int) error {
+	_, err := r.sqlite.db.ExecContext(ctx, insertQuery, "", key, value, userID)
+	if isUniqueViolation(err) {
+		return conflict(ctx, r.sqlite.db, value)
+	}
+	return err
+}
+
+// SaveAll saves multiple rows along with their tags within a single transaction.
+// If any of the rows can't be saved, none of them is.
+// It returns a *storage.ConflictError if any of the original URLs is already shortened or repeated within the batch.
+func (r *ShortenRepository) SaveAll(ctx context.Context, rows []models.ShortenData) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	tx, err := r.sqlite.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, row := range rows {
+		res, err := tx.ExecContext(ctx, insertQuery, row.CorrelationID, row.ShortURL, row.OriginalURL, row.UserID)
+		if isUniqueViolation(err) {
+			return conflict(ctx, tx, row.OriginalURL)
+		}
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := insertTags(ctx, tx, id, row.Tags); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveTags replaces the tags attached to the record with the given short URL within a single transaction.
+// It returns storage.ErrNotFound if there is no such record.
+func (r *ShortenRepository) SaveTags(ctx context.Context, shortURL string, tags []string) error {
+	tx, err := r.sqlite.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM short WHERE short_url = ?`, shortURL).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM short_tags WHERE short_id = ?`, id); err != nil {
+		return err
+	}
+	if err := insertTags(ctx, tx, id, tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update replaces the original URL of the record with the given short URL within a single transaction.
+// The previous destination and the ID of the user who changed it are stored in the "short_history" table.
+// It returns storage.ErrNotFound if there is no such record and a *storage.ConflictError
+// if the new original URL is already shortened.
+func (r *ShortenRepository) Update(ctx context.Context, shortURL, originalURL string, userID int) error {
+	tx, err := r.sqlite.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var (
+		id          int64
+		previousURL string
+	)
+	err = tx.QueryRowContext(ctx, `SELECT id, original_url FROM short WHERE short_url = ?`, shortURL).Scan(&id, &previousURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if previousURL == originalURL {
+		return nil
+	}
+	query := `INSERT INTO short_history (short_id, original_url, changed_by) VALUES (?, ?, ?)`
+	if _, err = tx.ExecContext(ctx, query, id, previousURL, userID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE short SET original_url = ? WHERE id = ?`, originalURL, id)
+	if isUniqueViolation(err) {
+		return conflict(ctx, tx, originalURL)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FindByURL searches for a not deleted record based on the original URL.
+// It returns the matching record and a flag indicating whether the record was found.
+func (r *ShortenRepository) FindByURL(ctx context.Context, key string) (models.ShortenData, bool) {
+	query := `SELECT ` + selectColumns + ` FROM short WHERE original_url = ? AND is_deleted = false`
+	data, err := scan(r.sqlite.db.QueryRowContext(ctx, query, key))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			r.sqlite.log.Ctx(ctx).Error(err.Error(), zap.String("during fetching by url", key))
+		}
+		return models.ShortenData{}, false
+	}
+	return data, true
+}
+
+// FindByURLs retrieves the not deleted records matching the given original URLs.
+func (r *ShortenRepository) FindByURLs(ctx context.Context, keys []string) ([]models.ShortenData, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	query := `SELECT ` + selectColumns + ` FROM short WHERE original_url IN (` + placeholders(len(keys)) + `) AND is_deleted = false`
+	args := make([]any, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return r.query(ctx, query, args...)
+}
+
+// FindByKey searches for a record based on the short URL, including deleted ones.
+// It returns storage.ErrNotFound if there is no such record.
+func (r *ShortenRepository) FindByKey(ctx context.Context, key string) (models.ShortenData, error) {
+	query := `SELECT ` + selectColumns + ` FROM short WHERE short_url = ?`
+	data, err := scan(r.sqlite.db.QueryRowContext(ctx, query, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ShortenData{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return models.ShortenData{}, err
+	}
+	return data, nil
+}
+
+// FindAll retrieves all not deleted records ordered from the newest to the oldest.
+func (r *ShortenRepository) FindAll(ctx context.Context) ([]models.ShortenData, error) {
+	return r.query(ctx, `SELECT `+selectColumns+` FROM short WHERE is_deleted = false ORDER BY id DESC`)
+}
+
+// FindAllByUserID retrieves all not deleted records of the given user along with their tags,
+// ordered from the newest to the oldest.
+func (r *ShortenRepository) FindAllByUserID(ctx context.Context, userID int) ([]models.ShortenData, error) {
+	query := `SELECT ` + selectColumns + ` FROM short WHERE user_id = ? AND is_deleted = false ORDER BY id DESC`
+	result, err := r.query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return result, r.attachTags(ctx, result)
+}
+
+// FindAllByUserIDAndTag retrieves all not deleted records of the given user marked with the tag,
+// ordered from the newest to the oldest. The tags of every returned record are loaded as well.
+func (r *ShortenRepository) FindAllByUserIDAndTag(ctx context.Context, userID int, tag string) ([]models.ShortenData, error) {
+	query := `SELECT s.id, s.correlation_id, s.short_url, s.original_url, s.user_id, s.is_deleted, s.deleted_at FROM short s JOIN short_tags t ON t.short_id = s.id WHERE s.user_id = ? AND t.tag = ? AND s.is_deleted = false ORDER BY s.id DESC`
+	result, err := r.query(ctx, query, userID, tag)
+	if err != nil {
+		return nil, err
+	}
+	return result, r.attachTags(ctx, result)
+}
+
+// CountTagsByUserID returns every tag used by the given user along with the number of not deleted records marked with it.
+// The result is ordered by count in descending order and then by tag.
+func (r *ShortenRepository) CountTagsByUserID(ctx context.Context, userID int) ([]models.TagCount, error) {
+	query := `SELECT t.tag, COUNT(*) AS count FROM short_tags t JOIN short s ON s.id = t.short_id WHERE s.user_id = ? AND s.is_deleted = false GROUP BY t.tag ORDER BY count DESC, t.tag`
+	rows, err := r.sqlite.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []models.TagCount
+	for rows.Next() {
+		var tc models.TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, tc)
+	}
+	return result, rows.Err()
+}
+
+// DeleteByShortURL marks the record of the user with the given short URL as deleted and remembers the moment of deletion.
+// Deleting an already deleted record keeps its original moment of deletion.
+// It returns false if the user has no such record.
+func (r *ShortenRepository) DeleteByShortURL(ctx context.Context, shortURL string, userID int) bool {
+	sqlStatement := `UPDATE short SET is_deleted = true, deleted_at = COALESCE(deleted_at, ?) WHERE short_url = ? AND user_id = ?`
+	res, err := r.sqlite.db.ExecContext(ctx, sqlStatement, time.Now().UnixNano(), shortURL, userID)
+	if err != nil {
+		r.sqlite.log.Ctx(ctx).Error(err.Error(), zap.String("during deleting short url", shortURL))
+		return false
+	}
+	affected, err := res.RowsAffected()
+	return err == nil && affected == 1
+}
+
+// RestoreByShortURL restores a record of the user deleted after the given moment.
+// It returns true if the record was restored.
+func (r *ShortenRepository) RestoreByShortURL(ctx context.Context, shortURL string, userID int, deletedAfter time.Time) (bool, error) {
+	sqlStatement := `UPDATE short SET is_deleted = false, deleted_at = NULL WHERE short_url = ? AND user_id = ? AND is_deleted = true AND deleted_at >= ?`
+	res, err := r.sqlite.db.ExecContext(ctx, sqlStatement, shortURL, userID, deletedAfter.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+// PurgeDeleted permanently removes the records deleted before the given moment along with their tags and history.
+// It returns the number of removed records.
+func (r *ShortenRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	sqlStatement := `DELETE FROM short WHERE is_deleted = true AND deleted_at < ?`
+	res, err := r.sqlite.db.ExecContext(ctx, sqlStatement, deletedBefore.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	return int(affected), err
+}
+
+// Ping checks that the database can be reached.
+func (r *ShortenRepository) Ping(ctx context.Context) error {
+	return r.sqlite.db.PingContext(ctx)
+}
+
+// Close closes the underlying database.
+func (r *ShortenRepository) Close() error {
+	return r.sqlite.Close()
+}
+
+// query runs a query selecting selectColumns and collects the resulting records.
+func (r *ShortenRepository) query(ctx context.Context, query string, args ...any) ([]models.ShortenData, error) {
+	rows, err := r.sqlite.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []models.ShortenData
+	for rows.Next() {
+		data, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, rows.Err()
+}
+
+// attachTags loads the tags of the given records from the "short_tags" table and sets them in place.
+func (r *ShortenRepository) attachTags(ctx context.Context, data []models.ShortenData) error {
+	if len(data) == 0 {
+		return nil
+	}
+	ids := make([]any, 0, len(data))
+	for _, d := range data {
+		ids = append(ids, d.ID)
+	}
+	query := `SELECT short_id, tag FROM short_tags WHERE short_id IN (` + placeholders(len(ids)) + `) ORDER BY tag`
+	rows, err := r.sqlite.db.QueryContext(ctx, query, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var (
+			id  int64
+			tag string
+		)
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range data {
+		data[i].Tags = tags[data[i].ID]
+	}
+	return nil
+}
+
+// queryRower runs queries returning a single row, it is implemented by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conflict returns the *storage.ConflictError carrying the record the original URL is stored with.
+func conflict(ctx context.Context, q queryRower, originalURL string) error {
+	data, err := scan(q.QueryRowContext(ctx, `SELECT `+selectColumns+` FROM short WHERE original_url = ?`, originalURL))
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrConflict, err)
+	}
+	return &storage.ConflictError{Existing: data}
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scan reads a row of selectColumns into a ShortenData object.
+func scan(row scanner) (models.ShortenData, error) {
+	var (
+		data      models.ShortenData
+		deletedAt sql.NullInt64
+	)
+	err := row.Scan(&data.ID, &data.CorrelationID, &data.ShortURL, &data.OriginalURL, &data.UserID, &data.DeletedFlag, &deletedAt)
+	if err != nil {
+		return models.ShortenData{}, err
+	}
+	if deletedAt.Valid {
+		t := time.Unix(0, deletedAt.Int64)
+		data.DeletedAt = &t
+	}
+	return data, nil
+}
+
+// insertTags attaches the given tags to the record with the given ID.
+func insertTags(ctx context.Context, tx *sql.Tx, id int64, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO short_tags (short_id, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`, id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeholders returns a comma separated list of n query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,70 @@
+// Package sqlite implements the storage repositories on top of a single SQLite database file,
+// so the service keeps relational storage without running a PostgreSQL server.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.uber.org/zap"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/storage/db"
+)
+
+// pragmas are applied to every connection: foreign keys make the tags and the history follow their URL,
+// the busy timeout makes concurrent writers wait for each other instead of failing,
+// and the write-ahead journal lets readers run alongside a writer.
+const pragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// SQLite is a type representing an open SQLite database.
+// It contains a *sql.DB object and a *logger.Logger object for logging.
+type SQLite struct {
+	db  *sql.DB
+	log *logger.Logger
+}
+
+// New opens or creates the database file given by the ConnString of cfg and applies the migrations
+// unless cfg.SkipMigrations is set.
+// The ConnString has the form sqlite:///path/to/file.db.
+func New(log *logger.Logger, cfg *configuration.Storage) (*SQLite, error) {
+	log.Log.Info("opening sqlite storage...", zap.String("path", strings.TrimPrefix(cfg.ConnString, configuration.SQLitePrefix)))
+	conn, err := OpenDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.SkipMigrations {
+		if err := db.StartSQLiteMigration(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &SQLite{db: conn, log: log}, nil
+}
+
+// OpenDB opens the database file given by the ConnString of cfg with the pragmas applied to every connection.
+func OpenDB(cfg *configuration.Storage) (*sql.DB, error) {
+	path := strings.TrimPrefix(cfg.ConnString, configuration.SQLitePrefix)
+	dsn := "file:" + path
+	if strings.Contains(path, "?") {
+		dsn += "&" + pragmas
+	} else {
+		dsn += "?" + pragmas
+	}
+	return sql.Open("sqlite", dsn)
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// isUniqueViolation reports whether err is caused by a violated unique constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
@@ -0,0 +1,51 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lookeme/short-url/internal/models"
+)
+
+// UserRepository represents a repository for managing user data in the SQLite database.
+type UserRepository struct {
+	sqlite *SQLite
+}
+
+// NewUserRepository creates a new instance of UserRepository on top of the given database.
+func NewUserRepository(sqlite *SQLite) *UserRepository {
+	return &UserRepository{
+		sqlite: sqlite,
+	}
+}
+
+// SaveUser saves a new user with the given name and password and returns the ID assigned to the user.
+func (u *UserRepository) SaveUser(ctx context.Context, name, pass string) (int, error) {
+	lastInsertID := 0
+	err := u.sqlite.db.QueryRowContext(
+		ctx,
+		"INSERT INTO users(name, pass) VALUES(?, ?) RETURNING id",
+		name, pass).Scan(&lastInsertID)
+	if err != nil {
+		return lastInsertID, err
+	}
+	return lastInsertID, nil
+}
+
+// FindByID finds a user by their userID.
+// It returns an error if the user doesn't exist.
+func (u *UserRepository) FindByID(ctx context.Context, userID int) (models.User, error) {
+	user := models.User{UserID: userID}
+	err := u.sqlite.db.QueryRowContext(
+		ctx,
+		"SELECT name, pass, is_active FROM users WHERE id = ?",
+		userID).Scan(&user.Name, &user.Pass, &user.IsActive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, errors.New("user doesn't exist")
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
@@ -1,23 +1,63 @@
 // Package storage declares interfaces for data access methods related to URL shortening and user operations.
 package storage
 
-import "github.com/lookeme/short-url/internal/models"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lookeme/short-url/internal/models"
+)
+
+// ErrNotFound is returned when the requested shorten data doesn't exist in the storage.
+var ErrNotFound = errors.New("shorten data not found")
+
+// ErrConflict is matched by the errors returned when an original URL is already shortened and can't be stored once more.
+var ErrConflict = errors.New("original url is already shortened")
+
+// ConflictError is returned by every ShortenRepository when an original URL is already shortened.
+// It matches ErrConflict and carries the data the URL is stored with, so the callers don't need to look it up.
+type ConflictError struct {
+	// Existing is the ShortenData the original URL is stored with, or an earlier item of the same batch.
+	Existing models.ShortenData
+}
+
+// Error implements the error interface.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %s is shortened as %s", ErrConflict, e.Existing.OriginalURL, e.Existing.ShortURL)
+}
+
+// Unwrap makes the error match ErrConflict.
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
 
 // ShortenRepository interface represents the necessary CRUD operations for handling URLs in persistence storage.
 type ShortenRepository interface {
-	Save(key, value string, userID int) error
-	SaveAll(urls []models.ShortenData) error
-	FindByURL(key string) (models.ShortenData, bool)
-	FindByURLs(keys []string) ([]models.ShortenData, error)
-	FindByKey(key string) (models.ShortenData, bool)
-	FindAll() ([]models.ShortenData, error)
-	FindAllByUserID(userID int) ([]models.ShortenData, error)
+	Save(ctx context.Context, key, value string, userID int) error
+	SaveAll(ctx context.Context, urls []models.ShortenData) error
+	SaveTags(ctx context.Context, shortURL string, tags []string) error
+	Update(ctx context.Context, shortURL, originalURL string, userID int) error
+	FindByURL(ctx context.Context, key string) (models.ShortenData, bool)
+	FindByURLs(ctx context.Context, keys []string) ([]models.ShortenData, error)
+	// FindByKey returns the data of the short URL, including a deleted one.
+	// It returns ErrNotFound if there is no such short URL, any other error means the lookup failed.
+	FindByKey(ctx context.Context, key string) (models.ShortenData, error)
+	FindAll(ctx context.Context) ([]models.ShortenData, error)
+	FindAllByUserID(ctx context.Context, userID int) ([]models.ShortenData, error)
+	FindAllByUserIDAndTag(ctx context.Context, userID int, tag string) ([]models.ShortenData, error)
+	CountTagsByUserID(ctx context.Context, userID int) ([]models.TagCount, error)
 	Close() error
-	DeleteByShortURL(shortURL string) bool
+	DeleteByShortURL(ctx context.Context, shortURL string, userID int) bool
+	RestoreByShortURL(ctx context.Context, shortURL string, userID int, deletedAfter time.Time) (bool, error)
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error)
+	// Ping reports whether the storage is able to serve requests, e.g. whether the database is reachable.
+	Ping(ctx context.Context) error
 }
 
 // UserRepository interface defines the methods necessary for handling users in persistence storage.
 type UserRepository interface {
-	SaveUser(name, pass string) (int, error)
-	FindByID(userID int) (models.User, error)
+	SaveUser(ctx context.Context, name, pass string) (int, error)
+	FindByID(ctx context.Context, userID int) (models.User, error)
 }
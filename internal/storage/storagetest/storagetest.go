@@ -0,0 +1,335 @@
+// Package storagetest provides a behavioral test suite shared by all implementations
+// of storage.ShortenRepository and storage.UserRepository, so every backend behaves the same way.
+//
+// Example usage:
+//
+//	func TestShortenRepository(t *testing.T) {
+//	    storagetest.RunShortenRepository(t, func(t *testing.T) storage.ShortenRepository {
+//	        return newRepository(t)
+//	    })
+//	}
+package storagetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+)
+
+// ShortenFactory creates an empty ShortenRepository for a single test.
+// The factory is responsible for releasing the repository when the test ends, e.g. with t.Cleanup.
+type ShortenFactory func(t *testing.T) storage.ShortenRepository
+
+// UserFactory creates an empty UserRepository for a single test.
+// The factory is responsible for releasing the repository when the test ends, e.g. with t.Cleanup.
+type UserFactory func(t *testing.T) storage.UserRepository
+
+// RunShortenRepository runs the conformance suite against the repositories created by newRepo.
+// Every subtest gets a fresh repository.
+func RunShortenRepository(t *testing.T, newRepo ShortenFactory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo storage.ShortenRepository)
+	}{
+		{name: "save and find", run: testSaveAndFind},
+		{name: "deduplication", run: testDeduplication},
+		{name: "batch", run: testBatch},
+		{name: "batch is atomic", run: testBatchIsAtomic},
+		{name: "user scoping", run: testUserScoping},
+		{name: "tags", run: testTags},
+		{name: "update", run: testUpdate},
+		{name: "deletion", run: testDeletion},
+		{name: "restore and purge", run: testRestoreAndPurge},
+		{name: "ping", run: testPing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepo(t))
+		})
+	}
+}
+
+// RunUserRepository runs the conformance suite against the repositories created by newRepo.
+func RunUserRepository(t *testing.T, newRepo UserFactory) {
+	ctx := context.Background()
+	repo := newRepo(t)
+	first, err := repo.SaveUser(ctx, "first", "hash1")
+	require.NoError(t, err)
+	second, err := repo.SaveUser(ctx, "second", "hash2")
+	require.NoError(t, err)
+	assert.Greater(t, first, 0)
+	assert.Greater(t, second, first)
+
+	user, err := repo.FindByID(ctx, second)
+	require.NoError(t, err)
+	assert.Equal(t, second, user.UserID)
+	assert.Equal(t, "second", user.Name)
+	assert.Equal(t, "hash2", user.Pass)
+	assert.True(t, user.IsActive)
+
+	_, err = repo.FindByID(ctx, second+1)
+	assert.Error(t, err)
+}
+
+func testSaveAndFind(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+
+	data, err := repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost/a", data.ShortURL)
+	assert.Equal(t, "https://a.example", data.OriginalURL)
+	assert.Equal(t, 1, data.UserID)
+	assert.False(t, data.DeletedFlag)
+	assert.NotZero(t, data.ID)
+
+	byURL, ok := repo.FindByURL(ctx, "https://a.example")
+	require.True(t, ok)
+	assert.Equal(t, data.ID, byURL.ID)
+	assert.Equal(t, "http://localhost/a", byURL.ShortURL)
+
+	_, err = repo.FindByKey(ctx, "http://localhost/unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, ok = repo.FindByURL(ctx, "https://unknown.example")
+	assert.False(t, ok)
+}
+
+func testDeduplication(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	var conflict *storage.ConflictError
+	require.ErrorAs(t, repo.Save(ctx, "http://localhost/b", "https://a.example", 2), &conflict)
+	assert.ErrorIs(t, conflict, storage.ErrConflict)
+	assert.Equal(t, "http://localhost/a", conflict.Existing.ShortURL, "the conflict carries the existing data")
+	assert.Equal(t, "https://a.example", conflict.Existing.OriginalURL)
+	assert.Equal(t, 1, conflict.Existing.UserID)
+
+	data, ok := repo.FindByURL(ctx, "https://a.example")
+	require.True(t, ok)
+	assert.Equal(t, "http://localhost/a", data.ShortURL)
+	assert.Equal(t, 1, data.UserID)
+	_, err := repo.FindByKey(ctx, "http://localhost/b")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.True(t, repo.DeleteByShortURL(ctx, "http://localhost/a", 1))
+	assert.ErrorIs(t, repo.Save(ctx, "http://localhost/c", "https://a.example", 1), storage.ErrConflict,
+		"a deleted URL still holds its original URL until it is purged")
+}
+
+func testBatch(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.SaveAll(ctx, nil))
+	require.NoError(t, repo.SaveAll(ctx, []models.ShortenData{
+		{CorrelationID: "1", ShortURL: "http://localhost/a", OriginalURL: "https://a.example", UserID: 1},
+		{CorrelationID: "2", ShortURL: "http://localhost/b", OriginalURL: "https://b.example", UserID: 1, Tags: []string{"promo"}},
+	}))
+
+	a, err := repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", a.CorrelationID)
+	assert.Equal(t, 1, a.UserID)
+	b, err := repo.FindByKey(ctx, "http://localhost/b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", b.CorrelationID)
+	assert.NotEqual(t, a.ID, b.ID)
+
+	found, err := repo.FindByURLs(ctx, []string{"https://a.example", "https://b.example", "https://unknown.example"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"http://localhost/a", "http://localhost/b"}, shortURLs(found))
+
+	tagged, err := repo.FindAllByUserIDAndTag(ctx, 1, "promo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/b"}, shortURLs(tagged))
+}
+
+func testBatchIsAtomic(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+
+	err := repo.SaveAll(ctx, []models.ShortenData{
+		{CorrelationID: "1", ShortURL: "http://localhost/b", OriginalURL: "https://b.example", UserID: 1},
+		{CorrelationID: "2", ShortURL: "http://localhost/c", OriginalURL: "https://a.example", UserID: 1},
+	})
+	var conflict *storage.ConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "http://localhost/a", conflict.Existing.ShortURL)
+	_, err = repo.FindByKey(ctx, "http://localhost/b")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "no row of a failed batch is stored")
+
+	err = repo.SaveAll(ctx, []models.ShortenData{
+		{CorrelationID: "1", ShortURL: "http://localhost/d", OriginalURL: "https://d.example", UserID: 1},
+		{CorrelationID: "2", ShortURL: "http://localhost/e", OriginalURL: "https://d.example", UserID: 1},
+	})
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "https://d.example", conflict.Existing.OriginalURL)
+	_, ok := repo.FindByURL(ctx, "https://d.example")
+	assert.False(t, ok, "a batch with a repeated original URL is rejected as a whole")
+
+	all, err := repo.FindAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/a"}, shortURLs(all))
+}
+
+func testUserScoping(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	require.NoError(t, repo.Save(ctx, "http://localhost/b", "https://b.example", 2))
+	require.NoError(t, repo.Save(ctx, "http://localhost/c", "https://c.example", 1))
+
+	first, err := repo.FindAllByUserID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/c", "http://localhost/a"}, shortURLs(first), "newest first")
+	second, err := repo.FindAllByUserID(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/b"}, shortURLs(second))
+	nobody, err := repo.FindAllByUserID(ctx, 3)
+	require.NoError(t, err)
+	assert.Empty(t, nobody)
+
+	all, err := repo.FindAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/c", "http://localhost/b", "http://localhost/a"}, shortURLs(all))
+}
+
+func testTags(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	require.NoError(t, repo.Save(ctx, "http://localhost/b", "https://b.example", 1))
+	require.NoError(t, repo.Save(ctx, "http://localhost/c", "https://c.example", 2))
+	require.NoError(t, repo.SaveTags(ctx, "http://localhost/a", []string{"promo", "spring"}))
+	require.NoError(t, repo.SaveTags(ctx, "http://localhost/b", []string{"spring"}))
+	require.NoError(t, repo.SaveTags(ctx, "http://localhost/c", []string{"promo"}))
+	assert.ErrorIs(t, repo.SaveTags(ctx, "http://localhost/unknown", []string{"promo"}), storage.ErrNotFound)
+
+	urls, err := repo.FindAllByUserID(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal(t, []string{"spring"}, urls[0].Tags)
+	assert.Equal(t, []string{"promo", "spring"}, urls[1].Tags)
+
+	tagged, err := repo.FindAllByUserIDAndTag(ctx, 1, "promo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/a"}, shortURLs(tagged))
+	tags, err := repo.CountTagsByUserID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{{Tag: "spring", Count: 2}, {Tag: "promo", Count: 1}}, tags)
+
+	require.NoError(t, repo.SaveTags(ctx, "http://localhost/a", nil))
+	tagged, err = repo.FindAllByUserIDAndTag(ctx, 1, "promo")
+	require.NoError(t, err)
+	assert.Empty(t, tagged)
+}
+
+func testUpdate(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	require.NoError(t, repo.Save(ctx, "http://localhost/b", "https://b.example", 1))
+
+	require.NoError(t, repo.Update(ctx, "http://localhost/a", "https://a2.example", 1))
+	require.NoError(t, repo.Update(ctx, "http://localhost/a", "https://a2.example", 1))
+	data, err := repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a2.example", data.OriginalURL)
+	_, ok := repo.FindByURL(ctx, "https://a.example")
+	assert.False(t, ok)
+	data, ok = repo.FindByURL(ctx, "https://a2.example")
+	require.True(t, ok)
+	assert.Equal(t, "http://localhost/a", data.ShortURL)
+
+	var conflict *storage.ConflictError
+	require.ErrorAs(t, repo.Update(ctx, "http://localhost/a", "https://b.example", 1), &conflict)
+	assert.Equal(t, "https://b.example", conflict.Existing.OriginalURL)
+	assert.NotEqual(t, "http://localhost/a", conflict.Existing.ShortURL)
+	assert.ErrorIs(t, repo.Update(ctx, "http://localhost/unknown", "https://c.example", 1), storage.ErrNotFound)
+}
+
+func testDeletion(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	require.NoError(t, repo.Save(ctx, "http://localhost/b", "https://b.example", 1))
+	require.NoError(t, repo.SaveTags(ctx, "http://localhost/a", []string{"promo"}))
+
+	assert.False(t, repo.DeleteByShortURL(ctx, "http://localhost/a", 2), "only the owner deletes")
+	data, err := repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	assert.False(t, data.DeletedFlag)
+	assert.True(t, repo.DeleteByShortURL(ctx, "http://localhost/a", 1))
+	assert.True(t, repo.DeleteByShortURL(ctx, "http://localhost/a", 1), "deletion is idempotent")
+	assert.False(t, repo.DeleteByShortURL(ctx, "http://localhost/unknown", 1))
+
+	data, err = repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err, "deleted URLs are still found by key, so redirects can answer 410 Gone")
+	assert.True(t, data.DeletedFlag)
+	_, ok := repo.FindByURL(ctx, "https://a.example")
+	assert.False(t, ok)
+
+	found, err := repo.FindByURLs(ctx, []string{"https://a.example", "https://b.example"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/b"}, shortURLs(found))
+	urls, err := repo.FindAllByUserID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/b"}, shortURLs(urls))
+	all, err := repo.FindAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost/b"}, shortURLs(all))
+	tagged, err := repo.FindAllByUserIDAndTag(ctx, 1, "promo")
+	require.NoError(t, err)
+	assert.Empty(t, tagged)
+	tags, err := repo.CountTagsByUserID(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+}
+
+func testRestoreAndPurge(t *testing.T, repo storage.ShortenRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, "http://localhost/a", "https://a.example", 1))
+	require.NoError(t, repo.Save(ctx, "http://localhost/b", "https://b.example", 1))
+	require.True(t, repo.DeleteByShortURL(ctx, "http://localhost/a", 1))
+	require.True(t, repo.DeleteByShortURL(ctx, "http://localhost/b", 1))
+	hourAgo := time.Now().Add(-time.Hour)
+	restore := func(shortURL string, userID int, deletedAfter time.Time) bool {
+		restored, err := repo.RestoreByShortURL(ctx, shortURL, userID, deletedAfter)
+		require.NoError(t, err)
+		return restored
+	}
+
+	assert.False(t, restore("http://localhost/a", 2, hourAgo), "only the owner restores")
+	assert.False(t, restore("http://localhost/a", 1, time.Now().Add(time.Hour)), "the grace period is over")
+	assert.False(t, restore("http://localhost/unknown", 1, hourAgo))
+	assert.True(t, restore("http://localhost/a", 1, hourAgo))
+	assert.False(t, restore("http://localhost/a", 1, hourAgo), "the URL isn't deleted anymore")
+	_, ok := repo.FindByURL(ctx, "https://a.example")
+	assert.True(t, ok)
+
+	count, err := repo.PurgeDeleted(ctx, hourAgo)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	count, err = repo.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	_, err = repo.FindByKey(ctx, "http://localhost/b")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = repo.FindByKey(ctx, "http://localhost/a")
+	assert.NoError(t, err)
+
+	require.NoError(t, repo.Save(ctx, "http://localhost/c", "https://b.example", 1), "a purged URL can be shortened again")
+}
+
+func testPing(t *testing.T, repo storage.ShortenRepository) {
+	assert.NoError(t, repo.Ping(context.Background()), "an open repository is healthy")
+}
+
+// shortURLs returns the short URLs of the given records in order.
+func shortURLs(data []models.ShortenData) []string {
+	result := make([]string, 0, len(data))
+	for _, d := range data {
+		result = append(result, d.ShortURL)
+	}
+	return result
+}
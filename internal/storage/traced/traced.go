@@ -0,0 +1,213 @@
+// Package traced wraps the storage repositories with tracing, so every storage call shows up
+// as a span of the request it was made for, along with the short URL and the number of rows involved.
+package traced
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/tracing"
+)
+
+// ShortenRepository is a storage.ShortenRepository starting a span for every call of the wrapped repository.
+type ShortenRepository struct {
+	storage.ShortenRepository
+	tracer *tracing.Tracer
+	system string
+}
+
+// NewShortenRepository wraps the repository of the given storage type, e.g. configuration.StoragePostgres,
+// reporting the spans to tracer.
+func NewShortenRepository(repo storage.ShortenRepository, tracer *tracing.Tracer, system string) *ShortenRepository {
+	return &ShortenRepository{
+		ShortenRepository: repo,
+		tracer:            tracer,
+		system:            system,
+	}
+}
+
+// start starts the span of a storage operation.
+func (r *ShortenRepository) start(ctx context.Context, operation string, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	return startSpan(ctx, r.tracer, r.system, operation, attrs...)
+}
+
+// Save traces storing a short URL.
+func (r *ShortenRepository) Save(ctx context.Context, key, value string, userID int) error {
+	ctx, span := r.start(ctx, "Save", tracing.String("short_url", key), tracing.Int("user_id", userID))
+	defer span.End()
+	err := r.ShortenRepository.Save(ctx, key, value, userID)
+	span.RecordError(err)
+	return err
+}
+
+// SaveAll traces storing a batch of short URLs.
+func (r *ShortenRepository) SaveAll(ctx context.Context, urls []models.ShortenData) error {
+	ctx, span := r.start(ctx, "SaveAll", tracing.Int("rows", len(urls)))
+	defer span.End()
+	err := r.ShortenRepository.SaveAll(ctx, urls)
+	span.RecordError(err)
+	return err
+}
+
+// SaveTags traces replacing the tags of a short URL.
+func (r *ShortenRepository) SaveTags(ctx context.Context, shortURL string, tags []string) error {
+	ctx, span := r.start(ctx, "SaveTags", tracing.String("short_url", shortURL), tracing.Int("tags", len(tags)))
+	defer span.End()
+	err := r.ShortenRepository.SaveTags(ctx, shortURL, tags)
+	span.RecordError(err)
+	return err
+}
+
+// Update traces replacing the original URL of a short URL.
+func (r *ShortenRepository) Update(ctx context.Context, shortURL, originalURL string, userID int) error {
+	ctx, span := r.start(ctx, "Update", tracing.String("short_url", shortURL), tracing.Int("user_id", userID))
+	defer span.End()
+	err := r.ShortenRepository.Update(ctx, shortURL, originalURL, userID)
+	span.RecordError(err)
+	return err
+}
+
+// FindByURL traces looking up the short URL of an original URL.
+func (r *ShortenRepository) FindByURL(ctx context.Context, key string) (models.ShortenData, bool) {
+	ctx, span := r.start(ctx, "FindByURL")
+	defer span.End()
+	data, ok := r.ShortenRepository.FindByURL(ctx, key)
+	span.SetAttributes(tracing.Bool("found", ok))
+	return data, ok
+}
+
+// FindByURLs traces looking up the short URLs of a batch of original URLs.
+func (r *ShortenRepository) FindByURLs(ctx context.Context, keys []string) ([]models.ShortenData, error) {
+	ctx, span := r.start(ctx, "FindByURLs", tracing.Int("keys", len(keys)))
+	defer span.End()
+	result, err := r.ShortenRepository.FindByURLs(ctx, keys)
+	span.SetAttributes(tracing.Int("rows", len(result)))
+	span.RecordError(err)
+	return result, err
+}
+
+// FindByKey traces looking up a short URL, the lookup behind every redirect.
+// A missing short URL isn't recorded as an error of the span.
+func (r *ShortenRepository) FindByKey(ctx context.Context, key string) (models.ShortenData, error) {
+	ctx, span := r.start(ctx, "FindByKey", tracing.String("short_url", key))
+	defer span.End()
+	data, err := r.ShortenRepository.FindByKey(ctx, key)
+	span.SetAttributes(tracing.Bool("found", err == nil))
+	if !errors.Is(err, storage.ErrNotFound) {
+		span.RecordError(err)
+	}
+	return data, err
+}
+
+// FindAll traces listing all short URLs.
+func (r *ShortenRepository) FindAll(ctx context.Context) ([]models.ShortenData, error) {
+	ctx, span := r.start(ctx, "FindAll")
+	defer span.End()
+	result, err := r.ShortenRepository.FindAll(ctx)
+	span.SetAttributes(tracing.Int("rows", len(result)))
+	span.RecordError(err)
+	return result, err
+}
+
+// FindAllByUserID traces listing the short URLs of a user.
+func (r *ShortenRepository) FindAllByUserID(ctx context.Context, userID int) ([]models.ShortenData, error) {
+	ctx, span := r.start(ctx, "FindAllByUserID", tracing.Int("user_id", userID))
+	defer span.End()
+	result, err := r.ShortenRepository.FindAllByUserID(ctx, userID)
+	span.SetAttributes(tracing.Int("rows", len(result)))
+	span.RecordError(err)
+	return result, err
+}
+
+// FindAllByUserIDAndTag traces listing the short URLs of a user marked with a tag.
+func (r *ShortenRepository) FindAllByUserIDAndTag(ctx context.Context, userID int, tag string) ([]models.ShortenData, error) {
+	ctx, span := r.start(ctx, "FindAllByUserIDAndTag", tracing.Int("user_id", userID), tracing.String("tag", tag))
+	defer span.End()
+	result, err := r.ShortenRepository.FindAllByUserIDAndTag(ctx, userID, tag)
+	span.SetAttributes(tracing.Int("rows", len(result)))
+	span.RecordError(err)
+	return result, err
+}
+
+// CountTagsByUserID traces counting the tags of a user.
+func (r *ShortenRepository) CountTagsByUserID(ctx context.Context, userID int) ([]models.TagCount, error) {
+	ctx, span := r.start(ctx, "CountTagsByUserID", tracing.Int("user_id", userID))
+	defer span.End()
+	result, err := r.ShortenRepository.CountTagsByUserID(ctx, userID)
+	span.SetAttributes(tracing.Int("rows", len(result)))
+	span.RecordError(err)
+	return result, err
+}
+
+// DeleteByShortURL traces marking a short URL as deleted.
+func (r *ShortenRepository) DeleteByShortURL(ctx context.Context, shortURL string, userID int) bool {
+	ctx, span := r.start(ctx, "DeleteByShortURL", tracing.String("short_url", shortURL), tracing.Int("user_id", userID))
+	defer span.End()
+	ok := r.ShortenRepository.DeleteByShortURL(ctx, shortURL, userID)
+	span.SetAttributes(tracing.Bool("deleted", ok))
+	return ok
+}
+
+// RestoreByShortURL traces restoring a deleted short URL.
+func (r *ShortenRepository) RestoreByShortURL(ctx context.Context, shortURL string, userID int, deletedAfter time.Time) (bool, error) {
+	ctx, span := r.start(ctx, "RestoreByShortURL", tracing.String("short_url", shortURL), tracing.Int("user_id", userID))
+	defer span.End()
+	ok, err := r.ShortenRepository.RestoreByShortURL(ctx, shortURL, userID, deletedAfter)
+	span.SetAttributes(tracing.Bool("restored", ok))
+	span.RecordError(err)
+	return ok, err
+}
+
+// PurgeDeleted traces removing the expired deleted short URLs.
+func (r *ShortenRepository) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	ctx, span := r.start(ctx, "PurgeDeleted")
+	defer span.End()
+	count, err := r.ShortenRepository.PurgeDeleted(ctx, deletedBefore)
+	span.SetAttributes(tracing.Int("rows", count))
+	span.RecordError(err)
+	return count, err
+}
+
+// UserRepository is a storage.UserRepository starting a span for every call of the wrapped repository.
+type UserRepository struct {
+	storage.UserRepository
+	tracer *tracing.Tracer
+	system string
+}
+
+// NewUserRepository wraps the repository of the given storage type, reporting the spans to tracer.
+func NewUserRepository(repo storage.UserRepository, tracer *tracing.Tracer, system string) *UserRepository {
+	return &UserRepository{
+		UserRepository: repo,
+		tracer:         tracer,
+		system:         system,
+	}
+}
+
+// SaveUser traces storing a new user.
+func (r *UserRepository) SaveUser(ctx context.Context, name, pass string) (int, error) {
+	ctx, span := startSpan(ctx, r.tracer, r.system, "SaveUser")
+	defer span.End()
+	userID, err := r.UserRepository.SaveUser(ctx, name, pass)
+	span.SetAttributes(tracing.Int("user_id", userID))
+	span.RecordError(err)
+	return userID, err
+}
+
+// FindByID traces looking up a user.
+func (r *UserRepository) FindByID(ctx context.Context, userID int) (models.User, error) {
+	ctx, span := startSpan(ctx, r.tracer, r.system, "FindByID", tracing.Int("user_id", userID))
+	defer span.End()
+	user, err := r.UserRepository.FindByID(ctx, userID)
+	span.RecordError(err)
+	return user, err
+}
+
+// startSpan starts the span of a storage operation named after the repository method.
+func startSpan(ctx context.Context, tracer *tracing.Tracer, system, operation string, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	attrs = append(attrs, tracing.String("db.system", system), tracing.String("db.operation", operation))
+	return tracer.Start(ctx, "storage."+operation, attrs...)
+}
@@ -0,0 +1,95 @@
+package traced
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+	"github.com/lookeme/short-url/internal/models"
+	"github.com/lookeme/short-url/internal/storage"
+	"github.com/lookeme/short-url/internal/storage/inmemory"
+	"github.com/lookeme/short-url/internal/storage/storagetest"
+	"github.com/lookeme/short-url/internal/tracing"
+)
+
+// recorder keeps the exported spans in memory.
+type recorder struct {
+	mutex sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *recorder) ExportSpan(data tracing.SpanData) {
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	r.spans = append(r.spans, data)
+}
+
+func (r *recorder) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func newRepository(t *testing.T) storage.ShortenRepository {
+	t.Helper()
+	cfg := &configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+	repo, err := inmemory.NewInMemShortenStorage(cfg, &logger.Logger{Log: zap.NewNop()})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestShortenRepositoryConformance(t *testing.T) {
+	storagetest.RunShortenRepository(t, func(t *testing.T) storage.ShortenRepository {
+		return NewShortenRepository(newRepository(t), tracing.NewTracer(&recorder{}), configuration.StorageMemory)
+	})
+}
+
+func TestUserRepositoryConformance(t *testing.T) {
+	storagetest.RunUserRepository(t, func(t *testing.T) storage.UserRepository {
+		cfg := &configuration.Storage{FileStoragePath: filepath.Join(t.TempDir(), "db.json")}
+		repo, err := inmemory.NewInMemUserStorage(cfg, &logger.Logger{Log: zap.NewNop()})
+		require.NoError(t, err)
+		t.Cleanup(func() { repo.Close() })
+		return NewUserRepository(repo, tracing.NewTracer(&recorder{}), configuration.StorageMemory)
+	})
+}
+
+func TestSpans(t *testing.T) {
+	rec := &recorder{}
+	tracer := tracing.NewTracer(rec)
+	repo := NewShortenRepository(newRepository(t), tracer, configuration.StorageMemory)
+	ctx, request := tracer.Start(context.Background(), "GET /{id}")
+
+	require.NoError(t, repo.SaveAll(ctx, []models.ShortenData{
+		{ShortURL: "http://localhost/a", OriginalURL: "https://a.example", UserID: 1},
+		{ShortURL: "http://localhost/b", OriginalURL: "https://b.example", UserID: 1},
+	}))
+	_, err := repo.FindByKey(ctx, "http://localhost/a")
+	require.NoError(t, err)
+	urls, err := repo.FindAllByUserID(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Error(t, repo.Save(ctx, "http://localhost/c", "https://a.example", 1))
+
+	require.Len(t, rec.spans, 4)
+	for _, span := range rec.spans {
+		assert.Equal(t, request.SpanContext().TraceID, span.SpanContext.TraceID)
+		assert.Equal(t, request.SpanContext().SpanID, span.Parent, "storage spans are children of the request span")
+	}
+	assert.Equal(t, "storage.SaveAll", rec.spans[0].Name)
+	assert.Contains(t, rec.spans[0].Attributes, tracing.Int("rows", 2))
+	assert.Contains(t, rec.spans[0].Attributes, tracing.String("db.system", configuration.StorageMemory))
+	assert.Equal(t, "storage.FindByKey", rec.spans[1].Name)
+	assert.Contains(t, rec.spans[1].Attributes, tracing.String("short_url", "http://localhost/a"))
+	assert.Contains(t, rec.spans[1].Attributes, tracing.Bool("found", true))
+	assert.Equal(t, "storage.FindAllByUserID", rec.spans[2].Name)
+	assert.Contains(t, rec.spans[2].Attributes, tracing.Int("rows", 2))
+	assert.Equal(t, "storage.Save", rec.spans[3].Name)
+	assert.Equal(t, tracing.StatusError, rec.spans[3].Status)
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lookeme/short-url/internal/configuration"
+	"github.com/lookeme/short-url/internal/logger"
+)
+
+// Exporter receives the finished spans of sampled traces.
+type Exporter interface {
+	// ExportSpan is called when a span ends; it must not block the traced operation for long.
+	ExportSpan(data SpanData)
+	// Shutdown flushes the pending spans and releases the exporter.
+	Shutdown(ctx context.Context) error
+}
+
+// Exporters selectable with configuration.TracingCfg.
+const (
+	// ExporterNone disables tracing.
+	ExporterNone = "none"
+	// ExporterStdout writes spans as JSON lines to the standard output.
+	ExporterStdout = "stdout"
+	// ExporterFile writes spans as JSON lines to the file at configuration.TracingCfg.FilePath.
+	ExporterFile = "file"
+	// ExporterOTLP sends spans to the OTLP/HTTP endpoint at configuration.TracingCfg.OTLPEndpoint.
+	ExporterOTLP = "otlp"
+)
+
+// NewExporter creates the exporter selected by the configuration.
+// It returns a nil exporter if tracing is disabled.
+func NewExporter(cfg *configuration.TracingCfg, log *logger.Logger) (Exporter, error) {
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return NewWriterExporter(os.Stdout), nil
+	case ExporterFile:
+		return NewFileExporter(cfg.FilePath)
+	case ExporterOTLP:
+		return NewOTLPExporter(cfg.OTLPEndpoint, cfg.ServiceName, log)
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q", cfg.Exporter)
+	}
+}
+
+// WriterExporter writes every span as a JSON line to a writer, which is handy for local debugging.
+type WriterExporter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewWriterExporter creates an exporter writing to w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{encoder: json.NewEncoder(w)}
+}
+
+// NewFileExporter creates an exporter appending to the file at path, which is closed on Shutdown.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	exporter := NewWriterExporter(file)
+	exporter.closer = file
+	return exporter, nil
+}
+
+// jsonSpan is the JSON line a WriterExporter writes for a span.
+type jsonSpan struct {
+	Name          string         `json:"name"`
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	Duration      string         `json:"duration"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	Status        string         `json:"status"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+// statusNames are the names of the status codes in the JSON lines.
+var statusNames = map[StatusCode]string{
+	StatusUnset: "unset",
+	StatusOK:    "ok",
+	StatusError: "error",
+}
+
+// ExportSpan writes the span as a JSON line.
+func (e *WriterExporter) ExportSpan(data SpanData) {
+	span := jsonSpan{
+		Name:          data.Name,
+		TraceID:       data.SpanContext.TraceID.String(),
+		SpanID:        data.SpanContext.SpanID.String(),
+		Start:         data.Start,
+		End:           data.End,
+		Duration:      data.End.Sub(data.Start).String(),
+		Status:        statusNames[data.Status],
+		StatusMessage: data.StatusMessage,
+	}
+	if data.Parent.IsValid() {
+		span.ParentSpanID = data.Parent.String()
+	}
+	if len(data.Attributes) > 0 {
+		span.Attributes = make(map[string]any, len(data.Attributes))
+		for _, attr := range data.Attributes {
+			span.Attributes[attr.Key] = attr.Value
+		}
+	}
+	defer e.mutex.Unlock()
+	e.mutex.Lock()
+	_ = e.encoder.Encode(span)
+}
+
+// Shutdown closes the file of the exporter, if any.
+func (e *WriterExporter) Shutdown(_ context.Context) error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Middleware starts a server span for every request with the Default tracer and stores it in the request context,
+// continuing the trace of an incoming traceparent header. The span is named by the chi route pattern, e.g. GET /{id},
+// so it must be installed on a chi router; requests which didn't match any route are named by the method only.
+// Responses with a 5xx status mark the span as failed.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(Extract(r.Context(), r.Header), r.Method,
+			String("http.method", r.Method),
+			String("http.target", r.URL.Path),
+			String("http.user_agent", r.UserAgent()),
+		)
+		span.SetKind(SpanKindServer)
+		defer span.End()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(String("http.route", rctx.RoutePattern()))
+		}
+		span.SetAttributes(Int("http.status_code", sw.status()))
+		if sw.status() >= http.StatusInternalServerError {
+			span.SetStatus(StatusError, http.StatusText(sw.status()))
+		}
+	})
+}
+
+// statusWriter captures the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+// WriteHeader captures the status code and writes it to the response.
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.code == 0 {
+		w.code = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the body, implying 200 OK if no status was written.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// status returns the captured status code; a handler which wrote nothing responded with 200 OK.
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
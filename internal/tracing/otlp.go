@@ -0,0 +1,253 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/logger"
+)
+
+const (
+	// otlpQueueSize is the number of spans buffered for sending; spans beyond it are dropped.
+	otlpQueueSize = 2048
+	// otlpBatchSize is the maximum number of spans sent in a single request.
+	otlpBatchSize = 512
+	// otlpFlushInterval is how long a span waits for a batch to fill up before it is sent anyway.
+	otlpFlushInterval = 5 * time.Second
+	// otlpTimeout bounds a single request to the collector.
+	otlpTimeout = 10 * time.Second
+	// otlpTracesPath is the path spans are posted to when the endpoint has none.
+	otlpTracesPath = "/v1/traces"
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector using OTLP/HTTP with JSON encoding.
+// Spans are queued and sent in the background, so a slow collector never delays a request;
+// when the queue is full, new spans are dropped.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	log         *logger.Logger
+	spans       chan SpanData
+	stop        chan struct{}
+	done        chan struct{}
+	stopOnce    sync.Once
+	dropped     atomic.Uint64
+}
+
+// NewOTLPExporter creates an exporter sending to the collector at endpoint, e.g. http://localhost:4318,
+// and starts its background sender. The spans are reported on behalf of the given service.
+func NewOTLPExporter(endpoint, serviceName string, log *logger.Logger) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+	e := &OTLPExporter{
+		endpoint:    u.String(),
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: otlpTimeout},
+		log:         log,
+		spans:       make(chan SpanData, otlpQueueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// ExportSpan queues the span for sending.
+func (e *OTLPExporter) ExportSpan(data SpanData) {
+	select {
+	case e.spans <- data:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of spans dropped because the queue was full.
+func (e *OTLPExporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Shutdown sends the queued spans and stops the background sender.
+// It returns the context error if the spans couldn't be sent in time.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects the queued spans into batches and sends them until the exporter is shut down.
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, otlpBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.log.Log.Error("error during exporting spans", zap.Int("spans", len(batch)), zap.Error(err))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case data := <-e.spans:
+			if batch = append(batch, data); len(batch) == otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case data := <-e.spans:
+					if batch = append(batch, data); len(batch) == otlpBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts a batch of spans to the collector.
+func (e *OTLPExporter) send(batch []SpanData) error {
+	body, err := json.Marshal(e.request(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below are the subset of the OTLP/JSON trace request used by the exporter,
+// see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// otlpKinds are the OTLP values of the span kinds.
+var otlpKinds = map[SpanKind]int{
+	SpanKindInternal: 1,
+	SpanKindServer:   2,
+}
+
+// request converts a batch of spans to an OTLP request.
+func (e *OTLPExporter) request(batch []SpanData) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, data := range batch {
+		span := otlpSpan{
+			TraceID:           data.SpanContext.TraceID.String(),
+			SpanID:            data.SpanContext.SpanID.String(),
+			Name:              data.Name,
+			Kind:              otlpKinds[data.Kind],
+			StartTimeUnixNano: strconv.FormatInt(data.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(data.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(data.Attributes),
+			Status:            otlpStatus{Code: int(data.Status), Message: data.StatusMessage},
+		}
+		if data.Parent.IsValid() {
+			span.ParentSpanID = data.Parent.String()
+		}
+		spans = append(spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attribute{String("service.name", e.serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/lookeme/short-url/internal/tracing"}, Spans: spans}},
+	}}}
+}
+
+// otlpAttributes converts the attributes to OTLP key-values.
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	result := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		var value otlpValue
+		switch v := attr.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.IntValue = &s
+		case bool:
+			value.BoolValue = &v
+		case float64:
+			value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		result = append(result, otlpKeyValue{Key: attr.Key, Value: value})
+	}
+	return result
+}
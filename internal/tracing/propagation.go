@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+)
+
+// TraceparentHeader is the W3C Trace Context header carrying the span context between processes.
+const TraceparentHeader = "traceparent"
+
+// traceparentLen is the length of a version 00 traceparent: 00-<trace id>-<span id>-<flags>.
+const traceparentLen = 55
+
+// sampledFlag is the trace flag of a sampled trace.
+const sampledFlag = 0x01
+
+// ParseTraceparent parses the value of a traceparent header as described by https://www.w3.org/TR/trace-context/.
+// It reports false if the value is malformed or carries an all-zero ID.
+// Values of future versions are accepted as long as they start like a version 00 value.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	if len(value) < traceparentLen || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return SpanContext{}, false
+	}
+	version, ok := decodeHex(value[:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(value) != traceparentLen) {
+		return SpanContext{}, false
+	}
+	if len(value) > traceparentLen && value[traceparentLen] != '-' {
+		return SpanContext{}, false
+	}
+	traceID, ok := decodeHex(value[3:35])
+	if !ok {
+		return SpanContext{}, false
+	}
+	spanID, ok := decodeHex(value[36:52])
+	if !ok {
+		return SpanContext{}, false
+	}
+	flags, ok := decodeHex(value[53:55])
+	if !ok {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&sampledFlag != 0
+	return sc, sc.IsValid()
+}
+
+// FormatTraceparent formats the span context as the value of a version 00 traceparent header.
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Extract returns a copy of ctx holding the span context of the traceparent header,
+// or ctx itself if the header is missing or malformed.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := ParseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Inject sets the traceparent header to the span context stored in ctx, so a downstream service continues the trace.
+func Inject(ctx context.Context, header http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		header.Set(TraceparentHeader, FormatTraceparent(sc))
+	}
+}
+
+// decodeHex decodes a lowercase hex string as required by the W3C Trace Context.
+func decodeHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
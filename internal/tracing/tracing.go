@@ -0,0 +1,338 @@
+// Package tracing implements distributed tracing of requests in the spirit of OpenTelemetry.
+// A request is traced by a tree of spans: the HTTP middleware starts the root span, and every span started
+// with the context of a request becomes a child of the span stored in it, down to the storage calls.
+// Finished spans are passed to the Exporter of the Tracer, see NewExporter.
+//
+// Example usage:
+//
+//	ctx, span := tracing.Start(ctx, "FindByKey", tracing.String("short_url", key))
+//	defer span.End()
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Default is the tracer used by Start and the HTTP middleware.
+// It exports nothing until an exporter is set with SetExporter.
+var Default = NewTracer(nil)
+
+// TraceID identifies all spans of a single trace.
+type TraceID [16]byte
+
+// String returns the lowercase hex representation of the ID.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid reports whether the ID is not all zeros.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// SpanID identifies a single span of a trace.
+type SpanID [8]byte
+
+// String returns the lowercase hex representation of the ID.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid reports whether the ID is not all zeros.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext is the part of a span propagated to its children, also across processes, see Extract and Inject.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled reports whether the spans of the trace are exported.
+	Sampled bool
+}
+
+// IsValid reports whether both IDs of the span context are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Attribute is a key-value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String creates a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int creates an integer attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+// Int64 creates an integer attribute.
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool creates a boolean attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// StatusCode is the outcome of the operation traced by a span.
+type StatusCode int
+
+// Status codes of a span.
+const (
+	// StatusUnset is the status of a span nobody marked as failed.
+	StatusUnset StatusCode = iota
+	// StatusOK marks a span explicitly as successful.
+	StatusOK
+	// StatusError marks a failed span.
+	StatusError
+)
+
+// SpanKind tells the role of a span in a trace.
+type SpanKind int
+
+// Span kinds.
+const (
+	// SpanKindInternal is an operation within the service.
+	SpanKindInternal SpanKind = iota
+	// SpanKindServer is the handling of a request received from a client.
+	SpanKindServer
+)
+
+// SpanData is a snapshot of a finished span passed to the exporter.
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span traces a single operation.
+// All methods are safe to call on a nil span, so code doesn't need to check whether it is traced.
+type Span struct {
+	tracer *Tracer
+	mutex  sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext returns the span context of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetName replaces the name of the span, e.g. once the route of a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	s.data.Name = name
+}
+
+// SetKind sets the kind of the span.
+func (s *Span) SetKind(kind SpanKind) {
+	if s == nil {
+		return
+	}
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	s.data.Kind = kind
+}
+
+// SetAttributes adds the attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// SetStatus sets the status of the span along with a description of an error.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+	s.data.Status = code
+	s.data.StatusMessage = message
+}
+
+// RecordError marks the span as failed with the given error; a nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End finishes the span and passes it to the exporter if the trace is sampled.
+// Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mutex.Unlock()
+	if data.SpanContext.Sampled {
+		s.tracer.export(data)
+	}
+}
+
+// Tracer creates spans and passes the finished ones to its exporter.
+type Tracer struct {
+	mutex    sync.RWMutex
+	exporter Exporter
+}
+
+// NewTracer creates a tracer exporting spans to the given exporter; a nil exporter disables exporting.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// SetExporter replaces the exporter of the tracer; a nil exporter disables exporting.
+func (t *Tracer) SetExporter(exporter Exporter) {
+	defer t.mutex.Unlock()
+	t.mutex.Lock()
+	t.exporter = exporter
+}
+
+// Enabled reports whether the tracer exports spans.
+func (t *Tracer) Enabled() bool {
+	defer t.mutex.RUnlock()
+	t.mutex.RLock()
+	return t.exporter != nil
+}
+
+// Shutdown flushes and closes the exporter of the tracer.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mutex.RLock()
+	exporter := t.exporter
+	t.mutex.RUnlock()
+	if exporter == nil {
+		return nil
+	}
+	return exporter.Shutdown(ctx)
+}
+
+// Start starts a span which is a child of the span or the remote span context stored in ctx.
+// Without a parent a new trace is started, sampled when the tracer exports spans.
+// It returns a copy of ctx holding the new span, which must be ended by the caller.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.Enabled()
+	}
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:        name,
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Start:       time.Now(),
+			Attributes:  attrs,
+		},
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// export passes the finished span to the exporter.
+func (t *Tracer) export(data SpanData) {
+	t.mutex.RLock()
+	exporter := t.exporter
+	t.mutex.RUnlock()
+	if exporter != nil {
+		exporter.ExportSpan(data)
+	}
+}
+
+// Start starts a span with the Default tracer, see Tracer.Start.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return Default.Start(ctx, name, attrs...)
+}
+
+// spanKey is the context key of the current span.
+type spanKey struct{}
+
+// remoteKey is the context key of the span context received from another process.
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of ctx holding the span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span stored in ctx or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx holding the span context received from another process,
+// so the spans started with it continue the remote trace.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span stored in ctx,
+// or the remote span context if there is no current span.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// newTraceID generates a random trace ID.
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// newSpanID generates a random span ID.
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,238 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lookeme/short-url/internal/logger"
+)
+
+// recorder keeps the exported spans in memory.
+type recorder struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) ExportSpan(data SpanData) {
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	r.spans = append(r.spans, data)
+}
+
+func (r *recorder) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func (r *recorder) exported() []SpanData {
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	return append([]SpanData(nil), r.spans...)
+}
+
+// attributes collects the attributes of the span into a map.
+func attributes(data SpanData) map[string]any {
+	result := make(map[string]any, len(data.Attributes))
+	for _, attr := range data.Attributes {
+		result[attr.Key] = attr.Value
+	}
+	return result
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{name: "sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ok: true, sampled: true},
+		{name: "not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", ok: true},
+		{name: "future version with more fields", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", ok: true, sampled: true},
+		{name: "version 00 with more fields", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "forbidden version", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "uppercase", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "short", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+		{name: "bad separator", value: "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "empty", value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+			assert.Equal(t, tt.ok, ok)
+			if !tt.ok {
+				return
+			}
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+			assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+			assert.Equal(t, tt.sampled, sc.Sampled)
+		})
+	}
+
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", FormatTraceparent(sc))
+}
+
+func TestStart(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec)
+
+	ctx, root := tracer.Start(context.Background(), "root", String("key", "value"))
+	_, child := tracer.Start(ctx, "child")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	root.End()
+	root.End()
+
+	spans := rec.exported()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, root.SpanContext().TraceID, spans[0].SpanContext.TraceID)
+	assert.Equal(t, root.SpanContext().SpanID, spans[0].Parent)
+	assert.Equal(t, StatusError, spans[0].Status)
+	assert.Equal(t, "boom", spans[0].StatusMessage)
+	assert.Equal(t, "root", spans[1].Name)
+	assert.False(t, spans[1].Parent.IsValid())
+	assert.True(t, spans[1].SpanContext.Sampled)
+	assert.Equal(t, map[string]any{"key": "value"}, attributes(spans[1]))
+	assert.False(t, spans[1].End.Before(spans[1].Start))
+
+	_, span := NewTracer(nil).Start(context.Background(), "not exported")
+	assert.True(t, span.SpanContext().IsValid(), "spans get IDs even when they are not exported")
+	assert.False(t, span.SpanContext().Sampled)
+	span.End()
+
+	var nilSpan *Span
+	nilSpan.SetAttributes(String("key", "value"))
+	nilSpan.End()
+	assert.Nil(t, SpanFromContext(context.Background()))
+}
+
+func TestMiddleware(t *testing.T) {
+	rec := &recorder{}
+	Default.SetExporter(rec)
+	defer Default.SetExporter(nil)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "storage.FindByKey")
+		span.End()
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	r.Get("/fail", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	spans := rec.exported()
+	require.Len(t, spans, 2)
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "GET /{id}", server.Name)
+	assert.Equal(t, SpanKindServer, server.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.String(), "the incoming traceparent is the parent")
+	assert.Equal(t, "/{id}", attributes(server)["http.route"])
+	assert.Equal(t, int64(http.StatusTemporaryRedirect), attributes(server)["http.status_code"])
+	assert.Equal(t, StatusUnset, server.Status)
+	assert.Equal(t, server.SpanContext.SpanID, child.Parent, "handler spans are children of the request span")
+	assert.Equal(t, server.SpanContext.TraceID, child.SpanContext.TraceID)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	spans = rec.exported()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "GET /fail", spans[2].Name)
+	assert.Equal(t, StatusError, spans[2].Status)
+	assert.False(t, spans[2].Parent.IsValid(), "a request without traceparent starts a new trace")
+
+	req = httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Len(t, rec.exported(), 3, "traces not sampled by the caller aren't exported")
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(NewWriterExporter(&buf))
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, span := tracer.Start(ctx, "storage.FindByKey", String("short_url", "http://localhost/a"), Int("rows", 1), Bool("found", true))
+	span.End()
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "storage.FindByKey", line["name"])
+	assert.Equal(t, parent.SpanContext().TraceID.String(), line["trace_id"])
+	assert.Equal(t, parent.SpanContext().SpanID.String(), line["parent_span_id"])
+	assert.Equal(t, span.SpanContext().SpanID.String(), line["span_id"])
+	assert.Equal(t, "unset", line["status"])
+	assert.Equal(t, map[string]any{"short_url": "http://localhost/a", "rows": float64(1), "found": true}, line["attributes"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan map[string]any, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var request map[string]any
+		require.NoError(t, json.Unmarshal(body, &request))
+		requests <- request
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(collector.URL, "short-url", &logger.Logger{Log: zap.NewNop()})
+	require.NoError(t, err)
+	tracer := NewTracer(exporter)
+	ctx, server := tracer.Start(context.Background(), "GET /{id}", Int("http.status_code", 307))
+	server.SetKind(SpanKindServer)
+	_, child := tracer.Start(ctx, "storage.FindByKey")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	server.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, exporter.Shutdown(shutdownCtx), "shutdown flushes the queued spans")
+	var request map[string]any
+	select {
+	case request = <-requests:
+	default:
+		t.Fatal("no spans were sent")
+	}
+
+	resourceSpans := request["resourceSpans"].([]any)[0].(map[string]any)
+	resource := resourceSpans["resource"].(map[string]any)
+	assert.Equal(t, []any{map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "short-url"}}}, resource["attributes"])
+	spans := resourceSpans["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	require.Len(t, spans, 2)
+	first, second := spans[0].(map[string]any), spans[1].(map[string]any)
+	assert.Equal(t, "storage.FindByKey", first["name"])
+	assert.Equal(t, float64(1), first["kind"])
+	assert.Equal(t, server.SpanContext().SpanID.String(), first["parentSpanId"])
+	assert.Equal(t, map[string]any{"code": float64(2), "message": "boom"}, first["status"])
+	assert.Equal(t, "GET /{id}", second["name"])
+	assert.Equal(t, float64(2), second["kind"])
+	assert.Equal(t, server.SpanContext().TraceID.String(), second["traceId"])
+	assert.Equal(t, []any{map[string]any{"key": "http.status_code", "value": map[string]any{"intValue": "307"}}}, second["attributes"])
+
+	_, err = NewOTLPExporter("localhost:4318", "short-url", &logger.Logger{Log: zap.NewNop()})
+	assert.Error(t, err)
+}
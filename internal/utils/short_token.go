@@ -10,8 +10,6 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-
-	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // ShortToken - interface for short token creation
@@ -80,13 +78,3 @@ func GetToken(str string) (string, error) {
 func CreateShortURL(key string, baseURL string) string {
 	return fmt.Sprintf("%s/%s", baseURL, key)
 }
-
-// ErrorCode - compare errors
-func ErrorCode(err error) string {
-	var pgerr *pgconn.PgError
-	ok := errors.As(err, &pgerr)
-	if !ok {
-		return ""
-	}
-	return pgerr.Code
-}